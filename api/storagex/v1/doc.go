@@ -0,0 +1,5 @@
+// Package storagexv1 holds the gRPC API of storagex, generated from storagex.proto with
+// buf. Clients in other modules can import it directly.
+package storagexv1
+
+//go:generate sh -c "cd ../.. && buf generate"
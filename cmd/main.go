@@ -1,20 +1,233 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/sayuyere/storageX/internal/app"
+	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/dav"
+	"github.com/sayuyere/storageX/internal/defaults"
+	"github.com/sayuyere/storageX/internal/gateway"
 	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+	"github.com/sayuyere/storageX/internal/rpc"
+	"github.com/sayuyere/storageX/internal/server"
+	"github.com/sayuyere/storageX/internal/storage"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc"
 )
 
-var cfgFile string
+var (
+	cfgFile  string
+	timeout  time.Duration
+	services *app.ServiceBundle
+)
 
-func main() {
-	var services *app.ServiceBundle
+// serveShutdownGrace is how long servers wait for requests in flight when interrupted
+const serveShutdownGrace = 30 * time.Second
+
+// serveIdleTimeout closes keep-alive connections that have not sent a request for this long
+const serveIdleTimeout = 2 * time.Minute
+
+// metricsRefreshTimeout bounds one round of free space queries to the backends
+const metricsRefreshTimeout = 30 * time.Second
+
+// commandContext bounds a command by the --timeout flag on top of the interrupt context
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(cmd.Context(), timeout)
+	}
+	return context.WithCancel(cmd.Context())
+}
+
+// serveUntilDone serves handler on addr until ctx ends, then gives requests in flight
+// serveShutdownGrace to finish before their uploads are rolled back
+func serveUntilDone(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 30 * time.Second, IdleTimeout: serveIdleTimeout}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownGrace)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// serveGRPCUntilDone is serveUntilDone for a gRPC server: when ctx ends, calls in flight
+// get serveShutdownGrace to finish before they are cut off
+func serveGRPCUntilDone(ctx context.Context, addr string, srv *grpc.Server) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(lis) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(serveShutdownGrace):
+		srv.Stop()
+	}
+	return nil
+}
+
+// fail reports a failed command and exits
+func fail(action string, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		fmt.Fprintf(os.Stderr, "%s interrupted: %v\n", action, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "%s timed out: %v\n", action, err)
+	default:
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", action, err)
+	}
+	dumpMetrics()
+	os.Exit(1)
+}
+
+// dumpMetrics writes the metrics of the command to the configured textfile, after asking
+// every backend for its free space
+func dumpMetrics() {
+	if services == nil || services.Config.Metrics.Textfile == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), metricsRefreshTimeout)
+	services.Manager.RefreshCapacity(ctx)
+	cancel()
+	if err := metrics.WriteTextfile(services.Config.Metrics.Textfile); err != nil {
+		fmt.Fprintf(os.Stderr, "Writing metrics failed: %v\n", err)
+	}
+}
+
+// serveMetrics refreshes the free space of every backend until ctx ends and, when addr
+// is set, serves GET /metrics on it
+func serveMetrics(ctx context.Context, addr string) {
+	go func() {
+		ticker := time.NewTicker(time.Duration(services.Config.Metrics.CapacityRefreshSeconds) * time.Second)
+		defer ticker.Stop()
+		for {
+			refreshCtx, cancel := context.WithTimeout(ctx, metricsRefreshTimeout)
+			services.Manager.RefreshCapacity(refreshCtx)
+			cancel()
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	log.Info("Serving metrics on %s", addr)
+	go func() {
+		if err := serveUntilDone(ctx, addr, mux); err != nil {
+			log.Error("Metrics server failed: %v", err)
+		}
+	}()
+}
+
+// writeOutput prints v as indented JSON, or through table as tab-aligned columns
+func writeOutput(format string, v interface{}, table func(w io.Writer)) {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			fmt.Fprintf(os.Stderr, "Encoding output failed: %v\n", err)
+			os.Exit(1)
+		}
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		table(w)
+		w.Flush()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown output format %q: use json or table\n", format)
+		os.Exit(1)
+	}
+}
+
+// fileEntry is one file listed by ls
+type fileEntry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Version  int       `json:"version"`
+	Modified time.Time `json:"modified"`
+}
 
+// fileStat is the stat output of a file's current version
+type fileStat struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	StoredSize int64     `json:"stored_size"` // distinct objects, parity included
+	Version    int       `json:"version"`
+	Versions   int       `json:"versions"`
+	Created    time.Time `json:"created"`  // first version
+	Modified   time.Time `json:"modified"` // current version
+	Mode       string    `json:"mode"`
+	Encrypted  bool      `json:"encrypted"`
+	Chunks     int       `json:"chunks"`
+	Backends   []string  `json:"backends"`
+	Checksums  []string  `json:"checksums"` // SHA-256 of each chunk, in order
+}
+
+// chunkEntry is one chunk listed by the chunks command
+type chunkEntry struct {
+	Index      int      `json:"index"`
+	Name       string   `json:"name"`
+	Size       int64    `json:"size"`
+	StoredSize int64    `json:"stored_size"`
+	Codec      string   `json:"codec"`
+	Checksum   string   `json:"checksum"`
+	Replicas   []string `json:"replicas"`
+}
+
+// currentVersion returns the current version of fileName; files recorded before
+// versioning have none and are read under their own name
+func currentVersion(meta *metadata.MetadataService, fileName string) (metadata.FileVersion, bool) {
+	if ver, ok := meta.GetVersion(fileName, 0); ok {
+		return ver, true
+	}
+	file, ok := meta.GetFile(fileName)
+	if !ok {
+		return metadata.FileVersion{}, false
+	}
+	return metadata.FileVersion{FileName: fileName, Version: file.Version, StorageName: fileName, TotalSize: file.TotalSize,
+		WrappedKey: file.WrappedKey, CreatedAt: file.ModifiedAt, Current: true}, true
+}
+
+func main() {
 	log.InitLogger(true)
 
 	rootCmd := &cobra.Command{
@@ -42,7 +255,9 @@ func main() {
 		},
 	}
 
+	rootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) { dumpMetrics() }
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (required)")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "abort the command after this long, e.g. 30s or 10m (default no limit)")
 	rootCmd.MarkPersistentFlagRequired("config")
 
 	rootCmd.AddCommand(
@@ -55,9 +270,22 @@ func main() {
 		},
 	)
 
-	rootCmd.AddCommand(&cobra.Command{
-		Use:   "upload [file]",
-		Short: "Upload a file to cloud storage",
+	var (
+		uploadMode         string
+		uploadDataShards   int
+		uploadParityShards int
+		uploadCompression  string
+		uploadResume       bool
+		uploadRecursive    bool
+		uploadAs           string
+		uploadPrefix       string
+		uploadFollow       bool
+		uploadInclude      []string
+		uploadExclude      []string
+	)
+	uploadCmd := &cobra.Command{
+		Use:   "upload [file|directory]",
+		Short: "Upload a file, or a directory tree with -r, to cloud storage",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			filePath := args[0]
@@ -66,17 +294,86 @@ func main() {
 				fmt.Fprintln(os.Stderr, "Services not initialized")
 				os.Exit(1)
 			}
-			if err := services.Storage.UploadFile(filePath); err != nil {
-				fmt.Fprintf(os.Stderr, "Upload failed: %v\n", err)
-				os.Exit(1)
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			if uploadResume && !uploadRecursive {
+				// The options recorded when the upload started are reused
+				if err := services.Storage.ResumeUploadAsContext(ctx, filePath, uploadAs); err != nil {
+					fail("Resume", err)
+				}
+				fmt.Println("Upload successful!")
+				return
+			}
+			opts := storage.DefaultUploadOptions()
+			if cmd.Flags().Changed("mode") {
+				opts.Mode = uploadMode
+			}
+			if cmd.Flags().Changed("data-shards") {
+				opts.DataShards = uploadDataShards
+			}
+			if cmd.Flags().Changed("parity-shards") {
+				opts.ParityShards = uploadParityShards
+			}
+			if cmd.Flags().Changed("compress") {
+				opts.Compression = uploadCompression
+			}
+			if uploadRecursive {
+				dirOpts := storage.DirOptions{
+					Upload:   opts,
+					Prefix:   uploadPrefix,
+					Include:  uploadInclude,
+					Exclude:  uploadExclude,
+					Symlinks: storage.SymlinkSkip,
+					Resume:   uploadResume,
+				}
+				if uploadFollow {
+					dirOpts.Symlinks = storage.SymlinkFollow
+				}
+				stored, err := services.Storage.UploadDirContext(ctx, filePath, dirOpts)
+				for _, name := range stored {
+					fmt.Println("Uploaded:", name)
+				}
+				if err != nil {
+					fail("Upload", err)
+				}
+				fmt.Printf("Upload successful! %d files stored\n", len(stored))
+				return
+			}
+			// On interrupt the workers stop taking chunks and what was stored is rolled back
+			var err error
+			if uploadAs != "" {
+				err = services.Storage.UploadFileAsContext(ctx, filePath, uploadAs, opts)
+			} else {
+				err = services.Storage.UploadFileWithOptionsContext(ctx, filePath, opts)
+			}
+			if err != nil {
+				fail("Upload", err)
 			}
 			fmt.Println("Upload successful!")
 		},
-	})
+	}
+	uploadCmd.Flags().StringVar(&uploadMode, "mode", "", "storage mode: replicate or erasure (default from config)")
+	uploadCmd.Flags().IntVar(&uploadDataShards, "data-shards", 0, "erasure coding: chunks per stripe (default from config)")
+	uploadCmd.Flags().IntVar(&uploadParityShards, "parity-shards", 0, "erasure coding: parity shards per stripe (default from config)")
+	uploadCmd.Flags().StringVar(&uploadCompression, "compress", "", "chunk compression: none, zstd, gzip, lz4 or auto (default from config)")
+	uploadCmd.Flags().BoolVar(&uploadResume, "resume", false, "finish an interrupted upload of this file, skipping chunks already stored")
+	uploadCmd.Flags().BoolVarP(&uploadRecursive, "recursive", "r", false, "upload a directory tree, keeping relative paths")
+	uploadCmd.Flags().StringVar(&uploadAs, "as", "", "store a single file under this path, e.g. docs/report.pdf (default its base name)")
+	uploadCmd.Flags().StringVar(&uploadPrefix, "prefix", "", "with -r: directory to store the tree under (default the local directory's name)")
+	uploadCmd.Flags().BoolVar(&uploadFollow, "follow-symlinks", false, "with -r: upload symlink targets instead of skipping links")
+	uploadCmd.Flags().StringSliceVar(&uploadInclude, "include", nil, "with -r: only upload files matching these glob patterns")
+	uploadCmd.Flags().StringSliceVar(&uploadExclude, "exclude", nil, "with -r: skip files and directories matching these glob patterns")
+	rootCmd.AddCommand(uploadCmd)
 
-	rootCmd.AddCommand(&cobra.Command{
-		Use:   "download [file] [output]",
-		Short: "Download a file from cloud storage",
+	var (
+		downloadRecursive bool
+		downloadInclude   []string
+		downloadExclude   []string
+		downloadVersion   int
+	)
+	downloadCmd := &cobra.Command{
+		Use:   "download [file|directory] [output]",
+		Short: "Download a file, or a directory tree with -r, from cloud storage",
 		Args:  cobra.ExactArgs(2),
 		Run: func(cmd *cobra.Command, args []string) {
 			fileName := args[0]
@@ -86,19 +383,47 @@ func main() {
 				fmt.Fprintln(os.Stderr, "Services not initialized")
 				os.Exit(1)
 			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			if downloadRecursive {
+				opts := storage.DirOptions{Include: downloadInclude, Exclude: downloadExclude}
+				written, err := services.Storage.DownloadDirContext(ctx, fileName, output, opts)
+				for _, path := range written {
+					fmt.Println("Downloaded:", path)
+				}
+				if err != nil {
+					fail("Download", err)
+				}
+				fmt.Printf("Download successful! %d files written\n", len(written))
+				return
+			}
+			reader, err := services.Storage.NewFileVersionReaderContext(ctx, fileName, downloadVersion)
+			if err != nil {
+				fail("Download", err)
+			}
+			defer reader.Close()
 			file, err := os.Create(output)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to create output file: %v\n", err)
 				os.Exit(1)
 			}
-			// TODO: implement services.Storage.GetFile(fileName, output)
-			err = services.Storage.GetFile(fileName, file)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
+			// Chunks are written as they arrive; memory stays bounded by the download window
+			if _, err := io.Copy(file, reader); err != nil {
+				file.Close()
+				os.Remove(output)
+				fail("Download", err)
+			}
+			if err := file.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write output file: %v\n", err)
 				os.Exit(1)
 			}
 		},
-	})
+	}
+	downloadCmd.Flags().BoolVarP(&downloadRecursive, "recursive", "r", false, "download every file below a directory into the output directory")
+	downloadCmd.Flags().StringSliceVar(&downloadInclude, "include", nil, "with -r: only download files matching these glob patterns")
+	downloadCmd.Flags().StringSliceVar(&downloadExclude, "exclude", nil, "with -r: skip files and directories matching these glob patterns")
+	downloadCmd.Flags().IntVar(&downloadVersion, "version", 0, "download this version of the file instead of the current one")
+	rootCmd.AddCommand(downloadCmd)
 
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "delete [file]",
@@ -111,14 +436,546 @@ func main() {
 				fmt.Fprintln(os.Stderr, "Services not initialized")
 				os.Exit(1)
 			}
-			if err := services.Storage.DeleteFile(fileName); err != nil {
-				fmt.Fprintf(os.Stderr, "Delete failed: %v\n", err)
-				os.Exit(1)
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			if err := services.Storage.DeleteFileContext(ctx, fileName); err != nil {
+				fail("Delete", err)
 			}
 			fmt.Println("Delete successful!")
 		},
 	})
 
+	var (
+		lsSort    string
+		lsReverse bool
+		lsOutput  string
+	)
+	lsCmd := &cobra.Command{
+		Use:   "ls [prefix]",
+		Short: "List stored files, optionally only those whose path starts with prefix",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			files, err := services.Metadata.ListFiles()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Listing files failed: %v\n", err)
+				os.Exit(1)
+			}
+			entries := []fileEntry{}
+			for _, file := range files {
+				if len(args) == 1 && !strings.HasPrefix(file.FileName, args[0]) {
+					continue
+				}
+				entries = append(entries, fileEntry{Name: file.FileName, Size: file.TotalSize, Version: file.Version, Modified: file.ModifiedAt})
+			}
+			var less func(a, b fileEntry) bool
+			switch lsSort {
+			case "name":
+				less = func(a, b fileEntry) bool { return a.Name < b.Name }
+			case "size":
+				less = func(a, b fileEntry) bool { return a.Size < b.Size || a.Size == b.Size && a.Name < b.Name }
+			case "time":
+				less = func(a, b fileEntry) bool {
+					return a.Modified.Before(b.Modified) || a.Modified.Equal(b.Modified) && a.Name < b.Name
+				}
+			default:
+				fmt.Fprintf(os.Stderr, "Unknown sort key %q: use name, size or time\n", lsSort)
+				os.Exit(1)
+			}
+			sort.Slice(entries, func(i, j int) bool {
+				if lsReverse {
+					return less(entries[j], entries[i])
+				}
+				return less(entries[i], entries[j])
+			})
+			writeOutput(lsOutput, entries, func(w io.Writer) {
+				fmt.Fprintln(w, "SIZE\tVERSION\tMODIFIED\tNAME")
+				for _, e := range entries {
+					fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", e.Size, e.Version, e.Modified.Format(time.RFC3339), e.Name)
+				}
+			})
+		},
+	}
+	lsCmd.Flags().StringVar(&lsSort, "sort", "name", "sort by name, size or time")
+	lsCmd.Flags().BoolVar(&lsReverse, "reverse", false, "reverse the sort order")
+	lsCmd.Flags().StringVarP(&lsOutput, "output", "o", "table", "output format: json or table")
+	rootCmd.AddCommand(lsCmd)
+
+	var statOutput string
+	statCmd := &cobra.Command{
+		Use:   "stat [file]",
+		Short: "Show size, versions, timestamps, backends and chunk checksums of a file",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ver, ok := currentVersion(services.Metadata, args[0])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "No such file: %s\n", args[0])
+				os.Exit(1)
+			}
+			chunks, err := services.Metadata.ListChunks(ver.StorageName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Listing chunks failed: %v\n", err)
+				os.Exit(1)
+			}
+			stripes, err := services.Metadata.ListStripes(ver.StorageName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Listing stripes failed: %v\n", err)
+				os.Exit(1)
+			}
+			versions, _ := services.Metadata.ListVersions(args[0])
+			st := fileStat{
+				Name:      ver.FileName,
+				Size:      ver.TotalSize,
+				Version:   ver.Version,
+				Versions:  len(versions),
+				Created:   ver.CreatedAt,
+				Modified:  ver.CreatedAt,
+				Mode:      string(storage.ModeReplicate),
+				Encrypted: ver.WrappedKey != nil,
+				Chunks:    len(chunks),
+				Backends:  []string{},
+				Checksums: []string{},
+			}
+			if len(versions) > 0 {
+				st.Created = versions[len(versions)-1].CreatedAt
+			}
+			if len(stripes) > 0 {
+				st.Mode = string(storage.ModeErasure)
+			}
+			backends, objects := make(map[string]bool), make(map[string]bool)
+			addBackend := func(id string) {
+				if !backends[id] {
+					backends[id] = true
+					st.Backends = append(st.Backends, id)
+				}
+			}
+			for _, c := range chunks {
+				st.Checksums = append(st.Checksums, hex.EncodeToString([]byte(c.Checksum)))
+				for _, id := range c.Replicas {
+					addBackend(id)
+				}
+				// A chunk repeated within the file is stored once
+				if !objects[c.ChunkName] {
+					objects[c.ChunkName] = true
+					st.StoredSize += c.StoredSize * int64(len(c.Replicas))
+				}
+			}
+			for _, stripe := range stripes {
+				for _, parity := range stripe.Parity {
+					addBackend(parity.Storage)
+					st.StoredSize += stripe.ShardSize
+				}
+			}
+			sort.Strings(st.Backends)
+			writeOutput(statOutput, st, func(w io.Writer) {
+				fmt.Fprintf(w, "Name:\t%s\n", st.Name)
+				fmt.Fprintf(w, "Size:\t%d\n", st.Size)
+				fmt.Fprintf(w, "Stored size:\t%d\n", st.StoredSize)
+				fmt.Fprintf(w, "Version:\t%d of %d\n", st.Version, st.Versions)
+				fmt.Fprintf(w, "Created:\t%s\n", st.Created.Format(time.RFC3339))
+				fmt.Fprintf(w, "Modified:\t%s\n", st.Modified.Format(time.RFC3339))
+				fmt.Fprintf(w, "Mode:\t%s\n", st.Mode)
+				fmt.Fprintf(w, "Encrypted:\t%t\n", st.Encrypted)
+				fmt.Fprintf(w, "Chunks:\t%d\n", st.Chunks)
+				fmt.Fprintf(w, "Backends:\t%s\n", strings.Join(st.Backends, ", "))
+				for i, sum := range st.Checksums {
+					fmt.Fprintf(w, "Chunk %d:\t%s\n", i, sum)
+				}
+			})
+		},
+	}
+	statCmd.Flags().StringVarP(&statOutput, "output", "o", "table", "output format: json or table")
+	rootCmd.AddCommand(statCmd)
+
+	var chunksOutput string
+	chunksCmd := &cobra.Command{
+		Use:   "chunks [file]",
+		Short: "List the chunks of a file's current version with their replicas",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ver, ok := currentVersion(services.Metadata, args[0])
+			if !ok {
+				fmt.Fprintf(os.Stderr, "No such file: %s\n", args[0])
+				os.Exit(1)
+			}
+			chunks, err := services.Metadata.ListChunks(ver.StorageName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Listing chunks failed: %v\n", err)
+				os.Exit(1)
+			}
+			entries := []chunkEntry{}
+			for _, c := range chunks {
+				entries = append(entries, chunkEntry{
+					Index:      c.Index,
+					Name:       c.ChunkName,
+					Size:       c.Size,
+					StoredSize: c.StoredSize,
+					Codec:      c.Codec,
+					Checksum:   hex.EncodeToString([]byte(c.Checksum)),
+					Replicas:   c.Replicas,
+				})
+			}
+			writeOutput(chunksOutput, entries, func(w io.Writer) {
+				fmt.Fprintln(w, "INDEX\tSIZE\tSTORED\tCODEC\tNAME\tREPLICAS")
+				for _, e := range entries {
+					fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%s\n", e.Index, e.Size, e.StoredSize, e.Codec, e.Name, strings.Join(e.Replicas, ","))
+				}
+			})
+		},
+	}
+	chunksCmd.Flags().StringVarP(&chunksOutput, "output", "o", "table", "output format: json or table")
+	rootCmd.AddCommand(chunksCmd)
+
+	sessionsCmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "List unfinished uploads",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			sessions, err := services.Metadata.ListUploadSessions()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Listing sessions failed: %v\n", err)
+				os.Exit(1)
+			}
+			now := time.Now()
+			for _, sess := range sessions {
+				state := "expires " + sess.ExpiresAt.Format(time.RFC3339)
+				if sess.Expired(now) {
+					state = "expired"
+				}
+				fmt.Printf("%s\t%s\tstarted %s\t%s\n", sess.FileName, sess.SourcePath, sess.CreatedAt.Format(time.RFC3339), state)
+			}
+		},
+	}
+	var cleanAll bool
+	sessionsCleanCmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Delete expired unfinished uploads and the chunks they stored",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			removed, err := services.Storage.CleanupUploadSessionsContext(ctx, cleanAll)
+			for _, name := range removed {
+				fmt.Println("Removed:", name)
+			}
+			if err != nil {
+				fail("Cleanup", err)
+			}
+		},
+	}
+	sessionsCleanCmd.Flags().BoolVar(&cleanAll, "all", false, "also delete unfinished uploads that have not expired")
+	sessionsCmd.AddCommand(sessionsCleanCmd)
+	rootCmd.AddCommand(sessionsCmd)
+
+	versionsCmd := &cobra.Command{
+		Use:   "versions [file]",
+		Short: "List the stored versions of a file, newest first",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			versions, err := services.Metadata.ListVersions(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Listing versions failed: %v\n", err)
+				os.Exit(1)
+			}
+			if len(versions) == 0 {
+				fmt.Fprintf(os.Stderr, "No versions of %s\n", args[0])
+				os.Exit(1)
+			}
+			for _, ver := range versions {
+				marker := ""
+				if ver.Current {
+					marker = "\tcurrent"
+				}
+				fmt.Printf("%d\t%s\t%d bytes%s\n", ver.Version, ver.CreatedAt.Format(time.RFC3339), ver.TotalSize, marker)
+			}
+		},
+	}
+	versionsRestoreCmd := &cobra.Command{
+		Use:   "restore [file] [version]",
+		Short: "Make an earlier version of a file current again",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			version, err := strconv.Atoi(args[1])
+			if err != nil || version < 1 {
+				fmt.Fprintf(os.Stderr, "Invalid version %q\n", args[1])
+				os.Exit(1)
+			}
+			if err := services.Storage.RestoreVersion(args[0], version); err != nil {
+				fail("Restore", err)
+			}
+			fmt.Printf("Restored %s to version %d\n", args[0], version)
+		},
+	}
+	var (
+		pruneKeepLast  int
+		pruneKeepDaily int
+	)
+	versionsPruneCmd := &cobra.Command{
+		Use:   "prune [file...]",
+		Short: "Delete old versions by the retention rules, of the given files or of every file",
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			policy := storage.RetentionPolicyFromConfig(config.GetConfig().Versioning)
+			if cmd.Flags().Changed("keep-last") {
+				policy.KeepLast = pruneKeepLast
+			}
+			if cmd.Flags().Changed("keep-daily") {
+				policy.KeepDailyDays = pruneKeepDaily
+			}
+			if policy == (storage.RetentionPolicy{}) {
+				fmt.Fprintln(os.Stderr, "No retention rules: set versioning in the config or pass --keep-last or --keep-daily")
+				os.Exit(1)
+			}
+			files := args
+			if len(files) == 0 {
+				all, err := services.Metadata.ListFiles()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Listing files failed: %v\n", err)
+					os.Exit(1)
+				}
+				for _, file := range all {
+					files = append(files, file.FileName)
+				}
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			for _, name := range files {
+				pruned, err := services.Storage.PruneVersionsContext(ctx, name, policy)
+				for _, version := range pruned {
+					fmt.Printf("Pruned: %s version %d\n", name, version)
+				}
+				if err != nil {
+					fail("Prune", err)
+				}
+			}
+		},
+	}
+	versionsPruneCmd.Flags().IntVar(&pruneKeepLast, "keep-last", 0, "keep this many newest versions (default from config)")
+	versionsPruneCmd.Flags().IntVar(&pruneKeepDaily, "keep-daily", 0, "keep the newest version of each of the last N days (default from config)")
+	versionsCmd.AddCommand(versionsRestoreCmd, versionsPruneCmd)
+	rootCmd.AddCommand(versionsCmd)
+
+	var gcOpts storage.GCOptions
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete objects on the backends that no file references",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			reports, err := services.Storage.GCContext(ctx, gcOpts)
+			for _, report := range reports {
+				for _, obj := range report.Orphans {
+					fmt.Printf("%s\t%s\t%d bytes\t%s\n", report.Storage, obj.Name, obj.Size, obj.ModTime.Format(time.RFC3339))
+				}
+				for _, e := range report.Errors {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", report.Storage, e)
+				}
+				if gcOpts.DryRun {
+					fmt.Printf("%s: %d objects, %d orphans\n", report.Storage, report.Scanned, len(report.Orphans))
+				} else {
+					fmt.Printf("%s: %d objects, %d orphans deleted\n", report.Storage, report.Scanned, report.Deleted)
+				}
+				if report.Foreign > 0 {
+					fmt.Printf("%s: %d objects not written by storagex left alone\n", report.Storage, report.Foreign)
+				}
+			}
+			if err != nil {
+				fail("GC", err)
+			}
+		},
+	}
+	gcCmd.Flags().BoolVar(&gcOpts.DryRun, "dry-run", false, "list orphaned objects without deleting them")
+	gcCmd.Flags().DurationVar(&gcOpts.Grace, "grace", storage.DefaultGCGrace, "only delete orphans last modified longer ago than this")
+	rootCmd.AddCommand(gcCmd)
+
+	var fsckOpts storage.FsckOptions
+	fsckCmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Check metadata consistency and, with --deep, every stored object; prints a JSON report",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			report, err := services.Storage.FsckContext(ctx, fsckOpts)
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if encErr := enc.Encode(report); encErr != nil {
+				fail("Fsck", encErr)
+			}
+			if err != nil {
+				fail("Fsck", err)
+			}
+			if n := report.Unresolved(); n > 0 {
+				fmt.Fprintf(os.Stderr, "%d unresolved issues\n", n)
+				dumpMetrics()
+				os.Exit(1)
+			}
+		},
+	}
+	fsckCmd.Flags().BoolVar(&fsckOpts.Deep, "deep", false, "download every chunk and parity shard and verify its checksum")
+	fsckCmd.Flags().BoolVar(&fsckOpts.Repair, "repair", false, "rewrite missing or corrupt copies from intact replicas or stripes (implies --deep)")
+	rootCmd.AddCommand(fsckCmd)
+
+	var serveAddr string
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the stored files over an HTTP API until interrupted",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			serveMetrics(ctx, "")
+			log.Info("Serving the HTTP API on %s", serveAddr)
+			if err := serveUntilDone(ctx, serveAddr, server.NewServer(services.Storage, services.Metadata)); err != nil {
+				fail("Serve", err)
+			}
+			log.Info("HTTP API stopped")
+		},
+	}
+	serveCmd.Flags().StringVar(&serveAddr, "addr", defaults.DefaultServeAddr, "address to listen on")
+	rootCmd.AddCommand(serveCmd)
+
+	var gatewayAddr, gatewayMetricsAddr string
+	gatewayCmd := &cobra.Command{
+		Use:   "gateway",
+		Short: "Serve the stored files over an S3-compatible API until interrupted",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			gw, err := gateway.NewGateway(services.Storage, services.Metadata, services.Config.Gateway)
+			if err != nil {
+				fail("Gateway", err)
+			}
+			serveMetrics(ctx, gatewayMetricsAddr)
+			log.Info("Serving bucket %s over the S3 API on %s", services.Config.Gateway.Bucket, gatewayAddr)
+			err = serveUntilDone(ctx, gatewayAddr, gw)
+			gw.Close()
+			if err != nil {
+				fail("Gateway", err)
+			}
+			log.Info("S3 gateway stopped")
+		},
+	}
+	gatewayCmd.Flags().StringVar(&gatewayAddr, "addr", defaults.DefaultGatewayAddr, "address to listen on")
+	gatewayCmd.Flags().StringVar(&gatewayMetricsAddr, "metrics-addr", "", "also serve Prometheus metrics on this address")
+	rootCmd.AddCommand(gatewayCmd)
+
+	var webdavAddr, webdavMetricsAddr string
+	webdavCmd := &cobra.Command{
+		Use:   "webdav",
+		Short: "Serve the stored files over WebDAV until interrupted",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			h, err := dav.NewHandler(services.Storage, services.Metadata, services.Config.WebDAV)
+			if err != nil {
+				fail("WebDAV", err)
+			}
+			if len(services.Config.WebDAV.Users) == 0 {
+				log.Info("No WebDAV users configured; access is anonymous")
+			}
+			serveMetrics(ctx, webdavMetricsAddr)
+			log.Info("Serving WebDAV on %s", webdavAddr)
+			err = serveUntilDone(ctx, webdavAddr, h)
+			h.Close()
+			if err != nil {
+				fail("WebDAV", err)
+			}
+			log.Info("WebDAV server stopped")
+		},
+	}
+	webdavCmd.Flags().StringVar(&webdavAddr, "addr", defaults.DefaultWebDAVAddr, "address to listen on")
+	webdavCmd.Flags().StringVar(&webdavMetricsAddr, "metrics-addr", "", "also serve Prometheus metrics on this address")
+	rootCmd.AddCommand(webdavCmd)
+
+	var grpcAddr, grpcMetricsAddr string
+	grpcCmd := &cobra.Command{
+		Use:   "grpc",
+		Short: "Serve the stored files over a gRPC API until interrupted",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if services == nil {
+				fmt.Fprintln(os.Stderr, "Services not initialized")
+				os.Exit(1)
+			}
+			ctx, cancel := commandContext(cmd)
+			defer cancel()
+			cfg := services.Config.GRPC
+			srv, err := rpc.NewServer(services.Storage, services.Metadata, cfg)
+			if err != nil {
+				fail("gRPC", err)
+			}
+			if len(cfg.Tokens) == 0 {
+				log.Info("No gRPC tokens configured; access is anonymous")
+			}
+			if cfg.CertFile == "" {
+				log.Info("No TLS certificate configured; serving gRPC in plaintext")
+			}
+			serveMetrics(ctx, grpcMetricsAddr)
+			log.Info("Serving the gRPC API on %s", grpcAddr)
+			if err := serveGRPCUntilDone(ctx, grpcAddr, srv); err != nil {
+				fail("gRPC", err)
+			}
+			log.Info("gRPC API stopped")
+		},
+	}
+	grpcCmd.Flags().StringVar(&grpcAddr, "addr", defaults.DefaultGRPCAddr, "address to listen on")
+	grpcCmd.Flags().StringVar(&grpcMetricsAddr, "metrics-addr", "", "also serve Prometheus metrics on this address")
+	rootCmd.AddCommand(grpcCmd)
+
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "configfile",
 		Short: "Print the path to the config file in use",
@@ -131,7 +988,14 @@ func main() {
 		},
 	})
 
-	if err := rootCmd.Execute(); err != nil {
+	// The first interrupt cancels the running command, which winds down cleanly; after
+	// that the default handler is restored so a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
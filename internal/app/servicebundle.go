@@ -1,9 +1,12 @@
 package app
 
 import (
+	"fmt"
+
 	"github.com/sayuyere/storageX/internal/chunker"
 	"github.com/sayuyere/storageX/internal/cloud"
 	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/encryption"
 	errorx "github.com/sayuyere/storageX/internal/errors" // unified error constants
 	"github.com/sayuyere/storageX/internal/log"
 	"github.com/sayuyere/storageX/internal/manager"
@@ -29,7 +32,10 @@ func NewServiceBundle(configPath string) (*ServiceBundle, error) {
 
 	log.InitLogger(cfg.Log.Debug)
 
-	ch := chunker.GetChunkerFromConfig()
+	ch, err := chunker.GetChunkerFromConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	meta, err := metadata.NewMetadataServiceFromConfig()
 	if err != nil {
@@ -44,18 +50,55 @@ func NewServiceBundle(configPath string) (*ServiceBundle, error) {
 		if auth.DropboxAccessToken != "" {
 			cloudSvcs = append(cloudSvcs, cloud.NewDropboxStorageWithAuth(auth))
 		}
+		if auth.LocalPath != "" {
+			local, err := cloud.NewLocalStorage(auth.LocalPath)
+			if err != nil {
+				return nil, errorx.Wrap(errorx.ErrCloudStorageInitFailed, err)
+			}
+			cloudSvcs = append(cloudSvcs, local)
+		}
+		if auth.S3 != nil {
+			s3, err := cloud.NewS3Storage(*auth.S3)
+			if err != nil {
+				return nil, errorx.Wrap(errorx.ErrCloudStorageInitFailed, err)
+			}
+			cloudSvcs = append(cloudSvcs, s3)
+		}
+	}
+
+	// Transient provider failures are retried with backoff before they reach the manager;
+	// metrics are recorded below the retries, so every attempt is observed
+	for i, svc := range cloudSvcs {
+		policy := cloud.RetryPolicyFromConfig(cfg.Retry.For(svc.StorageSystemID()))
+		cloudSvcs[i] = cloud.NewRetryStorage(cloud.NewInstrumentedStorage(svc), policy)
 	}
 
 	if len(cloudSvcs) == 0 {
 		return nil, errorx.WrapWithDetails(errorx.ErrNoCloudStorageConfigured, configPath)
 	}
 
-	mgr := manager.NewStorageManager(cloudSvcs)
-	for _, svc := range cloudSvcs {
-		mgr.AddCloudStorage(svc)
+	if cfg.Replication.Factor > len(cloudSvcs) {
+		return nil, errorx.WrapWithDetails(errorx.ErrInvalidReplication,
+			fmt.Sprintf("factor %d, backends %d", cfg.Replication.Factor, len(cloudSvcs)))
 	}
 
+	placement, err := manager.NewPlacementPolicy(cfg.Placement)
+	if err != nil {
+		return nil, err
+	}
+
+	mgr := manager.NewStorageManager(cloudSvcs)
+	mgr.SetReplication(cfg.Replication.Factor, cfg.Replication.WriteQuorum)
+	mgr.SetPlacementPolicy(placement)
+
 	stor := storage.NewStorageService(mgr, meta, ch)
+	if cfg.Encryption.Enabled {
+		masterKey, err := encryption.LoadMasterKey(cfg.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		stor.SetMasterKey(masterKey)
+	}
 
 	return &ServiceBundle{
 		Config:   cfg,
@@ -48,7 +48,12 @@ func ChunkFromBytes(b []byte) *Chunk {
 }
 
 type FileChunker struct {
-	ChunkSize int
+	ChunkSize int    // fixed strategy: serialized chunk size, header included
+	Strategy  string // StrategyFixed (default) or StrategyFastCDC
+
+	// FastCDC data sizes, set by NewFastCDCChunker
+	MinSize, AvgSize, MaxSize int
+	maskS, maskL              uint64
 }
 
 var (
@@ -69,27 +74,49 @@ func GetChunker(chunkSize int) *FileChunker {
 	return singleton
 }
 
-// GetChunkerFromConfig returns the singleton FileChunker using chunk size from app config
-func GetChunkerFromConfig() *FileChunker {
+// GetChunkerFromConfig returns the singleton FileChunker using the chunking strategy from
+// app config. An unknown strategy or invalid FastCDC sizes are reported as errors.
+func GetChunkerFromConfig() (*FileChunker, error) {
 	cfg := config.GetConfig()
 	if cfg == nil {
-		panic(errorx.ErrConfigNotLoaded.Error()) // keep panic for fatal config issues
+		return nil, errorx.ErrConfigNotLoaded
+	}
+	switch cfg.Chunking.Strategy {
+	case "", StrategyFixed:
+		return GetChunker(cfg.ChunkSize), nil
+	case StrategyFastCDC:
+		fc, err := NewFastCDCChunker(cfg.Chunking.MinSize, cfg.Chunking.AvgSize, cfg.Chunking.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		singletonOnce.Do(func() { singleton = fc })
+		return singleton, nil
 	}
-	return GetChunker(cfg.ChunkSize)
+	return nil, errorx.WrapWithDetails(errorx.ErrUnknownChunkingStrategy, cfg.Chunking.Strategy)
 }
 
-// ChunkFileStream streams file chunks of the given size
+// ChunkFileStream streams file chunks using the chunker's strategy
 func (fc *FileChunker) ChunkFileStream(file *os.File) (<-chan Chunk, error) {
-	ch := make(chan Chunk)
-
 	fileInfo, err := file.Stat()
 	if err != nil {
 		return nil, errorx.Wrap(errorx.ErrFileInfoFetchFailed, err)
 	}
+	return fc.ChunkStream(file, fileInfo.Name()), nil
+}
 
-	fileName := fileInfo.Name()
+// ChunkStream streams the chunks of r, naming them after fileName, and closes r at the end
+func (fc *FileChunker) ChunkStream(r io.ReadCloser, fileName string) <-chan Chunk {
+	ch := make(chan Chunk)
+	if fc.Strategy == StrategyFastCDC {
+		go func() {
+			defer r.Close()
+			defer close(ch)
+			fc.streamCDC(r, fileName, ch)
+		}()
+		return ch
+	}
 	go func() {
-		defer file.Close()
+		defer r.Close()
 		defer close(ch)
 		metaSize := ChunkMetadataSize
 		dataSize := fc.ChunkSize - metaSize
@@ -97,7 +124,8 @@ func (fc *FileChunker) ChunkFileStream(file *os.File) (<-chan Chunk, error) {
 		index := uint64(0)
 
 		for {
-			n, err := file.Read(buf)
+			// Fill whole chunks even from sources that return short reads, like network bodies
+			n, err := io.ReadFull(r, buf)
 			if n > 0 {
 				chunkData := make([]byte, n)
 				copy(chunkData, buf[:n])
@@ -113,7 +141,7 @@ func (fc *FileChunker) ChunkFileStream(file *os.File) (<-chan Chunk, error) {
 				}
 				index++
 			}
-			if err == io.EOF {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
 				break
 			}
 			if err != nil {
@@ -128,11 +156,14 @@ func (fc *FileChunker) ChunkFileStream(file *os.File) (<-chan Chunk, error) {
 			}
 		}
 	}()
-	return ch, nil
+	return ch
 }
 
 // ChunkBytes splits an in-memory byte slice into chunks
 func (fc *FileChunker) ChunkBytes(data []byte, fileName string) []Chunk {
+	if fc.Strategy == StrategyFastCDC {
+		return fc.chunkBytesCDC(data, fileName)
+	}
 	metaSize := ChunkMetadataSize
 	dataSize := fc.ChunkSize - metaSize
 	var chunks []Chunk
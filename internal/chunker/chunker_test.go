@@ -3,9 +3,16 @@ package chunker
 import (
 	"bytes"
 	"crypto/sha256"
+	"errors"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
+	"testing/iotest"
+
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
 )
 
 func TestChunk_BytesAndFromBytes(t *testing.T) {
@@ -70,6 +77,23 @@ func TestFileChunker_ChunkFileStream(t *testing.T) {
 	}
 }
 
+func TestFileChunker_ChunkStreamShortReads(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 5)
+	chunker := NewFileChunker(ChunkMetadataSize + 16)
+	// A source returning a byte at a time still yields full chunks
+	ch := chunker.ChunkStream(ioutil.NopCloser(iotest.OneByteReader(bytes.NewReader(data))), "file")
+	var sizes []int
+	for chunk := range ch {
+		if chunk.Err != nil {
+			t.Fatalf("chunk error: %v", chunk.Err)
+		}
+		sizes = append(sizes, len(chunk.Data))
+	}
+	if len(sizes) != 4 || sizes[0] != 16 || sizes[2] != 16 || sizes[3] != 2 {
+		t.Errorf("chunk sizes %v, want [16 16 16 2]", sizes)
+	}
+}
+
 func TestGetChunker_Singleton(t *testing.T) {
 	c1 := GetChunker(100)
 	c2 := GetChunker(200)
@@ -80,3 +104,120 @@ func TestGetChunker_Singleton(t *testing.T) {
 		t.Errorf("expected chunk size 100, got %d", c1.ChunkSize)
 	}
 }
+
+func cdcTestData(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+func TestFastCDC_BoundsAndReassembly(t *testing.T) {
+	fc, err := NewFastCDCChunker(256, 1024, 4096)
+	if err != nil {
+		t.Fatalf("NewFastCDCChunker failed: %v", err)
+	}
+	data := cdcTestData(256 * 1024)
+	chunks := fc.ChunkBytes(data, "file")
+
+	var joined []byte
+	for i, c := range chunks {
+		if i < len(chunks)-1 && (len(c.Data) < fc.MinSize || len(c.Data) > fc.MaxSize) {
+			t.Errorf("chunk %d has size %d outside [%d, %d]", i, len(c.Data), fc.MinSize, fc.MaxSize)
+		}
+		if c.Index != uint64(i) || c.Checksum != sha256.Sum256(c.Data) {
+			t.Errorf("chunk %d has wrong index or checksum", i)
+		}
+		joined = append(joined, c.Data...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("chunks do not reassemble to the input")
+	}
+	if avg := len(data) / len(chunks); avg < fc.MinSize || avg > 2*fc.AvgSize {
+		t.Errorf("average chunk size %d far from target %d", avg, fc.AvgSize)
+	}
+
+	// Streaming must cut at the same places regardless of read sizes
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		fc.streamCDC(iotest.OneByteReader(bytes.NewReader(data)), "file", ch)
+	}()
+	i := 0
+	for c := range ch {
+		if c.Err != nil {
+			t.Fatalf("stream error: %v", c.Err)
+		}
+		if i >= len(chunks) || c.Checksum != chunks[i].Checksum {
+			t.Fatalf("streamed chunk %d differs from ChunkBytes", i)
+		}
+		i++
+	}
+	if i != len(chunks) {
+		t.Errorf("streamed %d chunks, want %d", i, len(chunks))
+	}
+}
+
+func TestFastCDC_InsertionOnlyChangesNearbyChunks(t *testing.T) {
+	fc, _ := NewFastCDCChunker(256, 1024, 4096)
+	data := cdcTestData(256 * 1024)
+	shifted := append([]byte{0x42}, data...)
+
+	before := make(map[[32]byte]bool)
+	for _, c := range fc.ChunkBytes(data, "a") {
+		before[c.Checksum] = true
+	}
+	after := fc.ChunkBytes(shifted, "b")
+	shared := 0
+	for _, c := range after {
+		if before[c.Checksum] {
+			shared++
+		}
+	}
+	if shared < len(after)-2 {
+		t.Errorf("only %d of %d chunks survived a one-byte insertion", shared, len(after))
+	}
+
+	// Fixed-size chunking loses every chunk to the same edit
+	fixed := NewFileChunker(ChunkMetadataSize + 1024)
+	fixedBefore := make(map[[32]byte]bool)
+	for _, c := range fixed.ChunkBytes(data, "a") {
+		fixedBefore[c.Checksum] = true
+	}
+	for _, c := range fixed.ChunkBytes(shifted, "b") {
+		if fixedBefore[c.Checksum] {
+			t.Fatal("fixed chunking unexpectedly kept a chunk; test data is not useful")
+		}
+	}
+}
+
+func TestNewFastCDCChunker_InvalidSizes(t *testing.T) {
+	for _, sizes := range [][3]int{{0, 1024, 4096}, {1024, 1024, 4096}, {256, 1000, 4096}, {256, 1024, 1024}} {
+		if _, err := NewFastCDCChunker(sizes[0], sizes[1], sizes[2]); !errors.Is(err, errorx.ErrInvalidChunkSizes) {
+			t.Errorf("%v: expected ErrInvalidChunkSizes, got %v", sizes, err)
+		}
+	}
+}
+
+func TestGetChunkerFromConfig_InvalidSettings(t *testing.T) {
+	cases := []struct {
+		chunking string
+		want     error
+	}{
+		{`{"strategy": "rabin"}`, errorx.ErrUnknownChunkingStrategy},
+		{`{"strategy": "fastcdc", "min_size": 4096, "avg_size": 3000, "max_size": 65536}`, errorx.ErrInvalidChunkSizes},
+	}
+	for _, tc := range cases {
+		config.ResetConfigSingleton()
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(`{"chunking": `+tc.chunking+`}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := config.LoadConfig(path); err != nil {
+			t.Fatalf("LoadConfig failed: %v", err)
+		}
+		if _, err := GetChunkerFromConfig(); !errors.Is(err, tc.want) {
+			t.Errorf("chunking %s: expected %v, got %v", tc.chunking, tc.want, err)
+		}
+	}
+	config.ResetConfigSingleton()
+}
@@ -0,0 +1,136 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/bits"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// Chunking strategies accepted in config
+const (
+	StrategyFixed   = "fixed"   // ChunkSize blocks
+	StrategyFastCDC = "fastcdc" // content-defined boundaries between MinSize and MaxSize
+)
+
+// gearTable maps every byte to a pseudo-random 64-bit value for the rolling gear hash.
+// It is generated from a fixed seed: changing it moves every chunk boundary and defeats
+// deduplication against chunks already stored.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x73746f7261676558) // "storageX"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// NewFastCDCChunker returns a chunker cutting content-defined chunks with FastCDC.
+// Sizes are in bytes of chunk data; chunks average avgSize and never exceed maxSize.
+func NewFastCDCChunker(minSize, avgSize, maxSize int) (*FileChunker, error) {
+	if minSize < 1 || avgSize <= minSize || maxSize <= avgSize || avgSize&(avgSize-1) != 0 {
+		return nil, errorx.WrapWithDetails(errorx.ErrInvalidChunkSizes,
+			fmt.Sprintf("need 0 < min < avg < max with avg a power of two, got %d/%d/%d", minSize, avgSize, maxSize))
+	}
+	// Normalized chunking: a stricter mask before the average size and a looser one after
+	// it pull chunk sizes towards avgSize. The masks test the high bits of the hash, which
+	// depend on the most recent 64 bytes.
+	avgBits := bits.TrailingZeros(uint(avgSize))
+	return &FileChunker{
+		ChunkSize: ChunkMetadataSize + maxSize,
+		Strategy:  StrategyFastCDC,
+		MinSize:   minSize,
+		AvgSize:   avgSize,
+		MaxSize:   maxSize,
+		maskS:     ^uint64(0) << (64 - uint(avgBits+1)),
+		maskL:     ^uint64(0) << (64 - uint(avgBits-1)),
+	}, nil
+}
+
+// cdcCut returns the length of the next chunk at the start of data. data holds at least
+// MaxSize bytes unless it is the end of the input.
+func (fc *FileChunker) cdcCut(data []byte) int {
+	n := len(data)
+	if n <= fc.MinSize {
+		return n
+	}
+	if n > fc.MaxSize {
+		n = fc.MaxSize
+	}
+	normal := fc.AvgSize
+	if normal > n {
+		normal = n
+	}
+	var hash uint64
+	i := fc.MinSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&fc.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&fc.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+func newChunk(fileName string, index uint64, data []byte) Chunk {
+	return Chunk{
+		Data:     data,
+		N:        uint64(len(data)),
+		Name:     fileName + "-chunk-" + uintToString(index),
+		Checksum: sha256.Sum256(data),
+		Index:    index,
+	}
+}
+
+// streamCDC reads r to the end, sending content-defined chunks on ch
+func (fc *FileChunker) streamCDC(r io.Reader, fileName string, ch chan<- Chunk) {
+	buf := make([]byte, 0, fc.MaxSize)
+	index := uint64(0)
+	eof := false
+	for {
+		// Keep a full window so cut points do not depend on read sizes
+		for !eof && len(buf) < fc.MaxSize {
+			n, err := r.Read(buf[len(buf):fc.MaxSize])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				ch <- Chunk{Err: errorx.Wrap(errorx.ErrChunkReadFailed, err), Index: index}
+				return
+			}
+		}
+		if len(buf) == 0 {
+			return
+		}
+		cut := fc.cdcCut(buf)
+		data := make([]byte, cut)
+		copy(data, buf[:cut])
+		ch <- newChunk(fileName, index, data)
+		index++
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+}
+
+// chunkBytesCDC is ChunkBytes for the FastCDC strategy
+func (fc *FileChunker) chunkBytesCDC(data []byte, fileName string) []Chunk {
+	var chunks []Chunk
+	for index := uint64(0); len(data) > 0; index++ {
+		cut := fc.cdcCut(data)
+		chunks = append(chunks, newChunk(fileName, index, data[:cut]))
+		data = data[cut:]
+	}
+	return chunks
+}
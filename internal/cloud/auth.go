@@ -6,7 +6,9 @@ import "github.com/sayuyere/storageX/internal/config"
 // Each provider can use the relevant fields
 
 type AuthConfig struct {
-	DropboxAccessToken string // Dropbox API access token
+	DropboxAccessToken string           // Dropbox API access token
+	LocalPath          string           // Root directory for local filesystem / NAS storage
+	S3                 *config.S3Config // S3-compatible bucket settings
 	// Add more fields as needed for other providers
 }
 
@@ -18,6 +20,15 @@ func AuthConfigFromCloudConfig(cloudCfg *config.CloudConfig) []AuthConfig {
 		result = append(result, AuthConfig{DropboxAccessToken: token})
 	}
 
+	for _, path := range cloudCfg.LocalPaths {
+		result = append(result, AuthConfig{LocalPath: path})
+	}
+
+	for i := range cloudCfg.S3 {
+		s3 := cloudCfg.S3[i]
+		result = append(result, AuthConfig{S3: &s3})
+	}
+
 	return result
 }
 
@@ -30,6 +41,25 @@ func LinkAuthConfigForProvider(provider string, cloudConfig map[string]interface
 		if token, ok := cloudConfig["dropbox_access_token"].(string); ok {
 			ac.DropboxAccessToken = token
 		}
+	case "local":
+		if path, ok := cloudConfig["local_path"].(string); ok {
+			ac.LocalPath = path
+		}
+	case "s3":
+		s3 := &config.S3Config{}
+		s3.Endpoint, _ = cloudConfig["endpoint"].(string)
+		s3.Region, _ = cloudConfig["region"].(string)
+		s3.Bucket, _ = cloudConfig["bucket"].(string)
+		s3.Prefix, _ = cloudConfig["prefix"].(string)
+		s3.AccessKeyID, _ = cloudConfig["access_key_id"].(string)
+		s3.SecretAccessKey, _ = cloudConfig["secret_access_key"].(string)
+		s3.PathStyle, _ = cloudConfig["path_style"].(bool)
+		if capacity, ok := cloudConfig["capacity"].(float64); ok {
+			s3.Capacity = int64(capacity)
+		}
+		if s3.Bucket != "" {
+			ac.S3 = s3
+		}
 		// Add more providers here, e.g.:
 		// case "gdrive":
 		//   if cred, ok := cloudConfig["gdrive_credentials"].(string); ok {
@@ -16,6 +16,15 @@ func TestAuthConfigFromCloudConfig_Struct(t *testing.T) {
 	}
 }
 
+func TestAuthConfigFromCloudConfig_LocalPaths(t *testing.T) {
+	input := config.CloudConfig{DropboxAccessTokens: []string{"token1"}, LocalPaths: []string{"/mnt/nas"}}
+	got := AuthConfigFromCloudConfig(&input)
+	want := []AuthConfig{{DropboxAccessToken: "token1"}, {LocalPath: "/mnt/nas"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AuthConfigFromCloudConfig(local) = %v, want %v", got, want)
+	}
+}
+
 func TestLinkAuthConfigForProvider_Dropbox(t *testing.T) {
 	input := map[string]interface{}{"dropbox_access_token": "dbtoken"}
 	ac := LinkAuthConfigForProvider("dropbox", input)
@@ -1,5 +1,11 @@
 package cloud
 
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
 // CloudStorage defines the interface for cloud storage providers
 // Implement UploadChunk, GetChunk, and DeleteChunk for each provider
 
@@ -9,4 +15,93 @@ type CloudStorage interface {
 	DeleteChunk(name string) error
 	GetRemainingSize() (int64, error) // New method to get storage unit size
 	StorageSystemID() string          // Returns a unique ID or name for the storage system
+	List() ([]ObjectInfo, error)      // Returns every object stored, for garbage collection
+}
+
+// ObjectInfo describes one object stored on a provider, under the name it was uploaded with
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// ContextStorage is implemented by providers whose operations can be cancelled or given a
+// deadline. Use the package-level helpers, which fall back to the plain methods.
+type ContextStorage interface {
+	UploadChunkContext(ctx context.Context, name string, data []byte) error
+	GetChunkContext(ctx context.Context, name string) ([]byte, error)
+	DeleteChunkContext(ctx context.Context, name string) error
+	GetRemainingSizeContext(ctx context.Context) (int64, error)
+	ListContext(ctx context.Context) ([]ObjectInfo, error)
+}
+
+// UploadChunk uploads through UploadChunkContext when s supports it. Other providers
+// are only called while ctx is still live.
+func UploadChunk(ctx context.Context, s CloudStorage, name string, data []byte) error {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.UploadChunkContext(ctx, name, data)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.UploadChunk(name, data)
+}
+
+// GetChunk downloads through GetChunkContext when s supports it. Other providers are
+// only called while ctx is still live.
+func GetChunk(ctx context.Context, s CloudStorage, name string) ([]byte, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.GetChunkContext(ctx, name)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.GetChunk(name)
+}
+
+// DeleteChunk deletes through DeleteChunkContext when s supports it. Other providers
+// are only called while ctx is still live.
+func DeleteChunk(ctx context.Context, s CloudStorage, name string) error {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.DeleteChunkContext(ctx, name)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.DeleteChunk(name)
+}
+
+// GetRemainingSize queries the free space through GetRemainingSizeContext when s
+// supports it. Other providers are only called while ctx is still live.
+func GetRemainingSize(ctx context.Context, s CloudStorage) (int64, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.GetRemainingSizeContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.GetRemainingSize()
+}
+
+// List lists the objects through ListContext when s supports it. Other providers are
+// only called while ctx is still live.
+func List(ctx context.Context, s CloudStorage) ([]ObjectInfo, error) {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs.ListContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.List()
+}
+
+// contextTransport attaches ctx to every request, for SDK clients that build requests
+// without one
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
 }
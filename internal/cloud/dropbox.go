@@ -2,19 +2,34 @@ package cloud
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox"
+	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/auth"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/files"
 	"github.com/dropbox/dropbox-sdk-go-unofficial/v6/dropbox/users"
+	"golang.org/x/oauth2"
 
 	errorsx "github.com/sayuyere/storageX/internal/errors"
 	"github.com/sayuyere/storageX/internal/log"
 )
 
+// dropboxUnknownID is reported while the account ID cannot be fetched
+const dropboxUnknownID = "dropbox:unknown"
+
 type DropboxStorage struct {
-	client files.Client
-	config dropbox.Config
+	config     dropbox.Config
+	httpClient *http.Client // authorized client shared by per-context SDK clients
+
+	idLock sync.Mutex
+	id     string // cached account-based storage ID
 }
 
 func NewDropboxStorageWithAuth(auth AuthConfig) *DropboxStorage {
@@ -22,46 +37,135 @@ func NewDropboxStorageWithAuth(auth AuthConfig) *DropboxStorage {
 		Token:    auth.DropboxAccessToken,
 		LogLevel: dropbox.LogInfo, // or dropbox.LogOff
 	}
-	client := files.New(config)
-	return &DropboxStorage{client: client, config: config}
+	oauthConfig := &oauth2.Config{Endpoint: dropbox.OAuthEndpoint("")}
+	httpClient := oauthConfig.Client(context.Background(), &oauth2.Token{AccessToken: auth.DropboxAccessToken})
+	return &DropboxStorage{config: config, httpClient: httpClient}
+}
+
+// contextConfig returns the SDK config with every request bound to ctx; the SDK itself
+// does not take a context
+func (d *DropboxStorage) contextConfig(ctx context.Context) dropbox.Config {
+	config := d.config
+	if d.httpClient != nil {
+		config.Client = &http.Client{Transport: contextTransport{ctx: ctx, base: d.httpClient.Transport}}
+	}
+	return config
+}
+
+// classifyDropboxError marks rate limits (too_many_requests and too_many_write_operations,
+// with their retry_after), server errors and network failures as retryable
+func classifyDropboxError(ctx context.Context, err error) error {
+	switch e := err.(type) {
+	case auth.RateLimitAPIError:
+		var after time.Duration
+		if e.RateLimitError != nil {
+			after = time.Duration(e.RateLimitError.RetryAfter) * time.Second
+		}
+		return errorsx.Retryable(err, after)
+	case auth.ServerError:
+		return errorsx.Retryable(err, 0)
+	case dropbox.SDKInternalError:
+		if retryableStatus(e.StatusCode) {
+			return errorsx.Retryable(err, 0)
+		}
+		return err
+	}
+	var netErr net.Error
+	if ctx.Err() == nil && errors.As(err, &netErr) {
+		return errorsx.Retryable(err, 0)
+	}
+	return err
 }
 
 func (d *DropboxStorage) UploadChunk(name string, data []byte) error {
+	return d.UploadChunkContext(context.Background(), name, data)
+}
+
+func (d *DropboxStorage) UploadChunkContext(ctx context.Context, name string, data []byte) error {
 	uploadArg := files.NewUploadArg("/" + name)
 	uploadArg.Mode.Tag = "overwrite"
-	_, err := d.client.Upload(uploadArg, ioutil.NopCloser(bytes.NewReader(data)))
+	_, err := files.New(d.contextConfig(ctx)).Upload(uploadArg, ioutil.NopCloser(bytes.NewReader(data)))
 	if err != nil {
-		return errorsx.WrapDropboxError(errorsx.ErrDropboxUpload, err)
+		return errorsx.WrapDropboxError(errorsx.ErrDropboxUpload, classifyDropboxError(ctx, err))
 	}
 	return nil
 }
 
 func (d *DropboxStorage) GetChunk(name string) ([]byte, error) {
+	return d.GetChunkContext(context.Background(), name)
+}
+
+func (d *DropboxStorage) GetChunkContext(ctx context.Context, name string) ([]byte, error) {
 	downloadArg := files.NewDownloadArg("/" + name)
-	_, content, err := d.client.Download(downloadArg)
+	_, content, err := files.New(d.contextConfig(ctx)).Download(downloadArg)
 	if err != nil {
-		return nil, errorsx.WrapDropboxError(errorsx.ErrDropboxDownload, err)
+		return nil, errorsx.WrapDropboxError(errorsx.ErrDropboxDownload, classifyDropboxError(ctx, err))
 	}
 	defer content.Close()
 	return ioutil.ReadAll(content)
 }
 
 func (d *DropboxStorage) DeleteChunk(name string) error {
+	return d.DeleteChunkContext(context.Background(), name)
+}
+
+func (d *DropboxStorage) DeleteChunkContext(ctx context.Context, name string) error {
 	deleteArg := files.NewDeleteArg("/" + name)
-	_, err := d.client.DeleteV2(deleteArg)
+	_, err := files.New(d.contextConfig(ctx)).DeleteV2(deleteArg)
 	log.Info("Dropbox delete chunk:", name, "error:", err)
 	if err != nil {
-		return errorsx.WrapDropboxError(errorsx.ErrDropboxDelete, err)
+		return errorsx.WrapDropboxError(errorsx.ErrDropboxDelete, classifyDropboxError(ctx, err))
 	}
 	return nil
 }
 
+// List walks the app folder, or with a full-access token the whole account, recursively;
+// chunk names containing "/" are stored in subfolders
+func (d *DropboxStorage) List() ([]ObjectInfo, error) {
+	return d.ListContext(context.Background())
+}
+
+func (d *DropboxStorage) ListContext(ctx context.Context) ([]ObjectInfo, error) {
+	// Listings are matched against metadata by storage ID; under the fallback ID every
+	// object would look unreferenced
+	if d.StorageSystemID() == dropboxUnknownID {
+		return nil, errorsx.WrapWithDetails(errorsx.ErrDropboxList, "account ID unavailable")
+	}
+	client := files.New(d.contextConfig(ctx))
+	arg := files.NewListFolderArg("")
+	arg.Recursive = true
+	res, err := client.ListFolder(arg)
+	var result []ObjectInfo
+	for {
+		if err != nil {
+			return nil, errorsx.WrapDropboxError(errorsx.ErrDropboxList, classifyDropboxError(ctx, err))
+		}
+		for _, entry := range res.Entries {
+			if f, ok := entry.(*files.FileMetadata); ok {
+				result = append(result, ObjectInfo{
+					Name:    strings.TrimPrefix(f.PathDisplay, "/"),
+					Size:    int64(f.Size),
+					ModTime: f.ServerModified,
+				})
+			}
+		}
+		if !res.HasMore {
+			return result, nil
+		}
+		res, err = client.ListFolderContinue(files.NewListFolderContinueArg(res.Cursor))
+	}
+}
+
 // Fix GetRemainingSize to match interface: return int64, not uint64
 func (d *DropboxStorage) GetRemainingSize() (int64, error) {
-	userClient := users.New(d.config)
+	return d.GetRemainingSizeContext(context.Background())
+}
+
+func (d *DropboxStorage) GetRemainingSizeContext(ctx context.Context) (int64, error) {
+	userClient := users.New(d.contextConfig(ctx))
 	spaceUsage, err := userClient.GetSpaceUsage()
 	if err != nil {
-		return 0, errorsx.WrapDropboxError(errorsx.ErrDropboxDownload, err)
+		return 0, errorsx.WrapDropboxError(errorsx.ErrDropboxDownload, classifyDropboxError(ctx, err))
 	}
 	var allocated, used uint64
 	if spaceUsage.Allocation.Tag == "individual" && spaceUsage.Allocation.Individual != nil {
@@ -75,13 +179,19 @@ func (d *DropboxStorage) GetRemainingSize() (int64, error) {
 }
 
 func (d *DropboxStorage) StorageSystemID() string {
+	d.idLock.Lock()
+	defer d.idLock.Unlock()
+	if d.id != "" {
+		return d.id
+	}
 	// Use Dropbox account_id as unique tenant/storage node id
 	userClient := users.New(d.config)
 	acc, err := userClient.GetCurrentAccount()
-	log.Info("Dropbox account ID:", acc.AccountId)
 	if err == nil && acc.AccountId != "" {
-		return "dropbox:" + acc.AccountId
+		log.Info("Dropbox account ID: %s", acc.AccountId)
+		d.id = "dropbox:" + acc.AccountId
+		return d.id
 	}
-	// fallback to token hash or config
-	return "dropbox:unknown"
+	// fallback to token hash or config; not cached so a later call can recover
+	return dropboxUnknownID
 }
@@ -0,0 +1,209 @@
+package cloud
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	errorsx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// localIDFile holds the stable identifier of a local storage root
+const localIDFile = ".storagex-id"
+
+// LocalStorage stores chunks as files under a root directory (local disk or NAS mount).
+// Chunks are fanned out into subdirectories named after the first byte of the
+// SHA-256 of the chunk name so no single directory grows too large.
+type LocalStorage struct {
+	root string
+	id   string
+}
+
+// NewLocalStorage creates the root directory if needed and loads (or creates) its storage ID
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, errorsx.Wrap(errorsx.ErrLocalInit, err)
+	}
+	id, err := loadOrCreateLocalID(root)
+	if err != nil {
+		return nil, errorsx.Wrap(errorsx.ErrLocalInit, err)
+	}
+	return &LocalStorage{root: root, id: id}, nil
+}
+
+// loadOrCreateLocalID keeps the ID in the root itself so it survives restarts and remounts
+func loadOrCreateLocalID(root string) (string, error) {
+	path := filepath.Join(root, localIDFile)
+	b, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(b))) > 0 {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(raw[:])
+	if err := writeFileAtomic(path, []byte(id+"\n")); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// chunkPath maps a chunk name to its fan-out location; names are escaped so they never leave the root
+func (l *LocalStorage) chunkPath(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(l.root, hex.EncodeToString(sum[:1]), url.PathEscape(name))
+}
+
+// Filesystem calls cannot be interrupted: the Context variants only refuse to start once
+// ctx is done.
+
+func (l *LocalStorage) UploadChunk(name string, data []byte) error {
+	return l.UploadChunkContext(context.Background(), name, data)
+}
+
+func (l *LocalStorage) UploadChunkContext(ctx context.Context, name string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return errorsx.Wrap(errorsx.ErrLocalUpload, err)
+	}
+	path := l.chunkPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errorsx.Wrap(errorsx.ErrLocalUpload, err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return errorsx.Wrap(errorsx.ErrLocalUpload, err)
+	}
+	return nil
+}
+
+func (l *LocalStorage) GetChunk(name string) ([]byte, error) {
+	return l.GetChunkContext(context.Background(), name)
+}
+
+func (l *LocalStorage) GetChunkContext(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errorsx.Wrap(errorsx.ErrLocalDownload, err)
+	}
+	data, err := os.ReadFile(l.chunkPath(name))
+	if err != nil {
+		return nil, errorsx.Wrap(errorsx.ErrLocalDownload, err)
+	}
+	return data, nil
+}
+
+func (l *LocalStorage) DeleteChunk(name string) error {
+	return l.DeleteChunkContext(context.Background(), name)
+}
+
+func (l *LocalStorage) DeleteChunkContext(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return errorsx.Wrap(errorsx.ErrLocalDelete, err)
+	}
+	if err := os.Remove(l.chunkPath(name)); err != nil {
+		return errorsx.Wrap(errorsx.ErrLocalDelete, err)
+	}
+	return nil
+}
+
+// GetRemainingSize reports the space available to this process on the root's filesystem
+func (l *LocalStorage) GetRemainingSize() (int64, error) {
+	return l.GetRemainingSizeContext(context.Background())
+}
+
+func (l *LocalStorage) GetRemainingSizeContext(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, errorsx.Wrap(errorsx.ErrLocalStat, err)
+	}
+	free, err := freeSpace(l.root)
+	if err != nil {
+		return 0, errorsx.Wrap(errorsx.ErrLocalStat, err)
+	}
+	return free, nil
+}
+
+// List walks the fan-out directories. The ID file and temp files of interrupted writes
+// are not chunks and are skipped.
+func (l *LocalStorage) List() ([]ObjectInfo, error) {
+	return l.ListContext(context.Background())
+}
+
+func (l *LocalStorage) ListContext(ctx context.Context) ([]ObjectInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errorsx.Wrap(errorsx.ErrLocalList, err)
+	}
+	dirs, err := os.ReadDir(l.root)
+	if err != nil {
+		return nil, errorsx.Wrap(errorsx.ErrLocalList, err)
+	}
+	var result []ObjectInfo
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(l.root, dir.Name()))
+		if err != nil {
+			return nil, errorsx.Wrap(errorsx.ErrLocalList, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+				continue
+			}
+			name, err := url.PathUnescape(entry.Name())
+			if err != nil {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				// Deleted since the directory was read
+				continue
+			}
+			result = append(result, ObjectInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	return result, nil
+}
+
+func (l *LocalStorage) StorageSystemID() string {
+	return "local:" + l.id
+}
+
+// Root returns the directory chunks are stored under
+func (l *LocalStorage) Root() string {
+	return l.root
+}
+
+// writeFileAtomic writes to a temp file in the same directory and renames it into place
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
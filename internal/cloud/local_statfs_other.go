@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package cloud
+
+import "errors"
+
+// freeSpace is not supported on this platform
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("statfs not supported on this platform")
+}
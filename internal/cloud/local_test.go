@@ -0,0 +1,133 @@
+package cloud_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+)
+
+func TestLocalStorageLifecycle(t *testing.T) {
+	local, err := cloud.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	chunkName := "test-chunk.txt"
+	chunkData := []byte("hello, local!")
+
+	if err := local.UploadChunk(chunkName, chunkData); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	data, err := local.GetChunk(chunkName)
+	if err != nil {
+		t.Fatalf("GetChunk failed: %v", err)
+	}
+	if string(data) != string(chunkData) {
+		t.Errorf("GetChunk data mismatch: got %q, want %q", data, chunkData)
+	}
+	if err := local.DeleteChunk(chunkName); err != nil {
+		t.Fatalf("DeleteChunk failed: %v", err)
+	}
+	if _, err := local.GetChunk(chunkName); err == nil {
+		t.Error("expected error for deleted chunk, got nil")
+	}
+}
+
+func TestLocalStorageFanOut(t *testing.T) {
+	root := t.TempDir()
+	local, err := cloud.NewLocalStorage(root)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	// Names with separators must stay inside the root
+	if err := local.UploadChunk("../dir/escape-chunk-0", []byte("x")); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "..", "dir")); err == nil {
+		t.Fatal("chunk escaped the storage root")
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var dirs int
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs++
+			if len(e.Name()) != 2 {
+				t.Errorf("unexpected fan-out directory %q", e.Name())
+			}
+		}
+	}
+	if dirs != 1 {
+		t.Errorf("expected 1 fan-out directory, got %d", dirs)
+	}
+}
+
+func TestLocalStorageSystemIDStable(t *testing.T) {
+	root := t.TempDir()
+	first, err := cloud.NewLocalStorage(root)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	second, err := cloud.NewLocalStorage(root)
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if !strings.HasPrefix(first.StorageSystemID(), "local:") {
+		t.Errorf("StorageSystemID should start with 'local:', got %q", first.StorageSystemID())
+	}
+	if first.StorageSystemID() != second.StorageSystemID() {
+		t.Errorf("StorageSystemID not stable: %q != %q", first.StorageSystemID(), second.StorageSystemID())
+	}
+	other, err := cloud.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	if other.StorageSystemID() == first.StorageSystemID() {
+		t.Error("different roots should have different storage IDs")
+	}
+}
+
+func TestLocalStorageRemainingSize(t *testing.T) {
+	local, err := cloud.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	size, err := local.GetRemainingSize()
+	if err != nil {
+		t.Fatalf("GetRemainingSize failed: %v", err)
+	}
+	if size <= 0 {
+		t.Errorf("Expected positive remaining size, got %d", size)
+	}
+}
+
+func TestLocalStorageList(t *testing.T) {
+	local, err := cloud.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+	want := map[string]string{"docs/a.txt-chunk-0": "alpha", "b%20c": "beta"}
+	for name, data := range want {
+		if err := local.UploadChunk(name, []byte(data)); err != nil {
+			t.Fatalf("UploadChunk failed: %v", err)
+		}
+	}
+	objects, err := local.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	// The ID file is not an object
+	if len(objects) != len(want) {
+		t.Fatalf("List returned %d objects, want %d: %+v", len(objects), len(want), objects)
+	}
+	for _, obj := range objects {
+		data, ok := want[obj.Name]
+		if !ok || obj.Size != int64(len(data)) || obj.ModTime.IsZero() {
+			t.Errorf("unexpected object %+v", obj)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cloud
+
+import (
+	"context"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+// InstrumentedStorage records the duration, objects, bytes and errors of every call to
+// another backend in the metrics package, and the free space it reports. Wrapped by a
+// RetryStorage, it sees each attempt on its own. Calls are labelled with the backend's
+// ID at the time of the call, since some backends only learn theirs later.
+type InstrumentedStorage struct {
+	backend CloudStorage
+}
+
+// NewInstrumentedStorage wraps backend
+func NewInstrumentedStorage(backend CloudStorage) *InstrumentedStorage {
+	return &InstrumentedStorage{backend: backend}
+}
+
+// Unwrap returns the wrapped backend
+func (s *InstrumentedStorage) Unwrap() CloudStorage { return s.backend }
+
+func (s *InstrumentedStorage) UploadChunk(name string, data []byte) error {
+	return s.UploadChunkContext(context.Background(), name, data)
+}
+
+func (s *InstrumentedStorage) UploadChunkContext(ctx context.Context, name string, data []byte) error {
+	start := time.Now()
+	err := UploadChunk(ctx, s.backend, name, data)
+	metrics.ObserveBackend(s.backend.StorageSystemID(), "upload", len(data), start, err)
+	return err
+}
+
+func (s *InstrumentedStorage) GetChunk(name string) ([]byte, error) {
+	return s.GetChunkContext(context.Background(), name)
+}
+
+func (s *InstrumentedStorage) GetChunkContext(ctx context.Context, name string) ([]byte, error) {
+	start := time.Now()
+	data, err := GetChunk(ctx, s.backend, name)
+	metrics.ObserveBackend(s.backend.StorageSystemID(), "get", len(data), start, err)
+	return data, err
+}
+
+func (s *InstrumentedStorage) DeleteChunk(name string) error {
+	return s.DeleteChunkContext(context.Background(), name)
+}
+
+func (s *InstrumentedStorage) DeleteChunkContext(ctx context.Context, name string) error {
+	start := time.Now()
+	err := DeleteChunk(ctx, s.backend, name)
+	metrics.ObserveBackend(s.backend.StorageSystemID(), "delete", 0, start, err)
+	return err
+}
+
+func (s *InstrumentedStorage) GetRemainingSize() (int64, error) {
+	return s.GetRemainingSizeContext(context.Background())
+}
+
+func (s *InstrumentedStorage) GetRemainingSizeContext(ctx context.Context) (int64, error) {
+	start := time.Now()
+	size, err := GetRemainingSize(ctx, s.backend)
+	metrics.ObserveBackend(s.backend.StorageSystemID(), "query", 0, start, err)
+	if err == nil {
+		metrics.SetRemaining(s.backend.StorageSystemID(), size)
+	}
+	return size, err
+}
+
+func (s *InstrumentedStorage) List() ([]ObjectInfo, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *InstrumentedStorage) ListContext(ctx context.Context) ([]ObjectInfo, error) {
+	start := time.Now()
+	objects, err := List(ctx, s.backend)
+	metrics.ObserveBackend(s.backend.StorageSystemID(), "list", 0, start, err)
+	return objects, err
+}
+
+func (s *InstrumentedStorage) StorageSystemID() string {
+	return s.backend.StorageSystemID()
+}
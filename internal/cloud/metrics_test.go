@@ -0,0 +1,39 @@
+package cloud_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+)
+
+// lateIDStorage learns its storage ID only after it is created, like Dropbox when the
+// account lookup fails at startup
+type lateIDStorage struct {
+	*flakyStorage
+	mu sync.Mutex
+	id string
+}
+
+func (l *lateIDStorage) StorageSystemID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.id
+}
+
+func TestInstrumentedStorageFollowsID(t *testing.T) {
+	backend := &lateIDStorage{flakyStorage: &flakyStorage{chunks: map[string][]byte{}}, id: "late:unknown"}
+	s := cloud.NewInstrumentedStorage(backend)
+	if got := s.StorageSystemID(); got != "late:unknown" {
+		t.Fatalf("StorageSystemID = %q", got)
+	}
+	backend.mu.Lock()
+	backend.id = "late:account"
+	backend.mu.Unlock()
+	if got := s.StorageSystemID(); got != "late:account" {
+		t.Errorf("StorageSystemID = %q after the backend learned its ID", got)
+	}
+	if err := s.UploadChunk("a", []byte("data")); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package cloud
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/config"
+	errorsx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+// RetryPolicy bounds how often and how patiently an operation is retried
+type RetryPolicy struct {
+	MaxAttempts int           // tries per operation, including the first
+	BaseDelay   time.Duration // backoff before the first retry, doubled on every retry
+	MaxDelay    time.Duration // cap on the computed backoff
+	// MaxRetryAfter caps a wait requested by the provider; 0 waits as long as requested
+	MaxRetryAfter time.Duration
+}
+
+// RetryPolicyFromConfig converts the retry settings of one backend
+func RetryPolicyFromConfig(cfg config.RetryConfig) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   cfg.MaxAttempts,
+		BaseDelay:     time.Duration(cfg.BaseDelayMs) * time.Millisecond,
+		MaxDelay:      time.Duration(cfg.MaxDelayMs) * time.Millisecond,
+		MaxRetryAfter: time.Duration(cfg.MaxRetryAfterMs) * time.Millisecond,
+	}
+}
+
+// backoff returns the wait before retry n (1-based): exponential with equal jitter, so
+// concurrent workers hitting the same limit spread out
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// RetryStorage retries the transient failures of another backend, as classified by
+// errorsx.IsRetryable. A Retry-After requested by the provider is waited out instead of
+// the computed backoff, up to MaxRetryAfter; the context still bounds every wait.
+type RetryStorage struct {
+	backend CloudStorage
+	policy  RetryPolicy
+}
+
+// NewRetryStorage wraps backend with policy. A policy of one attempt or fewer disables retries.
+func NewRetryStorage(backend CloudStorage, policy RetryPolicy) *RetryStorage {
+	return &RetryStorage{backend: backend, policy: policy}
+}
+
+// Unwrap returns the wrapped backend
+func (r *RetryStorage) Unwrap() CloudStorage { return r.backend }
+
+func (r *RetryStorage) do(ctx context.Context, op, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !errorsx.IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= r.policy.MaxAttempts {
+			if attempt == 1 {
+				return err
+			}
+			return errorsx.Wrap(errorsx.ErrRetriesExhausted, err)
+		}
+		wait := errorsx.RetryAfter(err)
+		if wait <= 0 {
+			wait = r.policy.backoff(attempt)
+		} else if r.policy.MaxRetryAfter > 0 && wait > r.policy.MaxRetryAfter {
+			wait = r.policy.MaxRetryAfter
+		}
+		log.Info("%s %s on %s failed (attempt %d of %d), retrying in %v: %v",
+			op, name, r.backend.StorageSystemID(), attempt, r.policy.MaxAttempts, wait, err)
+		metrics.BackendRetry(r.backend.StorageSystemID(), op)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *RetryStorage) UploadChunk(name string, data []byte) error {
+	return r.UploadChunkContext(context.Background(), name, data)
+}
+
+func (r *RetryStorage) UploadChunkContext(ctx context.Context, name string, data []byte) error {
+	return r.do(ctx, "upload", name, func() error {
+		return UploadChunk(ctx, r.backend, name, data)
+	})
+}
+
+func (r *RetryStorage) GetChunk(name string) ([]byte, error) {
+	return r.GetChunkContext(context.Background(), name)
+}
+
+func (r *RetryStorage) GetChunkContext(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := r.do(ctx, "get", name, func() error {
+		var err error
+		data, err = GetChunk(ctx, r.backend, name)
+		return err
+	})
+	return data, err
+}
+
+func (r *RetryStorage) DeleteChunk(name string) error {
+	return r.DeleteChunkContext(context.Background(), name)
+}
+
+func (r *RetryStorage) DeleteChunkContext(ctx context.Context, name string) error {
+	return r.do(ctx, "delete", name, func() error {
+		return DeleteChunk(ctx, r.backend, name)
+	})
+}
+
+func (r *RetryStorage) GetRemainingSize() (int64, error) {
+	return r.GetRemainingSizeContext(context.Background())
+}
+
+func (r *RetryStorage) GetRemainingSizeContext(ctx context.Context) (int64, error) {
+	var size int64
+	err := r.do(ctx, "query", "remaining size", func() error {
+		var err error
+		size, err = GetRemainingSize(ctx, r.backend)
+		return err
+	})
+	return size, err
+}
+
+func (r *RetryStorage) List() ([]ObjectInfo, error) {
+	return r.ListContext(context.Background())
+}
+
+func (r *RetryStorage) ListContext(ctx context.Context) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := r.do(ctx, "list", "objects", func() error {
+		var err error
+		objects, err = List(ctx, r.backend)
+		return err
+	})
+	return objects, err
+}
+
+func (r *RetryStorage) StorageSystemID() string {
+	return r.backend.StorageSystemID()
+}
+
+// retryableStatus reports whether an HTTP status is worth retrying
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package cloud_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+	errorsx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// flakyStorage fails each operation with the queued errors before succeeding
+type flakyStorage struct {
+	mu       sync.Mutex
+	faults   []error
+	attempts int
+	chunks   map[string][]byte
+}
+
+func (f *flakyStorage) fault() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if len(f.faults) == 0 {
+		return nil
+	}
+	err := f.faults[0]
+	f.faults = f.faults[1:]
+	return err
+}
+
+func (f *flakyStorage) UploadChunk(name string, data []byte) error {
+	if err := f.fault(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chunks[name] = data
+	return nil
+}
+
+func (f *flakyStorage) GetChunk(name string) ([]byte, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.chunks[name]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (f *flakyStorage) DeleteChunk(name string) error    { return f.fault() }
+func (f *flakyStorage) GetRemainingSize() (int64, error) { return 1 << 30, f.fault() }
+func (f *flakyStorage) StorageSystemID() string          { return "flaky" }
+
+func (f *flakyStorage) List() ([]cloud.ObjectInfo, error) {
+	if err := f.fault(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []cloud.ObjectInfo
+	for name, data := range f.chunks {
+		result = append(result, cloud.ObjectInfo{Name: name, Size: int64(len(data))})
+	}
+	return result, nil
+}
+
+var (
+	errTransient = errorsx.Retryable(errors.New("connection reset"), 0)
+	errPermanent = errors.New("access denied")
+	testPolicy   = cloud.RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+)
+
+func TestRetryStorageRecovers(t *testing.T) {
+	backend := &flakyStorage{chunks: make(map[string][]byte), faults: []error{errTransient, errTransient}}
+	s := cloud.NewRetryStorage(backend, testPolicy)
+	if err := s.UploadChunk("a", []byte("data")); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	if backend.attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", backend.attempts)
+	}
+	backend.attempts = 0
+	backend.faults = []error{errTransient}
+	if data, err := s.GetChunk("a"); err != nil || string(data) != "data" {
+		t.Errorf("GetChunk = %q, %v", data, err)
+	}
+	if backend.attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", backend.attempts)
+	}
+}
+
+func TestRetryStorageGivesUp(t *testing.T) {
+	backend := &flakyStorage{chunks: make(map[string][]byte), faults: []error{errPermanent}}
+	s := cloud.NewRetryStorage(backend, testPolicy)
+	if err := s.DeleteChunk("a"); !errors.Is(err, errPermanent) || errors.Is(err, errorsx.ErrRetriesExhausted) {
+		t.Errorf("expected the permanent error as is, got %v", err)
+	}
+	if backend.attempts != 1 {
+		t.Errorf("permanent error was retried: %d attempts", backend.attempts)
+	}
+
+	backend.attempts = 0
+	backend.faults = []error{errTransient, errTransient, errTransient, errTransient, errTransient}
+	err := s.DeleteChunk("a")
+	if !errors.Is(err, errorsx.ErrRetriesExhausted) || !errorsx.IsRetryable(err) {
+		t.Errorf("expected ErrRetriesExhausted wrapping the last error, got %v", err)
+	}
+	if backend.attempts != testPolicy.MaxAttempts {
+		t.Errorf("expected %d attempts, got %d", testPolicy.MaxAttempts, backend.attempts)
+	}
+
+	// One attempt disables retries
+	backend.attempts = 0
+	backend.faults = []error{errTransient}
+	single := cloud.NewRetryStorage(backend, cloud.RetryPolicy{MaxAttempts: 1})
+	if err := single.DeleteChunk("a"); !errors.Is(err, errTransient) || backend.attempts != 1 {
+		t.Errorf("expected one failed attempt, got %v after %d", err, backend.attempts)
+	}
+}
+
+func TestRetryStorageHonorsRetryAfter(t *testing.T) {
+	limited := errorsx.Retryable(errors.New("too_many_requests"), 50*time.Millisecond)
+	backend := &flakyStorage{chunks: make(map[string][]byte), faults: []error{limited}}
+	s := cloud.NewRetryStorage(backend, testPolicy)
+	start := time.Now()
+	if err := s.UploadChunk("a", nil); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("retried after %v, before the requested 50ms", elapsed)
+	}
+
+	// The wait is abandoned when the context ends
+	backend.faults = []error{errorsx.Retryable(errors.New("too_many_requests"), time.Hour)}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cloud.UploadChunk(ctx, s, "a", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// A wait longer than MaxRetryAfter is cut short
+	capped := testPolicy
+	capped.MaxRetryAfter = 20 * time.Millisecond
+	s = cloud.NewRetryStorage(backend, capped)
+	backend.faults = []error{errorsx.Retryable(errors.New("too_many_requests"), time.Hour)}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cloud.UploadChunk(ctx, s, "a", nil); err != nil {
+		t.Errorf("expected a retry after 20ms, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package cloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/defaults"
+	errorsx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/sigv4"
+)
+
+// S3Storage stores chunks as objects in an S3-compatible bucket (AWS S3, MinIO, Ceph RGW, ...)
+// using plain HTTP requests signed with SigV4.
+type S3Storage struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	prefix    string
+	region    string
+	pathStyle bool
+	capacity  int64
+	creds     sigv4.Credentials
+}
+
+// NewS3Storage validates the bucket settings and returns a ready client
+func NewS3Storage(cfg config.S3Config) (*S3Storage, error) {
+	if cfg.Bucket == "" {
+		return nil, errorsx.WrapWithDetails(errorsx.ErrS3Init, "bucket is required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = defaults.DefaultS3Region
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, errorsx.WrapWithDetails(errorsx.ErrS3Init, "invalid endpoint "+endpoint)
+	}
+	prefix := cfg.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Storage{
+		client:    &http.Client{},
+		endpoint:  u,
+		bucket:    cfg.Bucket,
+		prefix:    strings.TrimPrefix(prefix, "/"),
+		region:    region,
+		pathStyle: cfg.PathStyle,
+		capacity:  cfg.Capacity,
+		creds:     sigv4.Credentials{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: cfg.SecretAccessKey},
+	}, nil
+}
+
+// SetHTTPClient overrides the client used for requests (custom transports, tests)
+func (s *S3Storage) SetHTTPClient(client *http.Client) {
+	s.client = client
+}
+
+// bucketURL returns the base URL for bucket-level requests
+func (s *S3Storage) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/"
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	}
+	return &u
+}
+
+func (s *S3Storage) objectURL(name string) *url.URL {
+	u := s.bucketURL()
+	u.Path += s.prefix + name
+	return u
+}
+
+// do signs and sends a request, turning non-2xx responses into errors wrapped with base.
+// Network failures, throttling and server errors are marked retryable.
+func (s *S3Storage) do(ctx context.Context, base error, method string, u *url.URL, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return nil, errorsx.Wrap(base, err)
+	}
+	sigv4.Sign(req, s.creds, s.region, "s3", sigv4.PayloadHash(body), time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			err = errorsx.Retryable(err, 0)
+		}
+		return nil, errorsx.Wrap(base, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		err := parseS3Error(resp)
+		if retryableStatus(resp.StatusCode) || err.Code == "SlowDown" {
+			return nil, errorsx.Wrap(base, errorsx.Retryable(err, parseRetryAfter(resp.Header.Get("Retry-After"))))
+		}
+		return nil, errorsx.Wrap(base, err)
+	}
+	return resp, nil
+}
+
+func (s *S3Storage) UploadChunk(name string, data []byte) error {
+	return s.UploadChunkContext(context.Background(), name, data)
+}
+
+func (s *S3Storage) UploadChunkContext(ctx context.Context, name string, data []byte) error {
+	if data == nil {
+		data = []byte{}
+	}
+	resp, err := s.do(ctx, errorsx.ErrS3Upload, http.MethodPut, s.objectURL(name), data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) GetChunk(name string) ([]byte, error) {
+	return s.GetChunkContext(context.Background(), name)
+}
+
+func (s *S3Storage) GetChunkContext(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, errorsx.ErrS3Download, http.MethodGet, s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() == nil {
+			err = errorsx.Retryable(err, 0)
+		}
+		return nil, errorsx.Wrap(errorsx.ErrS3Download, err)
+	}
+	return data, nil
+}
+
+func (s *S3Storage) DeleteChunk(name string) error {
+	return s.DeleteChunkContext(context.Background(), name)
+}
+
+func (s *S3Storage) DeleteChunkContext(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, errorsx.ErrS3Delete, http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetRemainingSize returns the configured capacity minus the bytes stored under the prefix.
+// Buckets without a configured capacity are treated as unbounded.
+func (s *S3Storage) GetRemainingSize() (int64, error) {
+	return s.GetRemainingSizeContext(context.Background())
+}
+
+func (s *S3Storage) GetRemainingSizeContext(ctx context.Context) (int64, error) {
+	if s.capacity <= 0 {
+		return math.MaxInt64, nil
+	}
+	objects, err := s.listObjects(ctx)
+	if err != nil {
+		return 0, err
+	}
+	used := int64(0)
+	for _, obj := range objects {
+		used += obj.Size
+	}
+	if used >= s.capacity {
+		return 0, nil
+	}
+	return s.capacity - used, nil
+}
+
+func (s *S3Storage) StorageSystemID() string {
+	return "s3:" + s.endpoint.Host + "/" + s.bucket + "/" + s.prefix
+}
+
+// List returns every object under the prefix, named without it
+func (s *S3Storage) List() ([]ObjectInfo, error) {
+	return s.ListContext(context.Background())
+}
+
+func (s *S3Storage) ListContext(ctx context.Context) ([]ObjectInfo, error) {
+	objects, err := s.listObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ObjectInfo, 0, len(objects))
+	for _, obj := range objects {
+		result = append(result, ObjectInfo{Name: obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return result, nil
+}
+
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listBucketResult struct {
+	Contents              []s3Object `xml:"Contents"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	NextContinuationToken string     `xml:"NextContinuationToken"`
+}
+
+// listObjects pages through ListObjectsV2 for every object under the prefix
+func (s *S3Storage) listObjects(ctx context.Context) ([]s3Object, error) {
+	var (
+		result []s3Object
+		token  string
+	)
+	for {
+		u := s.bucketURL()
+		q := url.Values{}
+		q.Set("list-type", "2")
+		if s.prefix != "" {
+			q.Set("prefix", s.prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = q.Encode()
+		resp, err := s.do(ctx, errorsx.ErrS3List, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errorsx.Wrap(errorsx.ErrS3List, err)
+		}
+		for _, obj := range page.Contents {
+			obj.Key = strings.TrimPrefix(obj.Key, s.prefix)
+			result = append(result, obj)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return result, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// parseS3Error extracts the S3 error code from a failed response
+func parseS3Error(resp *http.Response) *S3ResponseError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var e s3Error
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return &S3ResponseError{StatusCode: resp.StatusCode, Code: e.Code, Message: e.Message}
+	}
+	return &S3ResponseError{StatusCode: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+}
+
+// S3ResponseError is a non-2xx answer from an S3 endpoint
+type S3ResponseError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3ResponseError) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Message
+}
@@ -0,0 +1,267 @@
+package cloud_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/config"
+	errorsx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/sigv4"
+)
+
+const (
+	testS3AccessKey = "AKIATEST"
+	testS3Secret    = "secret/test/key"
+	testS3Bucket    = "chunks"
+)
+
+// fakeS3 is a minimal path-style S3 stand-in that checks SigV4 signatures
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	faults   []int // statuses answered, in order, before requests are served again
+	requests int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, err := sigv4.Verify(r, func(key string) (string, bool) {
+		return testS3Secret, key == testS3AccessKey
+	}, time.Now())
+	if err != nil {
+		f.writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testS3Bucket {
+		f.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if len(f.faults) > 0 {
+		status := f.faults[0]
+		f.faults = f.faults[1:]
+		f.writeError(w, status, http.StatusText(status))
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		if sigv4.PayloadHash(body) != r.Header.Get("X-Amz-Content-Sha256") {
+			f.writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+			return
+		}
+		f.objects[key] = body
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, k := range keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(f.objects[k]))
+		}
+		fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			f.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func newTestS3Storage(t *testing.T, endpoint string, cfg config.S3Config) *cloud.S3Storage {
+	cfg.Endpoint = endpoint
+	cfg.Bucket = testS3Bucket
+	cfg.PathStyle = true
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = testS3AccessKey
+		cfg.SecretAccessKey = testS3Secret
+	}
+	s3, err := cloud.NewS3Storage(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Storage failed: %v", err)
+	}
+	return s3
+}
+
+func TestS3StorageLifecycle(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s3 := newTestS3Storage(t, srv.URL, config.S3Config{Prefix: "storagex"})
+	chunkName := "file name+1.txt-chunk-0"
+	chunkData := []byte("hello, s3!")
+
+	if err := s3.UploadChunk(chunkName, chunkData); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	if _, ok := fake.objects["storagex/"+chunkName]; !ok {
+		t.Fatalf("object not stored under prefix, have %v", fake.objects)
+	}
+	data, err := s3.GetChunk(chunkName)
+	if err != nil {
+		t.Fatalf("GetChunk failed: %v", err)
+	}
+	if string(data) != string(chunkData) {
+		t.Errorf("GetChunk data mismatch: got %q, want %q", data, chunkData)
+	}
+	if err := s3.DeleteChunk(chunkName); err != nil {
+		t.Fatalf("DeleteChunk failed: %v", err)
+	}
+	_, err = s3.GetChunk(chunkName)
+	if !errors.Is(err, errorsx.ErrS3Download) || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("expected NoSuchKey download error, got %v", err)
+	}
+}
+
+func TestS3StorageBadCredentials(t *testing.T) {
+	_, srv := newFakeS3(t)
+	s3 := newTestS3Storage(t, srv.URL, config.S3Config{AccessKeyID: testS3AccessKey, SecretAccessKey: "wrong"})
+	err := s3.UploadChunk("chunk", []byte("data"))
+	if !errors.Is(err, errorsx.ErrS3Upload) || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("expected signature error, got %v", err)
+	}
+}
+
+func TestS3StorageRemainingSize(t *testing.T) {
+	_, srv := newFakeS3(t)
+	unbounded := newTestS3Storage(t, srv.URL, config.S3Config{})
+	if size, err := unbounded.GetRemainingSize(); err != nil || size <= 0 {
+		t.Errorf("expected unbounded remaining size, got %d, %v", size, err)
+	}
+
+	s3 := newTestS3Storage(t, srv.URL, config.S3Config{Prefix: "quota/", Capacity: 100})
+	if err := s3.UploadChunk("chunk", make([]byte, 40)); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	size, err := s3.GetRemainingSize()
+	if err != nil {
+		t.Fatalf("GetRemainingSize failed: %v", err)
+	}
+	if size != 60 {
+		t.Errorf("expected 60 bytes remaining, got %d", size)
+	}
+}
+
+func TestS3StorageList(t *testing.T) {
+	_, srv := newFakeS3(t)
+	other := newTestS3Storage(t, srv.URL, config.S3Config{Prefix: "other/"})
+	if err := other.UploadChunk("elsewhere", []byte("x")); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	s3 := newTestS3Storage(t, srv.URL, config.S3Config{Prefix: "chunks/"})
+	if err := s3.UploadChunk("docs/a.txt-chunk-0", []byte("alpha")); err != nil {
+		t.Fatalf("UploadChunk failed: %v", err)
+	}
+	objects, err := s3.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Name != "docs/a.txt-chunk-0" || objects[0].Size != 5 {
+		t.Errorf("List = %+v, want only the chunk under the prefix, named without it", objects)
+	}
+}
+
+func TestS3StorageSystemID(t *testing.T) {
+	s3, err := cloud.NewS3Storage(config.S3Config{Endpoint: "http://minio.local:9000", Bucket: "b", Prefix: "p"})
+	if err != nil {
+		t.Fatalf("NewS3Storage failed: %v", err)
+	}
+	if id := s3.StorageSystemID(); id != "s3:minio.local:9000/b/p/" {
+		t.Errorf("unexpected StorageSystemID %q", id)
+	}
+	if _, err := cloud.NewS3Storage(config.S3Config{}); !errors.Is(err, errorsx.ErrS3Init) {
+		t.Errorf("expected ErrS3Init without bucket, got %v", err)
+	}
+}
+
+func TestS3StorageContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	s3 := newTestS3Storage(t, srv.URL, config.S3Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := cloud.GetChunk(ctx, s3, "stuck")
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errorsx.ErrS3Download) {
+		t.Errorf("expected a deadline error wrapped in ErrS3Download, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("request was not abandoned at the deadline")
+	}
+}
+
+func TestS3StorageRetriesTransientErrors(t *testing.T) {
+	fake, srv := newFakeS3(t)
+	s3 := cloud.NewRetryStorage(newTestS3Storage(t, srv.URL, config.S3Config{}),
+		cloud.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond})
+
+	fake.faults = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	if err := s3.UploadChunk("a", []byte("data")); err != nil {
+		t.Fatalf("upload after transient errors failed: %v", err)
+	}
+	if fake.requests != 3 {
+		t.Errorf("expected 3 requests, got %d", fake.requests)
+	}
+
+	// Client errors are permanent and returned at once
+	fake.requests = 0
+	if _, err := s3.GetChunk("missing"); err == nil || errorsx.IsRetryable(err) {
+		t.Errorf("expected a permanent error for a missing key, got %v", err)
+	}
+	if fake.requests != 1 {
+		t.Errorf("permanent error was retried: %d requests", fake.requests)
+	}
+
+	fake.faults = []int{500, 500, 500}
+	fake.requests = 0
+	_, err := s3.GetChunk("a")
+	if !errors.Is(err, errorsx.ErrRetriesExhausted) || !errors.Is(err, errorsx.ErrS3Download) {
+		t.Errorf("expected ErrRetriesExhausted wrapping ErrS3Download, got %v", err)
+	}
+	if fake.requests != 3 {
+		t.Errorf("expected 3 attempts, got %d", fake.requests)
+	}
+}
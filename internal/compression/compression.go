@@ -0,0 +1,120 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// Codec names accepted in config and recorded per chunk in metadata
+const (
+	CodecNone = "none"
+	CodecZstd = "zstd"
+	CodecGzip = "gzip"
+	CodecLZ4  = "lz4"
+
+	// CodecAuto compresses with zstd but stores the data raw when that saves less than
+	// 1/minSavingsRatio of its size, e.g. for already-compressed media. It is never recorded.
+	CodecAuto = "auto"
+)
+
+const minSavingsRatio = 8
+
+// EncodeAll is safe for concurrent use
+var zstdEncoder = mustZstdEncoder()
+
+func mustZstdEncoder() *zstd.Encoder {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic("compression: zstd encoder: " + err.Error())
+	}
+	return enc
+}
+
+// Valid reports whether codec can be used for uploads
+func Valid(codec string) bool {
+	switch codec {
+	case "", CodecNone, CodecZstd, CodecGzip, CodecLZ4, CodecAuto:
+		return true
+	}
+	return false
+}
+
+// Compress encodes data with codec and returns the codec actually used, which differs
+// from the requested one only for CodecAuto
+func Compress(codec string, data []byte) (string, []byte, error) {
+	switch codec {
+	case "", CodecNone:
+		return CodecNone, data, nil
+	case CodecAuto:
+		out := zstdEncoder.EncodeAll(data, nil)
+		if len(data)-len(out) < len(data)/minSavingsRatio || len(out) >= len(data) {
+			return CodecNone, data, nil
+		}
+		return CodecZstd, out, nil
+	case CodecZstd:
+		return CodecZstd, zstdEncoder.EncodeAll(data, nil), nil
+	case CodecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return "", nil, errorx.Wrap(errorx.ErrCompressFailed, err)
+		}
+		if err := w.Close(); err != nil {
+			return "", nil, errorx.Wrap(errorx.ErrCompressFailed, err)
+		}
+		return CodecGzip, buf.Bytes(), nil
+	case CodecLZ4:
+		var buf bytes.Buffer
+		w := lz4.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return "", nil, errorx.Wrap(errorx.ErrCompressFailed, err)
+		}
+		if err := w.Close(); err != nil {
+			return "", nil, errorx.Wrap(errorx.ErrCompressFailed, err)
+		}
+		return CodecLZ4, buf.Bytes(), nil
+	}
+	return "", nil, errorx.WrapWithDetails(errorx.ErrUnknownCodec, codec)
+}
+
+// Decompress reverses Compress. size is the original data length; output of any other
+// length is rejected. Every codec decodes as a stream cut off after size+1 bytes, so a
+// corrupt object cannot claim more memory than the chunk it claims to be.
+func Decompress(codec string, data []byte, size int64) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch codec {
+	case "", CodecNone:
+		out = data
+	case CodecZstd:
+		var r *zstd.Decoder
+		if r, err = zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1)); err == nil {
+			out, err = io.ReadAll(io.LimitReader(r, size+1))
+			r.Close()
+		}
+	case CodecGzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			out, err = io.ReadAll(io.LimitReader(r, size+1))
+		}
+	case CodecLZ4:
+		out, err = io.ReadAll(io.LimitReader(lz4.NewReader(bytes.NewReader(data)), size+1))
+	default:
+		return nil, errorx.WrapWithDetails(errorx.ErrUnknownCodec, codec)
+	}
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDecompressFailed, err)
+	}
+	if int64(len(out)) != size {
+		return nil, errorx.WrapWithDetails(errorx.ErrDecompressFailed, "decompressed size does not match")
+	}
+	return out, nil
+}
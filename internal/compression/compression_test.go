@@ -0,0 +1,83 @@
+package compression_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"runtime"
+	"testing"
+
+	"github.com/sayuyere/storageX/internal/compression"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("2024-01-01 INFO request served in 3ms\n"), 200)
+	for _, codec := range []string{compression.CodecNone, compression.CodecZstd, compression.CodecGzip, compression.CodecLZ4} {
+		used, out, err := compression.Compress(codec, data)
+		if err != nil {
+			t.Fatalf("%s: Compress failed: %v", codec, err)
+		}
+		if used != codec {
+			t.Errorf("%s: reported codec %q", codec, used)
+		}
+		if codec != compression.CodecNone && len(out) >= len(data)/4 {
+			t.Errorf("%s: log data only shrank to %d of %d bytes", codec, len(out), len(data))
+		}
+		got, err := compression.Decompress(used, out, int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: Decompress failed: %v", codec, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: round trip mismatch", codec)
+		}
+	}
+}
+
+func TestCompressAuto(t *testing.T) {
+	text := bytes.Repeat([]byte("compressible "), 100)
+	if used, _, _ := compression.Compress(compression.CodecAuto, text); used != compression.CodecZstd {
+		t.Errorf("expected zstd for compressible data, got %q", used)
+	}
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+	used, out, err := compression.Compress(compression.CodecAuto, random)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if used != compression.CodecNone || !bytes.Equal(out, random) {
+		t.Errorf("expected random data stored raw, got codec %q", used)
+	}
+}
+
+func TestDecompressRejectsBadInput(t *testing.T) {
+	data := bytes.Repeat([]byte("abc"), 100)
+	_, out, _ := compression.Compress(compression.CodecZstd, data)
+	if _, err := compression.Decompress(compression.CodecZstd, out, int64(len(data)-1)); !errors.Is(err, errorx.ErrDecompressFailed) {
+		t.Errorf("expected ErrDecompressFailed on size mismatch, got %v", err)
+	}
+	if _, err := compression.Decompress(compression.CodecGzip, []byte("not gzip"), 3); !errors.Is(err, errorx.ErrDecompressFailed) {
+		t.Errorf("expected ErrDecompressFailed on corrupt input, got %v", err)
+	}
+	if _, _, err := compression.Compress("brotli", data); !errors.Is(err, errorx.ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec, got %v", err)
+	}
+	if compression.Valid("brotli") || !compression.Valid(compression.CodecAuto) {
+		t.Error("Valid returned the wrong answer")
+	}
+}
+
+func TestDecompressBoundsMemory(t *testing.T) {
+	// A small zstd frame that expands to 256 MiB, recorded as a 4 KiB chunk
+	_, bomb, _ := compression.Compress(compression.CodecZstd, make([]byte, 256<<20))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := compression.Decompress(compression.CodecZstd, bomb, 4096); !errors.Is(err, errorx.ErrDecompressFailed) {
+		t.Fatalf("expected ErrDecompressFailed, got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if grown := after.TotalAlloc - before.TotalAlloc; grown > 64<<20 {
+		t.Errorf("decompressing a 4 KiB chunk allocated %d bytes", grown)
+	}
+}
@@ -15,10 +15,25 @@ type LogConfig struct {
 }
 
 type CloudConfig struct {
-	DropboxAccessTokens []string `json:"dropbox_access_tokens,omitempty"`
+	DropboxAccessTokens []string   `json:"dropbox_access_tokens,omitempty"`
+	LocalPaths          []string   `json:"local_paths,omitempty"` // Directories (local disk or NAS mounts) used as storage backends
+	S3                  []S3Config `json:"s3,omitempty"`          // S3-compatible buckets (AWS, MinIO, Ceph RGW, ...)
 	// Add other provider configs here
 }
 
+// S3Config describes one S3-compatible bucket. Credentials may be given as
+// environment variable names, like Dropbox tokens.
+type S3Config struct {
+	Endpoint        string `json:"endpoint,omitempty"` // defaults to https://s3.<region>.amazonaws.com
+	Region          string `json:"region,omitempty"`
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"` // key prefix for all chunks
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	PathStyle       bool   `json:"path_style,omitempty"` // use endpoint/bucket/key instead of bucket.endpoint/key
+	Capacity        int64  `json:"capacity,omitempty"`   // optional quota in bytes; 0 means unbounded
+}
+
 type MetaDataServiceConfig struct {
 	DBPath string `json:"db_path"`
 }
@@ -28,12 +43,154 @@ type ParallelConfig struct {
 	Download int `json:"download_workers"`
 }
 
+// ReplicationConfig controls how many distinct backends hold each chunk
+type ReplicationConfig struct {
+	Factor      int `json:"factor"`       // copies of each chunk, on distinct backends
+	WriteQuorum int `json:"write_quorum"` // copies that must be written for an upload to succeed (0 = all)
+}
+
+// PlacementConfig selects how backends are chosen for new chunks
+type PlacementConfig struct {
+	Policy  string         `json:"policy"`            // first, round_robin, weighted, capacity or hash
+	Weights map[string]int `json:"weights,omitempty"` // storage system ID -> weight, for the weighted policy
+}
+
+// ErasureConfig sets the Reed-Solomon layout used by erasure-coded uploads
+type ErasureConfig struct {
+	DataShards   int `json:"data_shards"`   // chunks per stripe
+	ParityShards int `json:"parity_shards"` // backends a stripe can lose
+}
+
+// ChunkingConfig selects how files are cut into chunks. Sizes are bytes of chunk data and
+// only apply to the fastcdc strategy; the fixed strategy uses chunk_size.
+type ChunkingConfig struct {
+	Strategy string `json:"strategy"` // fixed or fastcdc
+	MinSize  int    `json:"min_size,omitempty"`
+	AvgSize  int    `json:"avg_size,omitempty"` // must be a power of two
+	MaxSize  int    `json:"max_size,omitempty"`
+}
+
+// CompressionConfig selects the default chunk compression codec
+type CompressionConfig struct {
+	Codec string `json:"codec"` // none, zstd, gzip, lz4 or auto (zstd, raw when it does not help)
+}
+
+// EncryptionConfig enables client-side chunk encryption. MasterKey may be the key itself
+// (hex or base64) or the name of an environment variable holding it.
+type EncryptionConfig struct {
+	Enabled   bool   `json:"enabled"`
+	MasterKey string `json:"master_key,omitempty"`
+	KeyFile   string `json:"key_file,omitempty"` // used when master_key is empty
+}
+
+// UploadConfig controls resumable upload sessions
+type UploadConfig struct {
+	SessionTTLHours int `json:"session_ttl_hours"` // how long an unfinished upload can be resumed
+}
+
+// RetryConfig controls how transient provider errors are retried. Backends overrides it
+// per storage system ID; zero fields in an override inherit the top-level values.
+type RetryConfig struct {
+	MaxAttempts     int                    `json:"max_attempts"`       // tries per operation, including the first (1 disables retries)
+	BaseDelayMs     int                    `json:"base_delay_ms"`      // first backoff, doubled on every retry
+	MaxDelayMs      int                    `json:"max_delay_ms"`       // cap on the computed backoff
+	MaxRetryAfterMs int                    `json:"max_retry_after_ms"` // cap on a wait requested by the provider
+	Backends        map[string]RetryConfig `json:"backends,omitempty"` // storage system ID -> overrides
+}
+
+// For returns the retry settings of the backend with the given storage system ID
+func (r RetryConfig) For(storageID string) RetryConfig {
+	out := RetryConfig{MaxAttempts: r.MaxAttempts, BaseDelayMs: r.BaseDelayMs, MaxDelayMs: r.MaxDelayMs, MaxRetryAfterMs: r.MaxRetryAfterMs}
+	o, ok := r.Backends[storageID]
+	if !ok {
+		return out
+	}
+	if o.MaxAttempts > 0 {
+		out.MaxAttempts = o.MaxAttempts
+	}
+	if o.BaseDelayMs > 0 {
+		out.BaseDelayMs = o.BaseDelayMs
+	}
+	if o.MaxDelayMs > 0 {
+		out.MaxDelayMs = o.MaxDelayMs
+	}
+	if o.MaxRetryAfterMs > 0 {
+		out.MaxRetryAfterMs = o.MaxRetryAfterMs
+	}
+	return out
+}
+
+// VersioningConfig sets the retention rules applied after every upload. The current
+// version is always kept; with both rules at 0 no version is ever pruned.
+type VersioningConfig struct {
+	KeepLast      int `json:"keep_last"`       // newest versions kept regardless of age
+	KeepDailyDays int `json:"keep_daily_days"` // for this many days, the newest version of each day is kept
+}
+
+// GatewayConfig sets up the S3-compatible gateway. Access key secrets may be given as
+// environment variable names, like Dropbox tokens.
+type GatewayConfig struct {
+	Bucket     string       `json:"bucket"`                // the one bucket served, holding every file
+	Region     string       `json:"region,omitempty"`      // reported by GetBucketLocation
+	StagingDir string       `json:"staging_dir,omitempty"` // object bodies and multipart parts wait here until stored (default the system temp dir)
+	AccessKeys []GatewayKey `json:"access_keys"`
+}
+
+// GatewayKey is an access key pair accepted by the gateway
+type GatewayKey struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+}
+
+// WebDAVConfig sets up the WebDAV server. Passwords may be given as environment variable
+// names, like Dropbox tokens.
+type WebDAVConfig struct {
+	StagingDir string       `json:"staging_dir,omitempty"` // uploads are written here before being stored (default the system temp dir)
+	Users      []WebDAVUser `json:"users,omitempty"`       // basic auth accounts; without any, access is anonymous
+}
+
+// WebDAVUser is an account allowed to use the WebDAV server
+type WebDAVUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// GRPCConfig sets up the gRPC API. Tokens may be given as environment variable names,
+// like Dropbox tokens.
+type GRPCConfig struct {
+	CertFile   string   `json:"cert_file,omitempty"`   // TLS certificate; without it the API is served in plaintext
+	KeyFile    string   `json:"key_file,omitempty"`    // private key of cert_file
+	Tokens     []string `json:"tokens,omitempty"`      // bearer tokens accepted; without any, access is anonymous
+	StagingDir string   `json:"staging_dir,omitempty"` // uploads are written here before being stored (default the system temp dir)
+}
+
+// MetricsConfig sets up Prometheus metrics. Servers expose them on /metrics; CLI runs
+// write them to Textfile when it is set.
+type MetricsConfig struct {
+	Textfile               string `json:"textfile,omitempty"`                 // written on exit, for the node exporter's textfile collector
+	CapacityRefreshSeconds int    `json:"capacity_refresh_seconds,omitempty"` // how often servers query the remaining size of every backend
+}
+
 type AppConfig struct {
-	ChunkSize int                   `json:"chunk_size"`
-	Cloud     CloudConfig           `json:"cloud"`
-	Log       LogConfig             `json:"log"`
-	Meta      MetaDataServiceConfig `json:"metadata"`
-	Parallel  ParallelConfig        `json:"parallel"`
+	ChunkSize   int                   `json:"chunk_size"`
+	Chunking    ChunkingConfig        `json:"chunking"`
+	Cloud       CloudConfig           `json:"cloud"`
+	Log         LogConfig             `json:"log"`
+	Meta        MetaDataServiceConfig `json:"metadata"`
+	Parallel    ParallelConfig        `json:"parallel"`
+	Replication ReplicationConfig     `json:"replication"`
+	Placement   PlacementConfig       `json:"placement"`
+	StorageMode string                `json:"storage_mode"` // default upload mode: replicate or erasure
+	Erasure     ErasureConfig         `json:"erasure"`
+	Compression CompressionConfig     `json:"compression"`
+	Encryption  EncryptionConfig      `json:"encryption"`
+	Upload      UploadConfig          `json:"upload"`
+	Retry       RetryConfig           `json:"retry"`
+	Versioning  VersioningConfig      `json:"versioning"`
+	Gateway     GatewayConfig         `json:"gateway"`
+	WebDAV      WebDAVConfig          `json:"webdav"`
+	GRPC        GRPCConfig            `json:"grpc"`
+	Metrics     MetricsConfig         `json:"metrics"`
 }
 
 var (
@@ -58,18 +215,45 @@ func LookupSecrets(cfg *AppConfig) {
 			cfg.Cloud.DropboxAccessTokens[i] = os.Getenv(cfg.Cloud.DropboxAccessTokens[i])
 		}
 	}
+	for i := range cfg.Cloud.S3 {
+		s3 := &cfg.Cloud.S3[i]
+		if v := os.Getenv(s3.AccessKeyID); s3.AccessKeyID != "" && v != "" {
+			s3.AccessKeyID = v
+		}
+		if v := os.Getenv(s3.SecretAccessKey); s3.SecretAccessKey != "" && v != "" {
+			s3.SecretAccessKey = v
+		}
+		if s3.Region == "" {
+			s3.Region = defaults.DefaultS3Region
+		}
+	}
+	if v := os.Getenv(cfg.Encryption.MasterKey); cfg.Encryption.MasterKey != "" && v != "" {
+		cfg.Encryption.MasterKey = v
+	}
+	for i := range cfg.Gateway.AccessKeys {
+		key := &cfg.Gateway.AccessKeys[i]
+		if v := os.Getenv(key.SecretAccessKey); key.SecretAccessKey != "" && v != "" {
+			key.SecretAccessKey = v
+		}
+	}
+	for i := range cfg.WebDAV.Users {
+		user := &cfg.WebDAV.Users[i]
+		if v := os.Getenv(user.Password); user.Password != "" && v != "" {
+			user.Password = v
+		}
+	}
+	for i, token := range cfg.GRPC.Tokens {
+		if v := os.Getenv(token); token != "" && v != "" {
+			cfg.GRPC.Tokens[i] = v
+		}
+	}
 }
 func UpdatePaths(cfg *AppConfig) {
 	if cfg.Meta.DBPath == "" {
 		cfg.Meta.DBPath = defaults.DefaultDBPath
 	}
 	// Expand ~ to home directory if present
-	if len(cfg.Meta.DBPath) > 0 && cfg.Meta.DBPath[0] == '~' {
-		home, err := os.UserHomeDir()
-		if err == nil {
-			cfg.Meta.DBPath = filepath.Join(home, cfg.Meta.DBPath[1:])
-		}
-	}
+	cfg.Meta.DBPath = expandHome(cfg.Meta.DBPath)
 	// Convert DBPath to absolute path if it's relative
 	if !filepath.IsAbs(cfg.Meta.DBPath) {
 		absPath, err := filepath.Abs(cfg.Meta.DBPath)
@@ -78,12 +262,105 @@ func UpdatePaths(cfg *AppConfig) {
 			cfg.Meta.DBPath = absPath
 		}
 	}
+	for i, path := range cfg.Cloud.LocalPaths {
+		path = expandHome(path)
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+		cfg.Cloud.LocalPaths[i] = path
+	}
+	if cfg.Encryption.KeyFile != "" {
+		cfg.Encryption.KeyFile = expandHome(cfg.Encryption.KeyFile)
+	}
+	if cfg.Gateway.StagingDir != "" {
+		cfg.Gateway.StagingDir = expandHome(cfg.Gateway.StagingDir)
+	}
+	if cfg.WebDAV.StagingDir != "" {
+		cfg.WebDAV.StagingDir = expandHome(cfg.WebDAV.StagingDir)
+	}
+	if cfg.GRPC.StagingDir != "" {
+		cfg.GRPC.StagingDir = expandHome(cfg.GRPC.StagingDir)
+	}
+	if cfg.GRPC.CertFile != "" {
+		cfg.GRPC.CertFile = expandHome(cfg.GRPC.CertFile)
+	}
+	if cfg.GRPC.KeyFile != "" {
+		cfg.GRPC.KeyFile = expandHome(cfg.GRPC.KeyFile)
+	}
+	if cfg.Metrics.Textfile != "" {
+		cfg.Metrics.Textfile = expandHome(cfg.Metrics.Textfile)
+	}
+	if cfg.Metrics.CapacityRefreshSeconds <= 0 {
+		cfg.Metrics.CapacityRefreshSeconds = defaults.DefaultCapacityRefreshSeconds
+	}
+	if cfg.Gateway.Bucket == "" {
+		cfg.Gateway.Bucket = defaults.DefaultGatewayBucket
+	}
+	if cfg.Gateway.Region == "" {
+		cfg.Gateway.Region = defaults.DefaultS3Region
+	}
 	if cfg.Parallel.Upload <= 0 {
 		cfg.Parallel.Upload = defaults.DefaultStorageUploadWorkers // default upload workers
 	}
 	if cfg.Parallel.Download <= 0 {
 		cfg.Parallel.Download = defaults.DefaultStorageDownloadWorkers // default download workers
 	}
+	if cfg.Chunking.Strategy == "" {
+		cfg.Chunking.Strategy = defaults.DefaultChunkingStrategy
+	}
+	if cfg.Chunking.MinSize <= 0 {
+		cfg.Chunking.MinSize = defaults.DefaultCDCMinSize
+	}
+	if cfg.Chunking.AvgSize <= 0 {
+		cfg.Chunking.AvgSize = defaults.DefaultCDCAvgSize
+	}
+	if cfg.Chunking.MaxSize <= 0 {
+		cfg.Chunking.MaxSize = defaults.DefaultCDCMaxSize
+	}
+	if cfg.Compression.Codec == "" {
+		cfg.Compression.Codec = defaults.DefaultCompressionCodec
+	}
+	if cfg.Upload.SessionTTLHours <= 0 {
+		cfg.Upload.SessionTTLHours = defaults.DefaultUploadSessionTTLHours
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry.MaxAttempts = defaults.DefaultRetryMaxAttempts
+	}
+	if cfg.Retry.BaseDelayMs <= 0 {
+		cfg.Retry.BaseDelayMs = defaults.DefaultRetryBaseDelayMs
+	}
+	if cfg.Retry.MaxDelayMs <= 0 {
+		cfg.Retry.MaxDelayMs = defaults.DefaultRetryMaxDelayMs
+	}
+	if cfg.Retry.MaxRetryAfterMs <= 0 {
+		cfg.Retry.MaxRetryAfterMs = defaults.DefaultRetryMaxRetryAfterMs
+	}
+	if cfg.StorageMode == "" {
+		cfg.StorageMode = defaults.DefaultStorageMode
+	}
+	if cfg.Erasure.DataShards <= 0 {
+		cfg.Erasure.DataShards = defaults.DefaultErasureDataShards
+	}
+	if cfg.Erasure.ParityShards <= 0 {
+		cfg.Erasure.ParityShards = defaults.DefaultErasureParityShards
+	}
+	if cfg.Replication.Factor <= 0 {
+		cfg.Replication.Factor = defaults.DefaultReplicationFactor
+	}
+	if cfg.Replication.WriteQuorum <= 0 || cfg.Replication.WriteQuorum > cfg.Replication.Factor {
+		cfg.Replication.WriteQuorum = cfg.Replication.Factor
+	}
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if len(path) > 0 && path[0] == '~' {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
 }
 
 // LoadConfig loads configuration from the given JSON file path.
@@ -93,6 +370,12 @@ func LoadConfig(path string) (*AppConfig, error) {
 	configOnce.Do(func() {
 		defaultConfig := &AppConfig{
 			ChunkSize: defaults.DefaultChunkSize,
+			Chunking: ChunkingConfig{
+				Strategy: defaults.DefaultChunkingStrategy,
+				MinSize:  defaults.DefaultCDCMinSize,
+				AvgSize:  defaults.DefaultCDCAvgSize,
+				MaxSize:  defaults.DefaultCDCMaxSize,
+			},
 			Cloud: CloudConfig{
 				DropboxAccessTokens: []string{},
 			},
@@ -106,6 +389,31 @@ func LoadConfig(path string) (*AppConfig, error) {
 				Upload:   4,
 				Download: 4,
 			},
+			Replication: ReplicationConfig{
+				Factor:      defaults.DefaultReplicationFactor,
+				WriteQuorum: defaults.DefaultReplicationFactor,
+			},
+			StorageMode: defaults.DefaultStorageMode,
+			Compression: CompressionConfig{
+				Codec: defaults.DefaultCompressionCodec,
+			},
+			Erasure: ErasureConfig{
+				DataShards:   defaults.DefaultErasureDataShards,
+				ParityShards: defaults.DefaultErasureParityShards,
+			},
+			Retry: RetryConfig{
+				MaxAttempts:     defaults.DefaultRetryMaxAttempts,
+				BaseDelayMs:     defaults.DefaultRetryBaseDelayMs,
+				MaxDelayMs:      defaults.DefaultRetryMaxDelayMs,
+				MaxRetryAfterMs: defaults.DefaultRetryMaxRetryAfterMs,
+			},
+			Upload: UploadConfig{
+				SessionTTLHours: defaults.DefaultUploadSessionTTLHours,
+			},
+			Gateway: GatewayConfig{
+				Bucket: defaults.DefaultGatewayBucket,
+				Region: defaults.DefaultS3Region,
+			},
 		}
 		f, e := os.Open(path)
 		if e != nil {
@@ -2,6 +2,7 @@ package config_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/sayuyere/storageX/internal/config"
@@ -71,3 +72,35 @@ func TestLoadConfig_JSON(t *testing.T) {
 		t.Errorf("expected db_path test.db, got %q", cfg.Meta.DBPath)
 	}
 }
+
+func TestUpdatePaths_LocalPaths(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	cfg := &config.AppConfig{
+		Cloud: config.CloudConfig{LocalPaths: []string{"~/storagex-chunks", "relative/chunks"}},
+	}
+	config.UpdatePaths(cfg)
+	if cfg.Cloud.LocalPaths[0] != filepath.Join(home, "storagex-chunks") {
+		t.Errorf("expected ~ to expand to home, got %q", cfg.Cloud.LocalPaths[0])
+	}
+	if !filepath.IsAbs(cfg.Cloud.LocalPaths[1]) {
+		t.Errorf("expected absolute local path, got %q", cfg.Cloud.LocalPaths[1])
+	}
+}
+
+func TestRetryConfig_For(t *testing.T) {
+	cfg := &config.AppConfig{Retry: config.RetryConfig{
+		MaxAttempts: 2,
+		Backends:    map[string]config.RetryConfig{"dropbox:dbid:A": {MaxAttempts: 8, MaxDelayMs: 60000}},
+	}}
+	config.UpdatePaths(cfg)
+	r := cfg.Retry.For("dropbox:dbid:A")
+	if r.MaxAttempts != 8 || r.MaxDelayMs != 60000 || r.BaseDelayMs != cfg.Retry.BaseDelayMs || r.MaxRetryAfterMs != cfg.Retry.MaxRetryAfterMs || r.MaxRetryAfterMs == 0 {
+		t.Errorf("override not merged with defaults: %+v", r)
+	}
+	if r := cfg.Retry.For("local:x"); r.MaxAttempts != 2 || r.Backends != nil {
+		t.Errorf("expected top-level settings for other backends, got %+v", r)
+	}
+}
@@ -0,0 +1,182 @@
+package dav
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+	"golang.org/x/net/webdav"
+)
+
+// Handler serves the stored namespace over WebDAV, so it can be mounted by desktop file
+// managers and davfs. PROPFIND, GET with Range, PUT, DELETE, MKCOL, COPY, MOVE and
+// LOCK/UNLOCK are handled by golang.org/x/net/webdav over a fileSystem; locks are kept in
+// memory. A GET on a directory answers a plain HTML listing for browsers.
+//
+// When users are configured every request must carry one of them in basic auth;
+// otherwise access is anonymous.
+type Handler struct {
+	fs    *fileSystem
+	dav   *webdav.Handler
+	users map[string]string // username -> password
+}
+
+// NewHandler returns a WebDAV handler for the given services. It creates a staging
+// directory for uploads below cfg.StagingDir, removed again by Close.
+func NewHandler(st *storage.StorageService, meta *metadata.MetadataService, cfg config.WebDAVConfig) (*Handler, error) {
+	staging, err := os.MkdirTemp(cfg.StagingDir, "storagex-dav-")
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]string, len(cfg.Users))
+	for _, u := range cfg.Users {
+		users[u.Username] = u.Password
+	}
+	fs := &fileSystem{storage: st, meta: meta, staging: staging, started: time.Now(), dirs: make(map[string]bool)}
+	return &Handler{
+		fs:    fs,
+		users: users,
+		dav: &webdav.Handler{
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+			Logger:     logError,
+		},
+	}, nil
+}
+
+// Close removes the staging directory
+func (h *Handler) Close() error {
+	return os.RemoveAll(h.fs.staging)
+}
+
+func logError(r *http.Request, err error) {
+	// Clients probe for files all the time; only real failures are worth logging
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error("WebDAV %s %s failed: %v", r.Method, r.URL.Path, err)
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="storagex", charset="UTF-8"`)
+		http.Error(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body := &trackedBody{ReadCloser: r.Body}
+		r = r.WithContext(context.WithValue(r.Context(), bodyKey{}, body))
+		r.Body = body
+	case http.MethodGet, http.MethodHead:
+		if info, err := h.fs.Stat(r.Context(), r.URL.Path); err == nil && info.IsDir() {
+			h.serveDir(w, r)
+			return
+		}
+	}
+	h.dav.ServeHTTP(w, r)
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	if len(h.users) == 0 {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, known := h.users[user]
+	return known && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+}
+
+// bodyKey is the context key of the trackedBody of a PUT
+type bodyKey struct{}
+
+// trackedBody remembers why reading a request body failed. The webdav package closes
+// the file it copied the body into whether or not the copy succeeded, so writeFile.Close
+// checks it before storing anything.
+type trackedBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// bodyError returns the error that cut short the request body read under ctx, if any
+func bodyError(ctx context.Context) error {
+	if body, ok := ctx.Value(bodyKey{}).(*trackedBody); ok {
+		return body.err
+	}
+	return nil
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body><h1>Index of {{.Path}}</h1>
+<table>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+type listingEntry struct {
+	Name, Href, Size, Modified string
+}
+
+// serveDir answers a GET on a directory with an HTML listing
+func (h *Handler) serveDir(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		// Relative links in the listing need the trailing slash
+		http.Redirect(w, r, r.URL.EscapedPath()+"/", http.StatusMovedPermanently)
+		return
+	}
+	p, _ := davPath(r.URL.Path)
+	infos, err := h.fs.readDir(p)
+	if err != nil {
+		log.Error("WebDAV listing %s failed: %v", r.URL.Path, err)
+		http.Error(w, "listing failed", http.StatusInternalServerError)
+		return
+	}
+	entries := make([]listingEntry, 0, len(infos))
+	for _, info := range infos {
+		e := listingEntry{Name: info.Name(), Href: url.PathEscape(info.Name()), Size: "-"}
+		if info.IsDir() {
+			e.Name += "/"
+			e.Href += "/"
+		} else {
+			e.Size = strconv.FormatInt(info.Size(), 10)
+			e.Modified = info.ModTime().Format("2006-01-02 15:04")
+		}
+		entries = append(entries, e)
+	}
+	title := "/"
+	if p != "" {
+		title = "/" + p + "/"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []listingEntry
+	}{title, entries})
+	if err != nil {
+		log.Error("Writing WebDAV listing failed: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package dav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/manager"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+func setupHandler(t *testing.T, cfg config.WebDAVConfig) (*httptest.Server, *metadata.MetadataService) {
+	t.Helper()
+	dir := t.TempDir()
+	meta, err := metadata.NewMetadataService(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	local, err := cloud.NewLocalStorage(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatalf("failed to create local storage: %v", err)
+	}
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{local})
+	st := storage.NewStorageService(mgr, meta, chunker.NewFileChunker(chunker.ChunkMetadataSize+64))
+	cfg.StagingDir = dir
+	h, err := NewHandler(st, meta, cfg)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+	ts := httptest.NewServer(h)
+	t.Cleanup(func() {
+		ts.Close()
+		h.Close()
+	})
+	return ts, meta
+}
+
+func do(t *testing.T, method, url string, body []byte, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href        string    `xml:"href"`
+		Collection  *struct{} `xml:"propstat>prop>resourcetype>collection"`
+		Length      string    `xml:"propstat>prop>getcontentlength"`
+		ContentType string    `xml:"propstat>prop>getcontenttype"`
+	} `xml:"response"`
+}
+
+// propfind lists a collection at depth 1 and returns its members' hrefs, directories
+// marked with a trailing "/"
+func propfind(t *testing.T, url string) []string {
+	t.Helper()
+	resp, body := do(t, "PROPFIND", url, nil, "Depth", "1")
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND %s = %d %s", url, resp.StatusCode, body)
+	}
+	var ms multistatus
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		t.Fatalf("malformed PROPFIND response: %v", err)
+	}
+	var hrefs []string
+	for _, r := range ms.Responses {
+		href := strings.TrimSuffix(r.Href, "/")
+		if r.Collection != nil {
+			href += "/"
+		}
+		hrefs = append(hrefs, href)
+	}
+	return hrefs
+}
+
+func TestHandler_Files(t *testing.T) {
+	ts, meta := setupHandler(t, config.WebDAVConfig{})
+	data := bytes.Repeat([]byte("0123456789"), 50)
+
+	if resp, body := do(t, http.MethodPut, ts.URL+"/docs/report.txt", data); resp.StatusCode != http.StatusConflict {
+		t.Errorf("PUT into a missing directory = %d %s, want 409", resp.StatusCode, body)
+	}
+	if resp, _ := do(t, "MKCOL", ts.URL+"/docs", nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("MKCOL = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "MKCOL", ts.URL+"/docs", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("MKCOL of an existing directory = %d, want 405", resp.StatusCode)
+	}
+	if resp, _ := do(t, "MKCOL", ts.URL+"/a/b", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("MKCOL without a parent = %d, want 409", resp.StatusCode)
+	}
+	if got := propfind(t, ts.URL+"/"); !reflect.DeepEqual(got, []string{"/", "/docs/"}) {
+		t.Errorf("PROPFIND / = %v", got)
+	}
+
+	if resp, body := do(t, http.MethodPut, ts.URL+"/docs/report.txt", data); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT = %d %s", resp.StatusCode, body)
+	}
+	if f, ok := meta.GetFile("docs/report.txt"); !ok || f.TotalSize != int64(len(data)) {
+		t.Fatalf("stored file = %+v, %v", f, ok)
+	}
+	resp, body := do(t, http.MethodGet, ts.URL+"/docs/report.txt", nil, "Range", "bytes=95-104")
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "5678901234" {
+		t.Errorf("ranged GET = %d %q", resp.StatusCode, body)
+	}
+	if resp, body = do(t, http.MethodGet, ts.URL+"/docs/report.txt", nil); !bytes.Equal(body, data) || resp.Header.Get("ETag") == "" {
+		t.Errorf("GET = %d, %d bytes, ETag %q", resp.StatusCode, len(body), resp.Header.Get("ETag"))
+	}
+	etag := resp.Header.Get("ETag")
+
+	resp, body = do(t, "PROPFIND", ts.URL+"/docs/report.txt", nil, "Depth", "0")
+	var ms multistatus
+	if err := xml.Unmarshal(body, &ms); err != nil || len(ms.Responses) != 1 {
+		t.Fatalf("PROPFIND file = %d %s", resp.StatusCode, body)
+	}
+	if r := ms.Responses[0]; r.Length != fmt.Sprint(len(data)) || !strings.HasPrefix(r.ContentType, "text/plain") {
+		t.Errorf("file properties = %+v", r)
+	}
+
+	// A new upload is a new version with a new ETag, even of the same size
+	replaced := bytes.ToUpper(data)
+	if resp, _ := do(t, http.MethodPut, ts.URL+"/docs/report.txt", replaced); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("second PUT = %d", resp.StatusCode)
+	}
+	if resp, body = do(t, http.MethodGet, ts.URL+"/docs/report.txt", nil); !bytes.Equal(body, replaced) || resp.Header.Get("ETag") == etag {
+		t.Errorf("GET after replacing = %q, ETag %q", body[:10], resp.Header.Get("ETag"))
+	}
+
+	// COPY, then MOVE of a file and of a directory
+	if resp, _ := do(t, "COPY", ts.URL+"/docs/report.txt", nil, "Destination", ts.URL+"/docs/copy.txt"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("COPY = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "MOVE", ts.URL+"/docs/copy.txt", nil, "Destination", ts.URL+"/docs/report.txt", "Overwrite", "F"); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("MOVE onto an existing file without overwrite = %d, want 412", resp.StatusCode)
+	}
+	if resp, _ := do(t, "MOVE", ts.URL+"/docs/copy.txt", nil, "Destination", ts.URL+"/docs/moved%20copy.txt"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("MOVE = %d", resp.StatusCode)
+	}
+	if got := propfind(t, ts.URL+"/docs"); !reflect.DeepEqual(got, []string{"/docs/", "/docs/moved%20copy.txt", "/docs/report.txt"}) {
+		t.Errorf("PROPFIND /docs = %v", got)
+	}
+	if resp, _ := do(t, "MOVE", ts.URL+"/docs", nil, "Destination", ts.URL+"/archive/"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("MOVE of a directory = %d", resp.StatusCode)
+	}
+	if _, body = do(t, http.MethodGet, ts.URL+"/archive/moved%20copy.txt", nil); !bytes.Equal(body, replaced) {
+		t.Error("moved file content mismatch")
+	}
+	if versions, _ := meta.ListVersions("archive/report.txt"); len(versions) != 2 {
+		t.Errorf("moved file has %d versions, want 2", len(versions))
+	}
+	if resp, _ := do(t, http.MethodGet, ts.URL+"/docs/report.txt", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of the old path = %d, want 404", resp.StatusCode)
+	}
+
+	// Directories stay listed once their last file is deleted
+	if resp, _ := do(t, http.MethodDelete, ts.URL+"/archive/report.txt", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, http.MethodDelete, ts.URL+"/archive/moved%20copy.txt", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d", resp.StatusCode)
+	}
+	if got := propfind(t, ts.URL+"/"); !reflect.DeepEqual(got, []string{"/", "/archive/"}) {
+		t.Errorf("PROPFIND / after deleting = %v", got)
+	}
+	if resp, _ := do(t, http.MethodDelete, ts.URL+"/archive", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE of a directory = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, http.MethodDelete, ts.URL+"/archive", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second DELETE = %d, want 404", resp.StatusCode)
+	}
+	if files, _ := meta.ListFiles(); len(files) != 0 {
+		t.Errorf("files left after deleting: %+v", files)
+	}
+}
+
+func TestHandler_Listing(t *testing.T) {
+	ts, _ := setupHandler(t, config.WebDAVConfig{})
+	do(t, "MKCOL", ts.URL+"/docs", nil)
+	do(t, http.MethodPut, ts.URL+"/docs/a&b.txt", []byte("hello"))
+
+	resp, _ := do(t, http.MethodGet, ts.URL+"/docs", nil)
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/docs/" {
+		t.Errorf("GET of a directory without slash = %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, body := do(t, http.MethodGet, ts.URL+"/docs/", nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("listing = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{`href="a&amp;b.txt"`, `a&amp;b.txt</a></td><td>5</td>`, `href="../"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("listing lacks %s:\n%s", want, body)
+		}
+	}
+	if _, body = do(t, http.MethodGet, ts.URL+"/", nil); !strings.Contains(string(body), `href="docs/"`) || strings.Contains(string(body), `href="../"`) {
+		t.Errorf("root listing:\n%s", body)
+	}
+}
+
+func TestHandler_Auth(t *testing.T) {
+	ts, _ := setupHandler(t, config.WebDAVConfig{Users: []config.WebDAVUser{{Username: "alice", Password: "secret"}}})
+
+	resp, _ := do(t, "PROPFIND", ts.URL+"/", nil, "Depth", "0")
+	if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Basic") {
+		t.Errorf("anonymous PROPFIND = %d, WWW-Authenticate %q", resp.StatusCode, resp.Header.Get("WWW-Authenticate"))
+	}
+	for _, tc := range []struct {
+		user, pass string
+		status     int
+	}{
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"bob", "secret", http.StatusUnauthorized},
+		{"alice", "secret", http.StatusMultiStatus},
+	} {
+		req, _ := http.NewRequest("PROPFIND", ts.URL+"/", nil)
+		req.Header.Set("Depth", "0")
+		req.SetBasicAuth(tc.user, tc.pass)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("PROPFIND as %s:%s = %d, want %d", tc.user, tc.pass, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestHandler_InterruptedPut(t *testing.T) {
+	ts, meta := setupHandler(t, config.WebDAVConfig{})
+	if resp, _ := do(t, http.MethodPut, ts.URL+"/a.txt", []byte("original")); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT = %d", resp.StatusCode)
+	}
+
+	// A body cut short must not replace the file, although the webdav package still closes
+	// the file it was copied into
+	h := ts.Config.Handler.(*Handler)
+	body := io.MultiReader(strings.NewReader("0123456789"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	req := httptest.NewRequest(http.MethodPut, "/a.txt", body)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code < 400 {
+		t.Errorf("interrupted PUT = %d", rec.Code)
+	}
+	if versions, _ := meta.ListVersions("a.txt"); len(versions) != 1 {
+		t.Errorf("interrupted PUT stored a version: %+v", versions)
+	}
+	if _, body := do(t, http.MethodGet, ts.URL+"/a.txt", nil); string(body) != "original" {
+		t.Errorf("file after an interrupted PUT = %q", body)
+	}
+	if staged, _ := os.ReadDir(h.fs.staging); len(staged) != 0 {
+		t.Errorf("staged data left behind: %v", staged)
+	}
+}
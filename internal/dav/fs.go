@@ -0,0 +1,430 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+	"golang.org/x/net/webdav"
+)
+
+// fileSystem implements webdav.FileSystem over the stored namespace. Files are the rows
+// of the files table; directories exist as the parents of files, plus the empty ones
+// created by MKCOL or emptied by DELETE and MOVE, which are kept in memory only.
+type fileSystem struct {
+	storage *storage.StorageService
+	meta    *metadata.MetadataService
+	staging string    // writes are staged here until the file is closed
+	started time.Time // reported as the modification time of directories
+
+	mu   sync.Mutex
+	dirs map[string]bool // empty directories
+}
+
+// davPath turns a WebDAV resource name into a stored path, "" for the root
+func davPath(name string) (string, error) {
+	return storage.CleanPath(name)
+}
+
+// pathError maps storage errors onto the os errors the webdav package turns into statuses
+func pathError(op, name string, err error) error {
+	switch {
+	case errors.Is(err, errorx.ErrFileNotFound), errors.Is(err, errorx.ErrVersionNotFound):
+		err = os.ErrNotExist
+	case errors.Is(err, errorx.ErrFileAlreadyExists):
+		err = os.ErrExist
+	case errors.Is(err, errorx.ErrInvalidPath), errors.Is(err, errorx.ErrUploadInProgress):
+		err = errors.Join(os.ErrPermission, err)
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// isDir reports whether p names a directory: the root, an empty directory or the parent
+// of a stored file
+func (fs *fileSystem) isDir(p string) (bool, error) {
+	if p == "" {
+		return true, nil
+	}
+	fs.mu.Lock()
+	empty := fs.dirs[p]
+	fs.mu.Unlock()
+	if empty {
+		return true, nil
+	}
+	files, err := fs.meta.ListPage(p+"/", "", 1)
+	return len(files) > 0, err
+}
+
+// keepDir remembers dir as an empty directory, so it outlives the files moved out of it
+func (fs *fileSystem) keepDir(dir string) {
+	if dir == "" {
+		return
+	}
+	fs.mu.Lock()
+	fs.dirs[dir] = true
+	fs.mu.Unlock()
+}
+
+// moveDirs renames the empty directories at or below from; to "" drops them
+func (fs *fileSystem) moveDirs(from, to string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for dir := range fs.dirs {
+		if dir == from || strings.HasPrefix(dir, from+"/") {
+			delete(fs.dirs, dir)
+			if to != "" {
+				fs.dirs[to+strings.TrimPrefix(dir, from)] = true
+			}
+		}
+	}
+}
+
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p, err := davPath(name)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	if p != "" {
+		if f, ok := fs.meta.GetFile(p); ok {
+			return newFileInfo(f), nil
+		}
+	}
+	dir, err := fs.isDir(p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	if !dir {
+		return nil, pathError("stat", name, os.ErrNotExist)
+	}
+	return fs.dirInfo(p), nil
+}
+
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p, err := davPath(name)
+	if err != nil {
+		return pathError("mkdir", name, err)
+	}
+	if _, err := fs.Stat(ctx, p); err == nil {
+		return pathError("mkdir", name, os.ErrExist)
+	}
+	if dir, err := fs.isDir(metadata.ParentDir(p)); err != nil || !dir {
+		return pathError("mkdir", name, os.ErrNotExist)
+	}
+	fs.keepDir(p)
+	return nil
+}
+
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p, err := davPath(name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return fs.create(ctx, name, p, flag)
+	}
+	if p != "" {
+		if meta, ok := fs.meta.GetFile(p); ok {
+			f, err := fs.storage.OpenFileContext(ctx, p)
+			if err != nil {
+				return nil, pathError("open", name, err)
+			}
+			return &readFile{File: f, info: newFileInfo(meta)}, nil
+		}
+	}
+	if dir, err := fs.isDir(p); err != nil || !dir {
+		return nil, pathError("open", name, os.ErrNotExist)
+	}
+	return &dirFile{fs: fs, info: fs.dirInfo(p)}, nil
+}
+
+// create opens a file for writing. Stored files cannot be changed in place, so writes
+// always replace the content: they are staged and stored as a new version on Close.
+func (fs *fileSystem) create(ctx context.Context, name, p string, flag int) (webdav.File, error) {
+	if p == "" {
+		return nil, pathError("open", name, os.ErrPermission)
+	}
+	_, exists := fs.meta.GetFile(p)
+	switch {
+	case exists && flag&os.O_TRUNC == 0:
+		return nil, pathError("open", name, os.ErrPermission)
+	case exists && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, os.ErrExist)
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, pathError("open", name, os.ErrNotExist)
+	}
+	if dir, err := fs.isDir(p); err != nil || dir {
+		return nil, pathError("open", name, os.ErrPermission)
+	}
+	if dir, err := fs.isDir(metadata.ParentDir(p)); err != nil || !dir {
+		return nil, pathError("open", name, os.ErrNotExist)
+	}
+	tmp, err := os.CreateTemp(fs.staging, "storagex-dav-")
+	if err != nil {
+		return nil, err
+	}
+	return &writeFile{ctx: ctx, fs: fs, name: p, tmp: tmp, info: fileInfo{name: p, modTime: time.Now()}}, nil
+}
+
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	p, err := davPath(name)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	if p == "" {
+		return pathError("remove", name, os.ErrPermission)
+	}
+	if _, ok := fs.meta.GetFile(p); ok {
+		if err := fs.storage.DeleteFileContext(ctx, p); err != nil {
+			return pathError("remove", name, err)
+		}
+		fs.keepDir(metadata.ParentDir(p))
+		return nil
+	}
+	files, err := fs.meta.ListTree(p)
+	if err != nil {
+		return pathError("remove", name, err)
+	}
+	for _, f := range files {
+		if err := fs.storage.DeleteFileContext(ctx, f.FileName); err != nil {
+			return pathError("remove", name, err)
+		}
+	}
+	fs.moveDirs(p, "")
+	fs.keepDir(metadata.ParentDir(p))
+	return nil
+}
+
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	from, err := davPath(oldName)
+	if err != nil {
+		return pathError("rename", oldName, err)
+	}
+	to, err := davPath(newName)
+	if err != nil {
+		return pathError("rename", newName, err)
+	}
+	if from == "" || to == "" {
+		return pathError("rename", oldName, os.ErrPermission)
+	}
+	if dir, err := fs.isDir(metadata.ParentDir(to)); err != nil || !dir {
+		return pathError("rename", newName, os.ErrNotExist)
+	}
+	if _, ok := fs.meta.GetFile(from); ok {
+		if err := fs.storage.RenameFile(from, to); err != nil {
+			return pathError("rename", oldName, err)
+		}
+		fs.keepDir(metadata.ParentDir(from))
+		return nil
+	}
+	if to == from || strings.HasPrefix(to, from+"/") {
+		return pathError("rename", newName, os.ErrPermission)
+	}
+	files, err := fs.meta.ListPage(from+"/", "", 1)
+	if err != nil {
+		return pathError("rename", oldName, err)
+	}
+	if len(files) > 0 {
+		if err := fs.storage.RenameDir(from, to); err != nil {
+			return pathError("rename", oldName, err)
+		}
+	} else if dir, _ := fs.isDir(from); !dir {
+		return pathError("rename", oldName, os.ErrNotExist)
+	}
+	fs.moveDirs(from, to)
+	fs.keepDir(metadata.ParentDir(from))
+	return nil
+}
+
+// readDir lists the files, subdirectories and empty directories directly inside p,
+// sorted by name
+func (fs *fileSystem) readDir(p string) ([]os.FileInfo, error) {
+	files, subdirs, err := fs.meta.ListDir(p)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(files)+len(subdirs))
+	seen := make(map[string]bool, len(subdirs))
+	for _, f := range files {
+		infos = append(infos, newFileInfo(f))
+	}
+	for _, sub := range subdirs {
+		seen[sub] = true
+		infos = append(infos, fs.dirInfo(path.Join(p, sub)))
+	}
+	fs.mu.Lock()
+	for dir := range fs.dirs {
+		if metadata.ParentDir(dir) == p && !seen[path.Base(dir)] {
+			infos = append(infos, fs.dirInfo(dir))
+		}
+	}
+	fs.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// fileInfo describes a stored file or a directory. It answers the content type and ETag
+// properties itself, so listings never read file content.
+type fileInfo struct {
+	name    string // stored path
+	size    int64
+	modTime time.Time
+	version int
+	dir     bool
+}
+
+func (fs *fileSystem) dirInfo(p string) *fileInfo {
+	return &fileInfo{name: p, modTime: fs.started, dir: true}
+}
+
+func newFileInfo(f metadata.FileMetadata) *fileInfo {
+	return &fileInfo{name: f.FileName, size: f.TotalSize, modTime: f.ModifiedAt, version: f.Version}
+}
+
+func (fi *fileInfo) Name() string       { return path.Base("/" + fi.name) }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// ContentType guesses from the extension only
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if t := mime.TypeByExtension(path.Ext(fi.name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// ETag changes with every upload, even of content of the same size within a second
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.version == 0 {
+		return "", webdav.ErrNotImplemented
+	}
+	return fmt.Sprintf(`"%d-%x-%x"`, fi.version, fi.modTime.Unix(), fi.size), nil
+}
+
+// readFile serves a stored file for GET, COPY and ranged reads
+type readFile struct {
+	*storage.File
+	info os.FileInfo
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, pathError("readdir", f.Name(), errors.New("not a directory"))
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, pathError("write", f.Name(), os.ErrPermission)
+}
+
+// dirFile lists a directory; its entries are read on the first Readdir
+type dirFile struct {
+	fs      *fileSystem
+	info    *fileInfo
+	entries []os.FileInfo
+	read    bool
+}
+
+func (d *dirFile) Stat() (os.FileInfo, error) { return d.info, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		entries, err := d.fs.readDir(d.info.name)
+		if err != nil {
+			return nil, pathError("readdir", d.info.name, err)
+		}
+		d.entries, d.read = entries, true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dirFile) Read(p []byte) (int, error) {
+	return 0, pathError("read", d.info.name, errors.New("is a directory"))
+}
+
+func (d *dirFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, pathError("seek", d.info.name, errors.New("is a directory"))
+}
+
+func (d *dirFile) Write(p []byte) (int, error) {
+	return 0, pathError("write", d.info.name, errors.New("is a directory"))
+}
+
+// writeFile stages written data in a local temporary file and stores it as a new version
+// of the file on Close. Staging keeps the storage write lock, taken for the upload, from
+// being held while a client sends the body, and lets COPY read a stored file while it
+// writes another.
+type writeFile struct {
+	ctx  context.Context
+	fs   *fileSystem
+	name string
+	tmp  *os.File
+	info fileInfo
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.tmp.Write(p)
+	f.info.size += int64(n)
+	return n, err
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) { return &f.info, nil }
+
+func (f *writeFile) Close() error {
+	defer os.Remove(f.tmp.Name())
+	defer f.tmp.Close()
+	// A request body that failed to arrive whole must not replace the file
+	if err := bodyError(f.ctx); err != nil {
+		return err
+	}
+	if _, err := f.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := f.fs.storage.UploadReaderContext(f.ctx, f.tmp, f.name, storage.DefaultUploadOptions()); err != nil {
+		return pathError("close", f.name, err)
+	}
+	return nil
+}
+
+func (f *writeFile) Read(p []byte) (int, error) {
+	return 0, pathError("read", f.name, os.ErrPermission)
+}
+
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, pathError("seek", f.name, os.ErrPermission)
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, pathError("readdir", f.name, errors.New("not a directory"))
+}
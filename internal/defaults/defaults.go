@@ -2,9 +2,33 @@ package defaults
 
 const (
 	DefaultChunkSize              = 1024 * 1024 // 1MB
+	DefaultChunkingStrategy       = "fixed"
+	DefaultCDCMinSize             = 256 * 1024      // FastCDC minimum chunk data size
+	DefaultCDCAvgSize             = 1024 * 1024     // FastCDC target average
+	DefaultCDCMaxSize             = 4 * 1024 * 1024 // FastCDC hard maximum
 	DefaultConfigPath             = "config/config.json"
 	DefaultDBPath                 = "metadata.db"
 	DefaultLogDebug               = false
 	DefaultStorageUploadWorkers   = 4 // Default number of upload workers
 	DefaultStorageDownloadWorkers = 4 // Default number of download workers
+	DefaultS3Region               = "us-east-1"
+	DefaultReplicationFactor      = 1 // Each chunk is stored on one backend
+	DefaultStorageMode            = "replicate"
+	DefaultErasureDataShards      = 4
+	DefaultErasureParityShards    = 2
+	DefaultCompressionCodec       = "none"
+	DefaultUploadSessionTTLHours  = 7 * 24 // unfinished uploads can be resumed for a week
+	DefaultRetryMaxAttempts       = 4
+	DefaultRetryBaseDelayMs       = 200
+	DefaultRetryMaxDelayMs        = 10 * 1000
+	DefaultRetryMaxRetryAfterMs   = 60 * 1000        // longer waits requested by a provider are cut short
+	DefaultServeAddr              = "127.0.0.1:8080" // the HTTP API listens on loopback unless told otherwise
+	DefaultListPageSize           = 100
+	MaxListPageSize               = 1000
+	DefaultGatewayAddr            = "127.0.0.1:9000" // the S3 gateway, like the HTTP API, listens on loopback by default
+	DefaultGatewayBucket          = "storagex"
+	DefaultWebDAVAddr             = "127.0.0.1:8081"
+	DefaultGRPCAddr               = "127.0.0.1:9090"
+	DefaultGRPCMessageSize        = 256 * 1024 // content bytes per Download message
+	DefaultCapacityRefreshSeconds = 60         // servers refresh the backend capacity metrics this often
 )
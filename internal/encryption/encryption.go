@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"strings"
+
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// Sealed object layout: version (1) | nonce (12) | AES-256-GCM ciphertext and tag.
+// The version byte and nonce are authenticated as additional data.
+const (
+	Version    byte = 1
+	KeySize         = 32
+	NonceSize       = 12
+	TagSize         = 16
+	HeaderSize      = 1 + NonceSize
+
+	// Overhead is how many bytes Seal adds to a plaintext
+	Overhead = HeaderSize + TagSize
+)
+
+// Cipher seals and opens objects with one AES-256-GCM key. It is safe for concurrent use.
+type Cipher struct {
+	aead    cipher.AEAD
+	nameKey []byte // keys ObjectName, derived from the data key
+}
+
+// NewCipher returns a Cipher for a 32-byte key
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, errorx.WrapWithDetails(errorx.ErrInvalidEncryptionKey, "key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidEncryptionKey, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidEncryptionKey, err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("storagex object name"))
+	return &Cipher{aead: aead, nameKey: mac.Sum(nil)}, nil
+}
+
+// ObjectName returns a keyed content address for a chunk checksum. Equal chunks sealed
+// with the same key share a name, while names reveal nothing about the plaintext.
+func (c *Cipher) ObjectName(checksum []byte) string {
+	mac := hmac.New(sha256.New, c.nameKey)
+	mac.Write(checksum)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Seal encrypts plaintext under a fresh random nonce
+func (c *Cipher) Seal(plaintext []byte) ([]byte, error) {
+	out := make([]byte, HeaderSize, HeaderSize+len(plaintext)+TagSize)
+	out[0] = Version
+	if _, err := rand.Read(out[1:HeaderSize]); err != nil {
+		return nil, errorx.Wrap(errorx.ErrEncryptFailed, err)
+	}
+	return c.aead.Seal(out, out[1:HeaderSize], plaintext, out[:HeaderSize]), nil
+}
+
+// Open authenticates and decrypts an object produced by Seal
+func (c *Cipher) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, errorx.WrapWithDetails(errorx.ErrDecryptFailed, "object too short")
+	}
+	if sealed[0] != Version {
+		return nil, errorx.WrapWithDetails(errorx.ErrUnsupportedCipherVersion, hex.EncodeToString(sealed[:1]))
+	}
+	plaintext, err := c.aead.Open(nil, sealed[1:HeaderSize], sealed[HeaderSize:], sealed[:HeaderSize])
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDecryptFailed, err)
+	}
+	return plaintext, nil
+}
+
+// NewDataKey returns a random per-file data key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errorx.Wrap(errorx.ErrEncryptFailed, err)
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a data key with the master key, using the same sealed layout as chunks
+func WrapKey(master, dataKey []byte) ([]byte, error) {
+	c, err := NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	return c.Seal(dataKey)
+}
+
+// UnwrapKey recovers a data key wrapped by WrapKey
+func UnwrapKey(master, wrapped []byte) ([]byte, error) {
+	c, err := NewCipher(master)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := c.Open(wrapped)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrKeyUnwrapFailed, err)
+	}
+	if len(dataKey) != KeySize {
+		return nil, errorx.WrapWithDetails(errorx.ErrKeyUnwrapFailed, "unexpected data key size")
+	}
+	return dataKey, nil
+}
+
+// LoadMasterKey reads the master key named in config: MasterKey takes precedence over
+// KeyFile. Keys are 32 bytes, given as hex or base64; a key file may also hold raw bytes.
+func LoadMasterKey(cfg config.EncryptionConfig) ([]byte, error) {
+	if cfg.MasterKey != "" {
+		return parseKey([]byte(cfg.MasterKey))
+	}
+	if cfg.KeyFile == "" {
+		return nil, errorx.WrapWithDetails(errorx.ErrInvalidEncryptionKey, "no master_key or key_file configured")
+	}
+	data, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidEncryptionKey, err)
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+	return parseKey(data)
+}
+
+func parseKey(data []byte) ([]byte, error) {
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, errorx.WrapWithDetails(errorx.ErrInvalidEncryptionKey, "master key must be 32 bytes, hex or base64 encoded")
+}
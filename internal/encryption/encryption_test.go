@@ -0,0 +1,143 @@
+package encryption_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/encryption"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, encryption.KeySize)
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	c, err := encryption.NewCipher(testKey(1))
+	if err != nil {
+		t.Fatalf("NewCipher failed: %v", err)
+	}
+	plaintext := []byte("chunk payload")
+	sealed, err := c.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if len(sealed) != len(plaintext)+encryption.Overhead {
+		t.Errorf("sealed length %d, want %d", len(sealed), len(plaintext)+encryption.Overhead)
+	}
+	if sealed[0] != encryption.Version {
+		t.Errorf("version byte %d, want %d", sealed[0], encryption.Version)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("sealed object contains the plaintext")
+	}
+	again, _ := c.Seal(plaintext)
+	if bytes.Equal(sealed, again) {
+		t.Error("two seals of the same plaintext are identical; nonce not random")
+	}
+
+	opened, err := c.Open(sealed)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open returned %q, want %q", opened, plaintext)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	c, _ := encryption.NewCipher(testKey(1))
+	sealed, _ := c.Seal([]byte("chunk payload"))
+
+	for _, pos := range []int{1, encryption.HeaderSize, len(sealed) - 1} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[pos] ^= 0x01
+		if _, err := c.Open(tampered); !errors.Is(err, errorx.ErrDecryptFailed) {
+			t.Errorf("byte %d flipped: expected ErrDecryptFailed, got %v", pos, err)
+		}
+	}
+
+	badVersion := append([]byte(nil), sealed...)
+	badVersion[0] = 99
+	if _, err := c.Open(badVersion); !errors.Is(err, errorx.ErrUnsupportedCipherVersion) {
+		t.Errorf("expected ErrUnsupportedCipherVersion, got %v", err)
+	}
+	if _, err := c.Open(sealed[:5]); !errors.Is(err, errorx.ErrDecryptFailed) {
+		t.Errorf("expected ErrDecryptFailed for short object, got %v", err)
+	}
+
+	other, _ := encryption.NewCipher(testKey(2))
+	if _, err := other.Open(sealed); !errors.Is(err, errorx.ErrDecryptFailed) {
+		t.Errorf("expected ErrDecryptFailed with wrong key, got %v", err)
+	}
+}
+
+func TestWrapUnwrapKey(t *testing.T) {
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey failed: %v", err)
+	}
+	wrapped, err := encryption.WrapKey(testKey(7), dataKey)
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	got, err := encryption.UnwrapKey(testKey(7), wrapped)
+	if err != nil {
+		t.Fatalf("UnwrapKey failed: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Error("unwrapped key differs from the original")
+	}
+	if _, err := encryption.UnwrapKey(testKey(8), wrapped); !errors.Is(err, errorx.ErrKeyUnwrapFailed) {
+		t.Errorf("expected ErrKeyUnwrapFailed with wrong master key, got %v", err)
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	key := testKey(3)
+	dir := t.TempDir()
+	rawFile := filepath.Join(dir, "raw.key")
+	hexFile := filepath.Join(dir, "hex.key")
+	if err := os.WriteFile(rawFile, key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hexFile, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		cfg  config.EncryptionConfig
+	}{
+		{"hex", config.EncryptionConfig{MasterKey: hex.EncodeToString(key)}},
+		{"base64", config.EncryptionConfig{MasterKey: base64.StdEncoding.EncodeToString(key)}},
+		{"raw file", config.EncryptionConfig{KeyFile: rawFile}},
+		{"hex file", config.EncryptionConfig{KeyFile: hexFile}},
+	}
+	for _, tc := range cases {
+		got, err := encryption.LoadMasterKey(tc.cfg)
+		if err != nil {
+			t.Errorf("%s: LoadMasterKey failed: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(got, key) {
+			t.Errorf("%s: wrong key loaded", tc.name)
+		}
+	}
+
+	for _, cfg := range []config.EncryptionConfig{
+		{},
+		{MasterKey: "too-short"},
+		{KeyFile: filepath.Join(dir, "missing.key")},
+	} {
+		if _, err := encryption.LoadMasterKey(cfg); !errors.Is(err, errorx.ErrInvalidEncryptionKey) {
+			t.Errorf("%+v: expected ErrInvalidEncryptionKey, got %v", cfg, err)
+		}
+	}
+}
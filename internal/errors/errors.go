@@ -3,18 +3,48 @@ package errors
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
 	ErrDropboxUpload   = errors.New("dropbox: upload failed")
 	ErrDropboxDownload = errors.New("dropbox: download failed")
 	ErrDropboxDelete   = errors.New("dropbox: delete failed")
+	ErrDropboxList     = errors.New("dropbox: list failed")
 
 	ErrDriveUpload     = errors.New("gdrive: upload failed")
 	ErrDriveDownload   = errors.New("gdrive: download failed")
 	ErrDriveDelete     = errors.New("gdrive: delete failed")
 	ErrStorageNotFound = errors.New("storage: storage system not found")
 
+	ErrNotEnoughBackends = errors.New("storage: not enough distinct backends")
+	ErrWriteQuorumNotMet = errors.New("storage: write quorum not met")
+
+	ErrUnknownPlacementPolicy = errors.New("storage: unknown placement policy")
+
+	ErrUnknownStorageMode   = errors.New("storage: unknown storage mode")
+	ErrInvalidErasureLayout = errors.New("storage: invalid erasure coding layout")
+	ErrStripeUnrecoverable  = errors.New("storage: not enough shards to reconstruct stripe")
+	ErrChunkCorrupted       = errors.New("storage: chunk failed verification")
+	ErrFileReaderClosed     = errors.New("storage: file reader closed")
+	ErrInvalidOffset        = errors.New("storage: invalid file offset")
+	ErrInvalidPath          = errors.New("storage: invalid file path")
+	ErrInvalidPattern       = errors.New("storage: invalid glob pattern")
+	ErrUnknownSymlinkMode   = errors.New("storage: unknown symlink mode")
+
+	ErrLocalInit     = errors.New("local: failed to initialize storage root")
+	ErrLocalUpload   = errors.New("local: upload failed")
+	ErrLocalDownload = errors.New("local: download failed")
+	ErrLocalDelete   = errors.New("local: delete failed")
+	ErrLocalStat     = errors.New("local: failed to stat filesystem")
+	ErrLocalList     = errors.New("local: list failed")
+
+	ErrS3Init     = errors.New("s3: invalid configuration")
+	ErrS3Upload   = errors.New("s3: upload failed")
+	ErrS3Download = errors.New("s3: download failed")
+	ErrS3Delete   = errors.New("s3: delete failed")
+	ErrS3List     = errors.New("s3: list failed")
+
 	// Add more unified errors for other providers as needed
 )
 
@@ -37,12 +67,95 @@ var (
 	ErrFileUpdateFailed         = errors.New("metadata: failed to update file")
 	ErrDBQueryFailed            = errors.New("metadata: database query failed")
 	ErrDBScanFailed             = errors.New("metadata: failed to scan database rows")
+	ErrStripeInsertFailed       = errors.New("metadata: failed to insert stripe")
+)
+
+// Retry errors
+var (
+	ErrRetriesExhausted = errors.New("retry: giving up after transient failures")
+)
+
+// RetryableError marks a transient failure, such as a timeout, a 5xx answer or a rate
+// limit, that may succeed if the operation is tried again. Errors not marked are permanent.
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration // wait requested by the provider, 0 if it did not ask for one
+}
+
+func (e *RetryableError) Error() string { return e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+// Retryable marks err as transient; after is the wait the provider asked for, if any
+func Retryable(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryableError{Err: err, RetryAfter: after}
+}
+
+// IsRetryable reports whether err, or an error it wraps, is marked transient
+func IsRetryable(err error) bool {
+	var re *RetryableError
+	return errors.As(err, &re)
+}
+
+// RetryAfter returns the wait requested by the provider for a transient error, or 0
+func RetryAfter(err error) time.Duration {
+	var re *RetryableError
+	if errors.As(err, &re) {
+		return re.RetryAfter
+	}
+	return 0
+}
+
+// Upload session errors
+var (
+	ErrUploadSessionExists   = errors.New("upload: session already exists")
+	ErrUploadSessionNotFound = errors.New("upload: no session to resume")
+	ErrUploadSessionExpired  = errors.New("upload: session expired")
+	ErrUploadInProgress      = errors.New("upload: file has an unfinished upload")
+	ErrUploadSourceChanged   = errors.New("upload: source file changed since the upload started")
+)
+
+// Versioning errors
+var (
+	ErrVersionNotFound  = errors.New("version: no such version of the file")
+	ErrVersionIsCurrent = errors.New("version: the current version cannot be removed")
 )
 
 // Chunker errors
 var (
 	ErrConfigNotLoaded = errors.New("chunker: app config not loaded")
 	ErrChunkReadFailed = errors.New("chunker: failed to read chunk from file")
+
+	ErrUnknownChunkingStrategy = errors.New("chunker: unknown chunking strategy")
+	ErrInvalidChunkSizes       = errors.New("chunker: invalid content-defined chunk sizes")
+)
+
+// Encryption errors
+var (
+	ErrInvalidEncryptionKey     = errors.New("encryption: invalid key")
+	ErrEncryptFailed            = errors.New("encryption: failed to encrypt")
+	ErrDecryptFailed            = errors.New("encryption: failed to authenticate or decrypt")
+	ErrUnsupportedCipherVersion = errors.New("encryption: unsupported format version")
+	ErrKeyUnwrapFailed          = errors.New("encryption: failed to unwrap data key")
+	ErrEncryptionKeyMissing     = errors.New("encryption: file is encrypted but no master key is configured")
+)
+
+// Compression errors
+var (
+	ErrUnknownCodec     = errors.New("compression: unknown codec")
+	ErrCompressFailed   = errors.New("compression: failed to compress")
+	ErrDecompressFailed = errors.New("compression: failed to decompress")
+)
+
+// SigV4 request authentication errors
+var (
+	ErrSigV4MissingAuth = errors.New("sigv4: missing authorization header")
+	ErrSigV4Malformed   = errors.New("sigv4: malformed authorization")
+	ErrSigV4UnknownKey  = errors.New("sigv4: unknown access key")
+	ErrSigV4ClockSkew   = errors.New("sigv4: request time too skewed")
+	ErrSigV4Mismatch    = errors.New("sigv4: signature does not match")
 )
 
 // App / Service initialization errors
@@ -50,6 +163,8 @@ var (
 	ErrConfigLoadFailed         = errors.New("app: failed to load config")
 	ErrMetadataInitFailed       = errors.New("app: failed to initialize metadata service")
 	ErrNoCloudStorageConfigured = errors.New("app: no cloud storage configured")
+	ErrCloudStorageInitFailed   = errors.New("app: failed to initialize cloud storage")
+	ErrInvalidReplication       = errors.New("app: replication factor exceeds configured backends")
 )
 
 // Wrappers to add context
@@ -57,7 +172,7 @@ func Wrap(base error, err error) error {
 	if err == nil {
 		return base
 	}
-	return fmt.Errorf("%w: %v", base, err)
+	return fmt.Errorf("%w: %w", base, err)
 }
 
 func WrapWithDetails(base error, details string) error {
@@ -65,9 +180,36 @@ func WrapWithDetails(base error, details string) error {
 }
 
 func WrapDropboxError(base error, err error) error {
-	return fmt.Errorf("%w: %v", base, err)
+	return fmt.Errorf("%w: %w", base, err)
 }
 
 func WrapDriveError(base error, err error) error {
-	return fmt.Errorf("%w: %v", base, err)
+	return fmt.Errorf("%w: %w", base, err)
 }
+
+// Garbage collection errors
+var (
+	ErrGCIncomplete = errors.New("gc: some backends could not be listed or cleaned")
+)
+
+// HTTP server errors
+var (
+	ErrInvalidRequest = errors.New("server: invalid request")
+)
+
+// S3 gateway errors
+var (
+	ErrGatewayNoAccessKeys = errors.New("gateway: no access keys configured")
+	ErrNoSuchBucket        = errors.New("gateway: no such bucket")
+	ErrNoSuchUpload        = errors.New("gateway: no such multipart upload")
+	ErrInvalidPart         = errors.New("gateway: invalid multipart upload part")
+	ErrPayloadMismatch     = errors.New("gateway: body does not match X-Amz-Content-Sha256")
+	ErrNotImplemented      = errors.New("gateway: operation not implemented")
+)
+
+// gRPC API errors
+var (
+	ErrGRPCIncompleteTLS   = errors.New("grpc: cert_file and key_file must be set together")
+	ErrMissingUploadHeader = errors.New("grpc: upload stream must start with a header")
+	ErrUnexpectedHeader    = errors.New("grpc: upload header sent after content")
+)
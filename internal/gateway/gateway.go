@@ -0,0 +1,300 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/sigv4"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+// s3Namespace is the XML namespace of S3 responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Gateway speaks a subset of the S3 API over a StorageService. It serves one bucket,
+// named in config, whose keys are the stored file paths; requests must be path-style
+// (endpoint/bucket/key) and signed with SigV4 by one of the configured access keys.
+//
+// Supported: ListBuckets, HeadBucket, CreateBucket (of the served bucket),
+// GetBucketLocation, ListObjects and ListObjectsV2, PutObject, GetObject with Range,
+// HeadObject, DeleteObject, DeleteObjects and multipart uploads. Anything else is
+// answered with NotImplemented.
+type Gateway struct {
+	storage *storage.StorageService
+	meta    *metadata.MetadataService
+	bucket  string
+	region  string
+	secrets map[string]string // access key ID -> secret
+	created time.Time         // reported as the bucket's creation date
+
+	// Object bodies and multipart parts are staged below staging before they are stored
+	staging string
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+// NewGateway returns a gateway for the given services. It creates a staging directory for
+// uploads below cfg.StagingDir, removed again by Close.
+func NewGateway(st *storage.StorageService, meta *metadata.MetadataService, cfg config.GatewayConfig) (*Gateway, error) {
+	if len(cfg.AccessKeys) == 0 {
+		return nil, errorx.ErrGatewayNoAccessKeys
+	}
+	secrets := make(map[string]string, len(cfg.AccessKeys))
+	for _, key := range cfg.AccessKeys {
+		secrets[key.AccessKeyID] = key.SecretAccessKey
+	}
+	staging, err := os.MkdirTemp(cfg.StagingDir, "storagex-multipart-")
+	if err != nil {
+		return nil, err
+	}
+	return &Gateway{
+		storage: st,
+		meta:    meta,
+		bucket:  cfg.Bucket,
+		region:  cfg.Region,
+		secrets: secrets,
+		created: time.Now().UTC(),
+		staging: staging,
+		uploads: make(map[string]*multipartUpload),
+	}, nil
+}
+
+// Close drops the multipart uploads not completed and their staged parts
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.uploads = make(map[string]*multipartUpload)
+	return os.RemoveAll(g.staging)
+}
+
+func (g *Gateway) secretFor(accessKey string) (string, bool) {
+	secret, ok := g.secrets[accessKey]
+	return secret, ok
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Amz-Request-Id", newID(8))
+	if _, err := sigv4.Verify(r, g.secretFor, time.Now()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, errorx.WrapWithDetails(errorx.ErrNotImplemented, r.Method+" /"))
+			return
+		}
+		g.listBuckets(w)
+		return
+	}
+	if bucket != g.bucket {
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrNoSuchBucket, bucket))
+		return
+	}
+	if key == "" {
+		g.serveBucket(w, r)
+		return
+	}
+	g.serveObject(w, r, key)
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut:
+		// The served bucket always exists; creating it again is not an error in us-east-1
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && q.Has("location"):
+		writeXML(w, http.StatusOK, locationConstraint{Xmlns: s3Namespace, Region: g.region})
+	case r.Method == http.MethodGet && !q.Has("uploads") && !q.Has("versions"):
+		g.listObjects(w, r)
+	case r.Method == http.MethodPost && q.Has("delete"):
+		g.deleteObjects(w, r)
+	default:
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrNotImplemented, r.Method+" on the bucket"))
+	}
+}
+
+func (g *Gateway) serveObject(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodPut:
+		switch {
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			writeError(w, r, errorx.WrapWithDetails(errorx.ErrNotImplemented, "CopyObject"))
+		case q.Has("uploadId"):
+			g.uploadPart(w, r, key)
+		default:
+			g.putObject(w, r, key)
+		}
+	case http.MethodPost:
+		switch {
+		case q.Has("uploads"):
+			g.createMultipartUpload(w, r, key)
+		case q.Has("uploadId"):
+			g.completeMultipartUpload(w, r, key)
+		default:
+			writeError(w, r, errorx.WrapWithDetails(errorx.ErrNotImplemented, "POST on an object"))
+		}
+	case http.MethodGet, http.MethodHead:
+		if q.Has("uploadId") {
+			writeError(w, r, errorx.WrapWithDetails(errorx.ErrNotImplemented, "ListParts"))
+			return
+		}
+		g.getObject(w, r, key)
+	case http.MethodDelete:
+		if q.Has("uploadId") {
+			g.abortMultipartUpload(w, r, key)
+			return
+		}
+		g.deleteObject(w, r, key)
+	default:
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrNotImplemented, r.Method))
+	}
+}
+
+// fileName returns the stored file a key names. Keys must already be clean paths, so
+// every object listed can be fetched under the key it is listed with.
+func fileName(key string) (string, error) {
+	name, err := storage.CleanPath(key)
+	if err != nil {
+		return "", err
+	}
+	if name != key {
+		return "", errorx.WrapWithDetails(errorx.ErrInvalidPath, "key is not a clean path: "+key)
+	}
+	return name, nil
+}
+
+// emptyETag is the MD5 of no data, the ETag S3 gives empty objects
+const emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+// etag derives the ETag of a file's current version from its chunk checksums. It has the
+// "<hex>-<parts>" form of multipart ETags, which clients do not compare with an MD5.
+func (g *Gateway) etag(name string) string {
+	storageName := name
+	if ver, ok := g.meta.GetVersion(name, 0); ok {
+		storageName = ver.StorageName
+	}
+	chunks, err := g.meta.ListChunks(storageName)
+	if err != nil || len(chunks) == 0 {
+		return emptyETag
+	}
+	h := sha256.New()
+	for _, c := range chunks {
+		io.WriteString(h, c.Checksum)
+	}
+	return fmt.Sprintf(`"%x-%d"`, h.Sum(nil)[:16], len(chunks))
+}
+
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// s3Status maps an error to the status and code S3 would answer with
+type s3Status struct {
+	err    error
+	status int
+	code   string
+}
+
+// s3Statuses is checked in order with errors.Is, so a context error wins over the storage
+// error it caused
+var s3Statuses = []s3Status{
+	{context.DeadlineExceeded, http.StatusServiceUnavailable, "ServiceUnavailable"},
+	{context.Canceled, http.StatusServiceUnavailable, "ServiceUnavailable"},
+	{errorx.ErrSigV4MissingAuth, http.StatusForbidden, "AccessDenied"},
+	{errorx.ErrSigV4UnknownKey, http.StatusForbidden, "InvalidAccessKeyId"},
+	{errorx.ErrSigV4ClockSkew, http.StatusForbidden, "RequestTimeTooSkewed"},
+	{errorx.ErrSigV4Mismatch, http.StatusForbidden, "SignatureDoesNotMatch"},
+	{errorx.ErrSigV4Malformed, http.StatusBadRequest, "AuthorizationHeaderMalformed"},
+	{errorx.ErrPayloadMismatch, http.StatusBadRequest, "XAmzContentSHA256Mismatch"},
+	{io.ErrUnexpectedEOF, http.StatusBadRequest, "IncompleteBody"},
+	{errorx.ErrNoSuchBucket, http.StatusNotFound, "NoSuchBucket"},
+	{errorx.ErrNoSuchUpload, http.StatusNotFound, "NoSuchUpload"},
+	{errorx.ErrInvalidPart, http.StatusBadRequest, "InvalidPart"},
+	{errorx.ErrNotImplemented, http.StatusNotImplemented, "NotImplemented"},
+	{errorx.ErrFileNotFound, http.StatusNotFound, "NoSuchKey"},
+	{errorx.ErrVersionNotFound, http.StatusNotFound, "NoSuchVersion"},
+	{errorx.ErrUploadInProgress, http.StatusConflict, "OperationAborted"},
+	{errorx.ErrUploadSessionExists, http.StatusConflict, "OperationAborted"},
+	{errorx.ErrInvalidPath, http.StatusBadRequest, "InvalidArgument"},
+	{errorx.ErrInvalidRequest, http.StatusBadRequest, "InvalidArgument"},
+	{errorx.ErrInvalidOffset, http.StatusRequestedRangeNotSatisfiable, "InvalidRange"},
+	{errorx.ErrNotEnoughBackends, http.StatusServiceUnavailable, "ServiceUnavailable"},
+	{errorx.ErrWriteQuorumNotMet, http.StatusServiceUnavailable, "ServiceUnavailable"},
+	{errorx.ErrRetriesExhausted, http.StatusServiceUnavailable, "ServiceUnavailable"},
+	{errorx.ErrStripeUnrecoverable, http.StatusServiceUnavailable, "ServiceUnavailable"},
+}
+
+func statusFor(err error) (int, string) {
+	for _, s := range s3Statuses {
+		if errors.Is(err, s.err) {
+			return s.status, s.code
+		}
+	}
+	return http.StatusInternalServerError, "InternalError"
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := statusFor(err)
+	if status >= http.StatusInternalServerError && status != http.StatusNotImplemented {
+		log.Error("S3 %s %s failed: %v", r.Method, r.URL.Path, err)
+	}
+	writeXML(w, status, errorResponse{
+		Code:      code,
+		Message:   err.Error(),
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Writing S3 response failed: %v", err)
+	}
+}
+
+// readXML decodes a small request body such as a DeleteObjects or
+// CompleteMultipartUpload document
+func readXML(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return errorx.WrapWithDetails(errorx.ErrInvalidRequest, "malformed XML: "+err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/manager"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/sigv4"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+var testCreds = sigv4.Credentials{AccessKeyID: "GATEWAYKEY", SecretAccessKey: "gateway-secret"}
+
+func setupGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	meta, err := metadata.NewMetadataService(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	local, err := cloud.NewLocalStorage(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatalf("failed to create local storage: %v", err)
+	}
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{local})
+	st := storage.NewStorageService(mgr, meta, chunker.NewFileChunker(chunker.ChunkMetadataSize+64))
+	g, err := NewGateway(st, meta, config.GatewayConfig{
+		Bucket:     "storagex",
+		Region:     "us-east-1",
+		StagingDir: dir,
+		AccessKeys: []config.GatewayKey{{AccessKeyID: testCreds.AccessKeyID, SecretAccessKey: testCreds.SecretAccessKey}},
+	})
+	if err != nil {
+		t.Fatalf("NewGateway failed: %v", err)
+	}
+	ts := httptest.NewServer(g)
+	t.Cleanup(func() {
+		ts.Close()
+		g.Close()
+	})
+	return ts
+}
+
+// do sends a signed request with payload as its body
+func do(t *testing.T, method, url string, payload []byte, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	sigv4.Sign(req, testCreds, "us-east-1", "s3", sigv4.PayloadHash(payload), time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func errorCode(body []byte) string {
+	var e errorResponse
+	xml.Unmarshal(body, &e)
+	return e.Code
+}
+
+// TestGateway_S3Client drives the gateway with the S3 backend client
+func TestGateway_S3Client(t *testing.T) {
+	ts := setupGateway(t)
+	client, err := cloud.NewS3Storage(config.S3Config{
+		Endpoint:        ts.URL,
+		Bucket:          "storagex",
+		Prefix:          "chunks",
+		AccessKeyID:     testCreds.AccessKeyID,
+		SecretAccessKey: testCreds.SecretAccessKey,
+		PathStyle:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("gateway "), 50)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := client.UploadChunk(name, data); err != nil {
+			t.Fatalf("UploadChunk(%s) failed: %v", name, err)
+		}
+	}
+	if got, err := client.GetChunk("b"); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("GetChunk = %d bytes, %v", len(got), err)
+	}
+	if err := client.DeleteChunk("a"); err != nil {
+		t.Fatalf("DeleteChunk failed: %v", err)
+	}
+	objects, err := client.List()
+	if err != nil || len(objects) != 2 || objects[0].Name != "b" || objects[0].Size != int64(len(data)) {
+		t.Errorf("List = %+v, %v", objects, err)
+	}
+	if _, err := client.GetChunk("a"); err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("expected NoSuchKey for a deleted object, got %v", err)
+	}
+
+	wrong, _ := cloud.NewS3Storage(config.S3Config{Endpoint: ts.URL, Bucket: "storagex", AccessKeyID: testCreds.AccessKeyID, SecretAccessKey: "nope", PathStyle: true})
+	if err := wrong.UploadChunk("x", data); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("expected SignatureDoesNotMatch, got %v", err)
+	}
+}
+
+func TestGateway_Objects(t *testing.T) {
+	ts := setupGateway(t)
+	url := ts.URL + "/storagex/docs/report.txt"
+	data := bytes.Repeat([]byte("0123456789"), 30)
+
+	resp, body := do(t, http.MethodPut, url, data)
+	if resp.StatusCode != http.StatusOK || !strings.HasSuffix(resp.Header.Get("ETag"), `-5"`) {
+		t.Fatalf("PutObject = %d %s, ETag %s", resp.StatusCode, body, resp.Header.Get("ETag"))
+	}
+	etag := resp.Header.Get("ETag")
+
+	resp, body = do(t, http.MethodGet, url, nil, "Range", "bytes=95-104")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[95:105]) {
+		t.Errorf("ranged GetObject = %d %q", resp.StatusCode, body)
+	}
+	resp, _ = do(t, http.MethodHead, url, nil)
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != int64(len(data)) || resp.Header.Get("ETag") != etag ||
+		resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("HeadObject = %d, length %d, headers %v", resp.StatusCode, resp.ContentLength, resp.Header)
+	}
+	if resp, _ = do(t, http.MethodGet, url, nil, "If-None-Match", etag); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional GetObject = %d, want 304", resp.StatusCode)
+	}
+
+	// A body not matching its signed hash is rejected and leaves the object as it was
+	req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("tampered"))
+	sigv4.Sign(req, testCreds, "us-east-1", "s3", sigv4.PayloadHash([]byte("original")), time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "XAmzContentSHA256Mismatch" {
+		t.Errorf("tampered PutObject = %d %s", resp.StatusCode, body)
+	}
+	if _, body = do(t, http.MethodGet, url, nil); !bytes.Equal(body, data) {
+		t.Error("tampered upload replaced the object")
+	}
+
+	for _, tc := range []struct {
+		method, url string
+		status      int
+		code        string
+	}{
+		{http.MethodGet, ts.URL + "/storagex/missing.txt", http.StatusNotFound, "NoSuchKey"},
+		{http.MethodGet, ts.URL + "/other/docs/report.txt", http.StatusNotFound, "NoSuchBucket"},
+		{http.MethodPut, ts.URL + "/storagex/a//b", http.StatusBadRequest, "InvalidArgument"},
+		{http.MethodGet, url + "?versionId=9", http.StatusNotFound, "NoSuchVersion"},
+	} {
+		resp, body := do(t, tc.method, tc.url, nil)
+		if resp.StatusCode != tc.status || errorCode(body) != tc.code {
+			t.Errorf("%s %s = %d %s, want %d %s", tc.method, tc.url, resp.StatusCode, body, tc.status, tc.code)
+		}
+	}
+
+	if resp, _ = do(t, http.MethodDelete, url, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DeleteObject = %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodDelete, url, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DeleteObject of a missing key = %d", resp.StatusCode)
+	}
+}
+
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	NextMarker            string `xml:"NextMarker"`
+}
+
+func TestGateway_StalledPut(t *testing.T) {
+	ts := setupGateway(t)
+	if resp, body := do(t, http.MethodPut, ts.URL+"/storagex/other.txt", []byte("other")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("PutObject other.txt = %d %s", resp.StatusCode, body)
+	}
+
+	// A client that sends part of its body and then stalls
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+	put := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/storagex/slow.txt", pr)
+		sigv4.Sign(req, testCreds, "us-east-1", "s3", sigv4.UnsignedPayload, time.Now())
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			put <- 0
+			return
+		}
+		resp.Body.Close()
+		put <- resp.StatusCode
+	}()
+	pw.Write([]byte("first half, "))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/storagex/other.txt", nil)
+	sigv4.Sign(req, testCreds, "us-east-1", "s3", sigv4.EmptyPayloadHash, time.Now())
+	resp, err := (&http.Client{Timeout: 2 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("GetObject while a PutObject stalls: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "other" {
+		t.Errorf("GetObject while a PutObject stalls = %d %q", resp.StatusCode, data)
+	}
+
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if code := <-put; code != http.StatusOK {
+		t.Fatalf("stalled PutObject = %d", code)
+	}
+	if _, body := do(t, http.MethodGet, ts.URL+"/storagex/slow.txt", nil); string(body) != "first half, second half" {
+		t.Errorf("GetObject slow.txt = %q", body)
+	}
+}
+
+func TestGateway_ListObjects(t *testing.T) {
+	ts := setupGateway(t)
+	for _, key := range []string{"a.txt", "docs/b.txt", "docs/c.txt", "docs/old/d.txt", "src/e.go", "z.txt"} {
+		if resp, body := do(t, http.MethodPut, ts.URL+"/storagex/"+key, []byte(key)); resp.StatusCode != http.StatusOK {
+			t.Fatalf("PutObject %s = %d %s", key, resp.StatusCode, body)
+		}
+	}
+
+	// One entry per page, keys and common prefixes alike, through both pagination styles
+	for _, v2 := range []bool{true, false} {
+		var got []string
+		next := ""
+		for pages := 0; pages < 10; pages++ {
+			query := "?delimiter=/&max-keys=1&marker=" + next
+			if v2 {
+				query = "?list-type=2&delimiter=/&max-keys=1&continuation-token=" + next
+			}
+			resp, body := do(t, http.MethodGet, ts.URL+"/storagex"+query, nil)
+			var res listResult
+			if resp.StatusCode != http.StatusOK || xml.Unmarshal(body, &res) != nil {
+				t.Fatalf("ListObjects = %d %s", resp.StatusCode, body)
+			}
+			for _, c := range res.Contents {
+				got = append(got, c.Key)
+			}
+			for _, p := range res.CommonPrefixes {
+				got = append(got, p.Prefix)
+			}
+			if !res.IsTruncated {
+				break
+			}
+			next = res.NextMarker
+			if v2 {
+				next = res.NextContinuationToken
+			}
+		}
+		if want := []string{"a.txt", "docs/", "src/", "z.txt"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("v2=%v listing = %v, want %v", v2, got, want)
+		}
+	}
+
+	resp, body := do(t, http.MethodGet, ts.URL+"/storagex?list-type=2&prefix=docs/&delimiter=/", nil)
+	var res listResult
+	if resp.StatusCode != http.StatusOK || xml.Unmarshal(body, &res) != nil || len(res.Contents) != 2 ||
+		len(res.CommonPrefixes) != 1 || res.CommonPrefixes[0].Prefix != "docs/old/" {
+		t.Errorf("listing docs/ = %d %s", resp.StatusCode, body)
+	}
+
+	del := []byte(`<Delete><Object><Key>a.txt</Key></Object><Object><Key>docs/b.txt</Key></Object></Delete>`)
+	if resp, body := do(t, http.MethodPost, ts.URL+"/storagex?delete", del); resp.StatusCode != http.StatusOK || strings.Count(string(body), "<Deleted>") != 2 {
+		t.Errorf("DeleteObjects = %d %s", resp.StatusCode, body)
+	}
+	_, body = do(t, http.MethodGet, ts.URL+"/storagex?list-type=2", nil)
+	if res = (listResult{}); xml.Unmarshal(body, &res) != nil || len(res.Contents) != 4 {
+		t.Errorf("%d objects left after DeleteObjects, want 4", len(res.Contents))
+	}
+}
+
+func TestGateway_Multipart(t *testing.T) {
+	ts := setupGateway(t)
+	url := ts.URL + "/storagex/big.bin"
+	parts := [][]byte{bytes.Repeat([]byte{'a'}, 150), bytes.Repeat([]byte{'b'}, 150), []byte("tail")}
+
+	start := func() string {
+		resp, body := do(t, http.MethodPost, url+"?uploads", nil)
+		var res initiateMultipartUploadResult
+		if resp.StatusCode != http.StatusOK || xml.Unmarshal(body, &res) != nil || res.UploadID == "" {
+			t.Fatalf("CreateMultipartUpload = %d %s", resp.StatusCode, body)
+		}
+		return res.UploadID
+	}
+	id := start()
+	etags := make([]string, len(parts))
+	for _, i := range []int{2, 0, 1} {
+		resp, body := do(t, http.MethodPut, fmt.Sprintf("%s?partNumber=%d&uploadId=%s", url, i+1, id), parts[i])
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("UploadPart %d = %d %s", i+1, resp.StatusCode, body)
+		}
+		etags[i] = resp.Header.Get("ETag")
+	}
+	complete := func(etags []string) []byte {
+		var doc strings.Builder
+		doc.WriteString("<CompleteMultipartUpload>")
+		for i, etag := range etags {
+			fmt.Fprintf(&doc, "<Part><PartNumber>%d</PartNumber><ETag>%s</ETag></Part>", i+1, etag)
+		}
+		doc.WriteString("</CompleteMultipartUpload>")
+		return []byte(doc.String())
+	}
+
+	resp, body := do(t, http.MethodPost, url+"?uploadId="+id, complete([]string{etags[0], etags[0], etags[2]}))
+	if resp.StatusCode != http.StatusBadRequest || errorCode(body) != "InvalidPart" {
+		t.Errorf("completing with a wrong ETag = %d %s", resp.StatusCode, body)
+	}
+	if resp, body = do(t, http.MethodPost, url+"?uploadId="+id, complete(etags)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("CompleteMultipartUpload = %d %s", resp.StatusCode, body)
+	}
+	if _, body = do(t, http.MethodGet, url, nil); !bytes.Equal(body, bytes.Join(parts, nil)) {
+		t.Errorf("completed object has %d bytes, want the parts joined", len(body))
+	}
+	if resp, body = do(t, http.MethodPost, url+"?uploadId="+id, complete(etags)); errorCode(body) != "NoSuchUpload" {
+		t.Errorf("completing twice = %d %s", resp.StatusCode, body)
+	}
+
+	id = start()
+	if resp, _ = do(t, http.MethodDelete, url+"?uploadId="+id, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("AbortMultipartUpload = %d", resp.StatusCode)
+	}
+	if resp, body = do(t, http.MethodPut, url+"?partNumber=1&uploadId="+id, parts[0]); errorCode(body) != "NoSuchUpload" {
+		t.Errorf("UploadPart after abort = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestChunkedReader(t *testing.T) {
+	encoded := "5\r\nhello\r\n7;chunk-signature=x\r\n, world\r\n0\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"
+	got, err := io.ReadAll(&chunkedReader{r: bufioReader(encoded)})
+	if err != nil || string(got) != "hello, world" {
+		t.Errorf("decoded %q, %v", got, err)
+	}
+	_, err = io.ReadAll(&chunkedReader{r: bufioReader("5\r\nhel")})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated body, got %v", err)
+	}
+	_, err = io.ReadAll(&chunkedReader{r: bufioReader("zz\r\n")})
+	if !errors.Is(err, errorx.ErrInvalidRequest) {
+		t.Errorf("expected ErrInvalidRequest for a bad header, got %v", err)
+	}
+}
+
+func bufioReader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+// maxPartNumber is the highest part number S3 accepts
+const maxPartNumber = 10000
+
+// multipartUpload is an upload whose parts are staged in dir. Completing it streams the
+// parts in order into one StorageService upload, so the file is chunked, deduplicated and
+// placed like any other; the parts themselves never reach the backends.
+type multipartUpload struct {
+	key   string
+	dir   string
+	parts map[int]string // part number -> quoted MD5 ETag
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (g *Gateway) createMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	if _, err := fileName(key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id := newID(16)
+	dir := filepath.Join(g.staging, id)
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	g.mu.Lock()
+	g.uploads[id] = &multipartUpload{key: key, dir: dir, parts: make(map[int]string)}
+	g.mu.Unlock()
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{Xmlns: s3Namespace, Bucket: g.bucket, Key: key, UploadID: id})
+}
+
+// upload returns the multipart upload named by the uploadId parameter, which must be for key
+func (g *Gateway) upload(r *http.Request, key string) (string, *multipartUpload, error) {
+	id := r.URL.Query().Get("uploadId")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	up, ok := g.uploads[id]
+	if !ok || up.key != key {
+		return "", nil, errorx.WrapWithDetails(errorx.ErrNoSuchUpload, id)
+	}
+	return id, up, nil
+}
+
+func (g *Gateway) uploadPart(w http.ResponseWriter, r *http.Request, key string) {
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > maxPartNumber {
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrInvalidRequest, fmt.Sprintf("part number must be between 1 and %d", maxPartNumber)))
+		return
+	}
+	_, up, err := g.upload(r, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	src, err := body(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Parts are written aside and renamed into place, so a part uploaded again replaces
+	// the previous one only once it is complete
+	tmp, err := os.CreateTemp(up.dir, ".part-*")
+	if err != nil {
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrNoSuchUpload, "upload was aborted"))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	sum := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, sum), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), partPath(up.dir, number))
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	etag := `"` + hex.EncodeToString(sum.Sum(nil)) + `"`
+	g.mu.Lock()
+	up.parts[number] = etag
+	g.mu.Unlock()
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func partPath(dir string, number int) string {
+	return filepath.Join(dir, strconv.Itoa(number))
+}
+
+func (g *Gateway) completeMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	id, up, err := g.upload(r, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	src, err := body(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req completeMultipartUpload
+	if err := readXML(src, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.Parts) == 0 {
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrInvalidPart, "no parts listed"))
+		return
+	}
+
+	// The upload leaves the table while it completes, so it cannot complete twice or be
+	// aborted midway; it is put back if completing fails and the client may retry
+	g.mu.Lock()
+	if g.uploads[id] != up {
+		g.mu.Unlock()
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrNoSuchUpload, id))
+		return
+	}
+	delete(g.uploads, id)
+	parts := make(map[int]string, len(up.parts))
+	for n, etag := range up.parts {
+		parts[n] = etag
+	}
+	g.mu.Unlock()
+
+	err = g.assemble(r, up, req, parts)
+	if err != nil {
+		g.mu.Lock()
+		g.uploads[id] = up
+		g.mu.Unlock()
+		writeError(w, r, err)
+		return
+	}
+	if err := os.RemoveAll(up.dir); err != nil {
+		log.Error("Removing staged parts of %s: %v", key, err)
+	}
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{Xmlns: s3Namespace, Bucket: g.bucket, Key: key, ETag: g.etag(key)})
+}
+
+// assemble checks the parts a CompleteMultipartUpload lists against the staged ones and
+// uploads them in order as the object
+func (g *Gateway) assemble(r *http.Request, up *multipartUpload, req completeMultipartUpload, staged map[int]string) error {
+	if !sort.SliceIsSorted(req.Parts, func(i, j int) bool { return req.Parts[i].PartNumber < req.Parts[j].PartNumber }) {
+		return errorx.WrapWithDetails(errorx.ErrInvalidPart, "parts are not in ascending order")
+	}
+	readers := make([]io.Reader, 0, len(req.Parts))
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber == req.Parts[i-1].PartNumber {
+			return errorx.WrapWithDetails(errorx.ErrInvalidPart, fmt.Sprintf("part %d listed twice", p.PartNumber))
+		}
+		etag, ok := staged[p.PartNumber]
+		if !ok || strings.Trim(p.ETag, `"`) != strings.Trim(etag, `"`) {
+			return errorx.WrapWithDetails(errorx.ErrInvalidPart, fmt.Sprintf("part %d", p.PartNumber))
+		}
+		f, err := os.Open(partPath(up.dir, p.PartNumber))
+		if err != nil {
+			return errorx.Wrap(errorx.ErrInvalidPart, err)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	name, err := fileName(up.key)
+	if err != nil {
+		return err
+	}
+	_, err = g.storage.UploadReaderContext(r.Context(), io.MultiReader(readers...), name, storage.DefaultUploadOptions())
+	return err
+}
+
+func (g *Gateway) abortMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	id, up, err := g.upload(r, key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	g.mu.Lock()
+	delete(g.uploads, id)
+	g.mu.Unlock()
+	if err := os.RemoveAll(up.dir); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,360 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+// s3TimeFormat is how S3 writes times in XML
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// maxKeys is the most keys a listing returns, S3's own limit
+const maxKeys = 1000
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listBucketResult answers both ListObjects versions; each leaves the other's fields empty
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                string         `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	KeyCount              int            `xml:"KeyCount,omitempty"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter) {
+	writeXML(w, http.StatusOK, listAllMyBucketsResult{
+		Xmlns:   s3Namespace,
+		Buckets: []bucketEntry{{Name: g.bucket, CreationDate: g.created.Format(s3TimeFormat)}},
+	})
+}
+
+// listObjects answers ListObjectsV2 (list-type=2) and the original ListObjects. Keys are
+// read from metadata in pages; with a delimiter, the keys sharing a common prefix are
+// skipped in one step by resuming after the prefix. Markers and continuation tokens are
+// the last key or common prefix returned.
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	v2 := q.Get("list-type") == "2"
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	limit := maxKeys
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, errorx.WrapWithDetails(errorx.ErrInvalidRequest, "max-keys must be a non-negative integer"))
+			return
+		}
+		limit = min(n, maxKeys)
+	}
+
+	res := listBucketResult{Xmlns: s3Namespace, Name: g.bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: limit}
+	var after string
+	if v2 {
+		res.StartAfter = q.Get("start-after")
+		res.ContinuationToken = q.Get("continuation-token")
+		after = res.StartAfter
+		if res.ContinuationToken != "" {
+			token, err := base64.RawURLEncoding.DecodeString(res.ContinuationToken)
+			if err != nil {
+				writeError(w, r, errorx.WrapWithDetails(errorx.ErrInvalidRequest, "malformed continuation token"))
+				return
+			}
+			after = string(token)
+		}
+	} else {
+		res.Marker = q.Get("marker")
+		after = res.Marker
+	}
+
+	after = skipGroup(after, prefix, delimiter)
+	var last string // the last key or common prefix returned
+	for count := 0; count < limit; {
+		page, err := g.meta.ListPage(prefix, after, limit-count)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, f := range page {
+			after = f.FileName
+			if common := commonPrefixOf(f.FileName, prefix, delimiter); common != "" {
+				res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: common})
+				after, last = skipGroup(common, prefix, delimiter), common
+				count++
+				break
+			}
+			res.Contents = append(res.Contents, objectEntry{
+				Key:          f.FileName,
+				LastModified: f.ModifiedAt.UTC().Format(s3TimeFormat),
+				ETag:         g.etag(f.FileName),
+				Size:         f.TotalSize,
+				StorageClass: "STANDARD",
+			})
+			last = f.FileName
+			count++
+		}
+	}
+	if last != "" {
+		// Truncated when anything follows what was returned
+		more, err := g.meta.ListPage(prefix, after, 1)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		res.IsTruncated = len(more) > 0
+	}
+	if res.IsTruncated {
+		if v2 {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			res.NextMarker = last
+		}
+	}
+	if v2 {
+		res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+	}
+	if q.Get("encoding-type") == "url" {
+		res.encodeKeys()
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+// commonPrefixOf returns the common prefix rolling name up in a listing with a delimiter,
+// or "" when name is listed as itself
+func commonPrefixOf(name, prefix, delimiter string) string {
+	if delimiter == "" || !strings.HasPrefix(name, prefix) {
+		return ""
+	}
+	if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+		return name[:len(prefix)+i+len(delimiter)]
+	}
+	return ""
+}
+
+// skipGroup returns the position to resume a listing after, given the last key or common
+// prefix returned: after a common prefix, every name it rolls up is skipped, as they all
+// sort below the prefix followed by 0xff
+func skipGroup(after, prefix, delimiter string) string {
+	if common := commonPrefixOf(after, prefix, delimiter); common != "" {
+		return common + "\xff"
+	}
+	return after
+}
+
+// encodeKeys URL-encodes the keys and prefixes of a listing, as clients asking for
+// encoding-type=url decode them
+func (res *listBucketResult) encodeKeys() {
+	enc := func(s string) string {
+		return strings.ReplaceAll(url.QueryEscape(s), "%2F", "/")
+	}
+	res.EncodingType = "url"
+	res.Prefix, res.Delimiter = enc(res.Prefix), enc(res.Delimiter)
+	res.Marker, res.NextMarker, res.StartAfter = enc(res.Marker), enc(res.NextMarker), enc(res.StartAfter)
+	for i := range res.Contents {
+		res.Contents[i].Key = enc(res.Contents[i].Key)
+	}
+	for i := range res.CommonPrefixes {
+		res.CommonPrefixes[i].Prefix = enc(res.CommonPrefixes[i].Prefix)
+	}
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	src, err := body(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if strings.HasSuffix(key, "/") {
+		// Directory markers: directories exist as the parents of files and are not stored
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", emptyETag)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	name, err := fileName(key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Like parts, the body is staged first, so a slow client never holds the storage
+	// write lock; a body failing its payload hash is rejected before anything is stored
+	tmp, err := storage.StageReader(src, g.staging)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := g.storage.UploadReaderContext(r.Context(), tmp, name, storage.DefaultUploadOptions()); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", g.etag(name))
+	w.WriteHeader(http.StatusOK)
+}
+
+// getObject answers GetObject and HeadObject. versionId selects an older version by its
+// number, as listed by storagex versions.
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	name, err := fileName(key)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	version := 0
+	if v := r.URL.Query().Get("versionId"); v != "" {
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			writeError(w, r, errorx.WrapWithDetails(errorx.ErrVersionNotFound, v))
+			return
+		}
+	}
+	f, err := g.storage.OpenFileVersionContext(r.Context(), name, version)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer f.Close()
+	var modified time.Time
+	if ver, ok := g.meta.GetVersion(name, version); ok {
+		modified = ver.CreatedAt
+		w.Header().Set("X-Amz-Version-Id", strconv.Itoa(ver.Version))
+	} else if meta, ok := g.meta.GetFile(name); ok {
+		modified = meta.ModifiedAt
+	}
+	if version == 0 {
+		w.Header().Set("ETag", g.etag(name))
+	}
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "binary/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	// ServeContent answers Range and conditional requests against the ETag set above
+	http.ServeContent(w, r, name, modified, f)
+}
+
+// deleteObject succeeds for keys that do not exist, like S3
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, key string) {
+	if err := g.delete(r, key); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) delete(r *http.Request, key string) error {
+	name, err := fileName(strings.TrimSuffix(key, "/"))
+	if err != nil {
+		return err
+	}
+	if _, ok := g.meta.GetFile(name); !ok {
+		return nil
+	}
+	return g.storage.DeleteFileContext(r.Context(), name)
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedEntry struct {
+	Key string `xml:"Key"`
+}
+
+type deleteErrorEntry struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name           `xml:"DeleteResult"`
+	Xmlns   string             `xml:"xmlns,attr"`
+	Deleted []deletedEntry     `xml:"Deleted"`
+	Errors  []deleteErrorEntry `xml:"Error"`
+}
+
+func (g *Gateway) deleteObjects(w http.ResponseWriter, r *http.Request) {
+	src, err := body(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req deleteRequest
+	if err := readXML(src, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(req.Objects) > maxKeys {
+		writeError(w, r, errorx.WrapWithDetails(errorx.ErrInvalidRequest, "too many keys"))
+		return
+	}
+	res := deleteResult{Xmlns: s3Namespace}
+	for _, obj := range req.Objects {
+		if err := g.delete(r, obj.Key); err != nil {
+			_, code := statusFor(err)
+			res.Errors = append(res.Errors, deleteErrorEntry{Key: obj.Key, Code: code, Message: err.Error()})
+		} else if !req.Quiet {
+			res.Deleted = append(res.Deleted, deletedEntry{Key: obj.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, res)
+}
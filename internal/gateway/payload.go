@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/sigv4"
+)
+
+// streamingUnsignedTrailer marks aws-chunked bodies whose chunks are not signed, as sent
+// by recent SDKs with checksum trailers
+const streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+// body returns the payload of a signed request. sigv4.Verify only checks the hash the
+// client declared in X-Amz-Content-Sha256, so the body is checked against it as it is
+// read: the final Read fails with ErrPayloadMismatch, which rolls an upload back.
+func body(r *http.Request) (io.Reader, error) {
+	declared := r.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case declared == "":
+		return &hashedReader{r: r.Body, h: sha256.New(), want: sigv4.EmptyPayloadHash}, nil
+	case declared == sigv4.UnsignedPayload:
+		return r.Body, nil
+	case declared == streamingUnsignedTrailer:
+		return &chunkedReader{r: bufio.NewReader(r.Body)}, nil
+	case strings.HasPrefix(declared, "STREAMING-"):
+		return nil, errorx.WrapWithDetails(errorx.ErrNotImplemented, "payload signing "+declared)
+	}
+	return &hashedReader{r: r.Body, h: sha256.New(), want: strings.ToLower(declared)}, nil
+}
+
+// hashedReader fails at the end of its data if the data does not hash to want
+type hashedReader struct {
+	r    io.Reader
+	h    hash.Hash
+	want string
+}
+
+func (h *hashedReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(h.h.Sum(nil)) != h.want {
+		return n, errorx.ErrPayloadMismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked body: chunks of "<hex size>[;extensions]\r\n<data>\r\n"
+// ending with a chunk of size 0, then trailer lines and an empty line. Trailing checksums
+// are not checked.
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int64 // bytes left in the current chunk
+	done bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.left == 0 {
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && c.left == 0 {
+		err = c.expectCRLF()
+	}
+	return n, err
+}
+
+// nextChunk reads a chunk header, or the trailer after the last chunk
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.line()
+	if err != nil {
+		return err
+	}
+	sizeHex, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil || size < 0 {
+		return errorx.WrapWithDetails(errorx.ErrInvalidRequest, "malformed aws-chunked header")
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+	for {
+		trailer, err := c.line()
+		if err != nil {
+			return err
+		}
+		if trailer == "" {
+			c.done = true
+			return nil
+		}
+	}
+}
+
+func (c *chunkedReader) line() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err == io.EOF {
+		// The final CRLF is optional after the trailers
+		if line == "" {
+			return "", nil
+		}
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *chunkedReader) expectCRLF() error {
+	var crlf [2]byte
+	if _, err := io.ReadFull(c.r, crlf[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if string(crlf[:]) != "\r\n" {
+		return errorx.WrapWithDetails(errorx.ErrInvalidRequest, "malformed aws-chunked body")
+	}
+	return nil
+}
@@ -1,25 +1,60 @@
 package manager
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
 	"github.com/sayuyere/storageX/internal/chunker"
 	"github.com/sayuyere/storageX/internal/cloud"
 	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metrics"
 )
 
 type StorageManager struct {
-	cloudSvcs []cloud.CloudStorage
+	cloudSvcs   []cloud.CloudStorage
+	placement   PlacementPolicy
+	replicas    int // number of distinct backends each chunk is written to
+	writeQuorum int // replicas that must succeed for an upload to count
 }
 
 func NewStorageManager(cloudSvcs []cloud.CloudStorage) *StorageManager {
 	return &StorageManager{
-		cloudSvcs: cloudSvcs,
+		cloudSvcs:   cloudSvcs,
+		placement:   FirstPolicy{},
+		replicas:    1,
+		writeQuorum: 1,
 	}
 }
 
+// SetPlacementPolicy replaces the policy choosing backends for new chunks
+func (sm *StorageManager) SetPlacementPolicy(policy PlacementPolicy) {
+	sm.placement = policy
+}
+
 func (sm *StorageManager) AddCloudStorage(storage cloud.CloudStorage) {
 	sm.cloudSvcs = append(sm.cloudSvcs, storage)
 }
 
+// SetReplication sets the replication factor and write quorum.
+// A quorum of 0 (or one above the factor) means every replica must succeed.
+func (sm *StorageManager) SetReplication(factor, writeQuorum int) {
+	if factor < 1 {
+		factor = 1
+	}
+	if writeQuorum < 1 || writeQuorum > factor {
+		writeQuorum = factor
+	}
+	sm.replicas = factor
+	sm.writeQuorum = writeQuorum
+}
+
+// Replication returns the configured replication factor and write quorum
+func (sm *StorageManager) Replication() (int, int) {
+	return sm.replicas, sm.writeQuorum
+}
+
 func (sm *StorageManager) SearchStorageID(id string) cloud.CloudStorage {
 
 	for _, svc := range sm.cloudSvcs {
@@ -30,33 +65,245 @@ func (sm *StorageManager) SearchStorageID(id string) cloud.CloudStorage {
 	return nil
 }
 
-func (sm *StorageManager) GetCloudSvcForStorage() cloud.CloudStorage {
+// Backends returns each registered storage system once
+func (sm *StorageManager) Backends() []cloud.CloudStorage {
+	return sm.distinctCloudSvcs()
+}
+
+// distinctCloudSvcs returns the configured backends with duplicate storage IDs removed
+func (sm *StorageManager) distinctCloudSvcs() []cloud.CloudStorage {
+	var (
+		result []cloud.CloudStorage
+		seen   = make(map[string]bool)
+	)
+	for _, svc := range sm.cloudSvcs {
+		id := svc.StorageSystemID()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, svc)
+	}
+	return result
+}
+
+// GetCloudSvcForStorage returns the backend the placement policy prefers for a chunk
+func (sm *StorageManager) GetCloudSvcForStorage(name string, size int64) cloud.CloudStorage {
 	if len(sm.cloudSvcs) == 0 {
 		panic("no cloud storage configured")
 	}
-	return sm.cloudSvcs[0] // Assuming first is the default
+	targets := sm.GetCloudSvcsForChunk(name, size, 1)
+	if len(targets) == 0 {
+		return nil
+	}
+	return targets[0]
+}
+
+// RefreshCapacity asks every backend for its remaining size, which instrumented backends
+// record in the metrics package. Failures are only logged.
+func (sm *StorageManager) RefreshCapacity(ctx context.Context) {
+	for _, svc := range sm.distinctCloudSvcs() {
+		if _, err := cloud.GetRemainingSize(ctx, svc); err != nil {
+			log.Error("Querying the remaining size of %s failed: %v", svc.StorageSystemID(), err)
+		}
+	}
+}
+
+// GetCloudSvcsForChunk asks the placement policy for up to n distinct backends for a chunk
+func (sm *StorageManager) GetCloudSvcsForChunk(name string, size int64, n int) []cloud.CloudStorage {
+	return sm.GetCloudSvcsForChunkContext(context.Background(), name, size, n)
+}
+
+// GetCloudSvcsForChunkContext is GetCloudSvcsForChunk bounded by ctx, for policies that
+// query the backends
+func (sm *StorageManager) GetCloudSvcsForChunkContext(ctx context.Context, name string, size int64, n int) []cloud.CloudStorage {
+	return Place(ctx, sm.placement, name, size, sm.distinctCloudSvcs(), n)
 }
 
 // UploadChunk uploads a chunk to the selected cloud storage
 func (sm *StorageManager) UploadChunk(name string, c chunker.Chunk) (cloud.CloudStorage, error) {
-	storageLocation := sm.GetCloudSvcForStorage()
-	return storageLocation, storageLocation.UploadChunk(name, c.Bytes())
+	replicas, err := sm.UploadChunkReplicas(name, c)
+	if err != nil {
+		return nil, err
+	}
+	return replicas[0], nil
+}
+
+// UploadChunkReplicas writes a chunk to replication-factor distinct backends in parallel and
+// returns those that succeeded. If fewer than the write quorum succeed, the written
+// replicas are removed again and an error is returned.
+func (sm *StorageManager) UploadChunkReplicas(name string, c chunker.Chunk) ([]cloud.CloudStorage, error) {
+	return sm.UploadObjectReplicas(name, c.Bytes())
+}
+
+// UploadObjectReplicas is UploadChunkReplicas for an already serialized (and possibly
+// encrypted) object
+func (sm *StorageManager) UploadObjectReplicas(name string, data []byte) ([]cloud.CloudStorage, error) {
+	return sm.UploadObjectReplicasContext(context.Background(), name, data)
+}
+
+// UploadObjectReplicasContext is UploadObjectReplicas bounded by ctx. Replicas written
+// before a failure are removed even when ctx is already done.
+func (sm *StorageManager) UploadObjectReplicasContext(ctx context.Context, name string, data []byte) ([]cloud.CloudStorage, error) {
+	if len(sm.cloudSvcs) == 0 {
+		panic("no cloud storage configured")
+	}
+	targets := sm.GetCloudSvcsForChunkContext(ctx, name, int64(len(data)), sm.replicas)
+	if len(targets) < sm.writeQuorum {
+		return nil, errorx.WrapWithDetails(errorx.ErrNotEnoughBackends,
+			fmt.Sprintf("need %d, have %d", sm.writeQuorum, len(targets)))
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(targets))
+	)
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target cloud.CloudStorage) {
+			defer wg.Done()
+			errs[i] = cloud.UploadChunk(ctx, target, name, data)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var (
+		written []cloud.CloudStorage
+		failed  []error
+	)
+	for i, target := range targets {
+		if errs[i] != nil {
+			log.Error("replica upload of %s to %s failed: %v", name, target.StorageSystemID(), errs[i])
+			metrics.ReplicaFailure(target.StorageSystemID(), "upload")
+			failed = append(failed, errs[i])
+			continue
+		}
+		written = append(written, target)
+	}
+	if len(written) < sm.writeQuorum {
+		metrics.QuorumFailure()
+		cleanup := context.WithoutCancel(ctx)
+		for _, target := range written {
+			_ = cloud.DeleteChunk(cleanup, target, name)
+		}
+		if len(targets) == 1 {
+			// Keep the provider error intact for the single-backend case
+			return nil, failed[0]
+		}
+		return nil, errorx.WrapWithDetails(errorx.ErrWriteQuorumNotMet,
+			fmt.Sprintf("chunk %s: %d of %d replicas written, errors: %v", name, len(written), sm.writeQuorum, failed))
+	}
+	return written, nil
+}
+
+// UploadShards writes shards[i] under names[i] to targets[i] in parallel, skipping nil shards.
+// Erasure coding relies on every shard landing on its own backend, so nothing is retried
+// elsewhere: if any write fails, the shards already written are removed and an error returned.
+func (sm *StorageManager) UploadShards(names []string, shards [][]byte, targets []cloud.CloudStorage) error {
+	return sm.UploadShardsContext(context.Background(), names, shards, targets)
+}
+
+// UploadShardsContext is UploadShards bounded by ctx
+func (sm *StorageManager) UploadShardsContext(ctx context.Context, names []string, shards [][]byte, targets []cloud.CloudStorage) error {
+	if len(targets) < len(shards) {
+		return errorx.WrapWithDetails(errorx.ErrNotEnoughBackends,
+			fmt.Sprintf("need %d, have %d", len(shards), len(targets)))
+	}
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(shards))
+	)
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = cloud.UploadChunk(ctx, targets[i], names[i], shards[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		cleanup := context.WithoutCancel(ctx)
+		for j, shard := range shards {
+			if shard != nil && errs[j] == nil {
+				_ = cloud.DeleteChunk(cleanup, targets[j], names[j])
+			}
+		}
+		return errorx.WrapWithDetails(err, names[i])
+	}
+	return nil
 }
 
 // GetChunk gets a chunk from the selected cloud storage
 func (sm *StorageManager) GetChunk(storageSystemID string, name string) ([]byte, error) {
+	return sm.GetChunkContext(context.Background(), storageSystemID, name)
+}
+
+// GetChunkContext is GetChunk bounded by ctx
+func (sm *StorageManager) GetChunkContext(ctx context.Context, storageSystemID string, name string) ([]byte, error) {
 	storageLocation := sm.SearchStorageID(storageSystemID)
 	if storageLocation == nil {
 		return nil, errorx.ErrStorageNotFound
 	}
-	return storageLocation.GetChunk(name)
+	return cloud.GetChunk(ctx, storageLocation, name)
+}
+
+// GetChunkReplicas tries each replica location in order and returns the first successful read
+func (sm *StorageManager) GetChunkReplicas(storageSystemIDs []string, name string) ([]byte, error) {
+	return sm.GetChunkReplicasContext(context.Background(), storageSystemIDs, name)
+}
+
+// GetChunkReplicasContext is GetChunkReplicas bounded by ctx; it stops trying further
+// replicas once ctx is done
+func (sm *StorageManager) GetChunkReplicasContext(ctx context.Context, storageSystemIDs []string, name string) ([]byte, error) {
+	var lastErr error = errorx.ErrStorageNotFound
+	for _, id := range storageSystemIDs {
+		data, err := sm.GetChunkContext(ctx, id, name)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Error("replica read of %s from %s failed: %v", name, id, err)
+		metrics.ReplicaFailure(id, "get")
+		lastErr = err
+	}
+	return nil, lastErr
 }
 
 // DeleteChunk deletes a chunk from the selected cloud storage
 func (sm *StorageManager) DeleteChunk(storageSystemID string, name string) error {
+	return sm.DeleteChunkContext(context.Background(), storageSystemID, name)
+}
+
+// DeleteChunkContext is DeleteChunk bounded by ctx
+func (sm *StorageManager) DeleteChunkContext(ctx context.Context, storageSystemID string, name string) error {
 	storageLocation := sm.SearchStorageID(storageSystemID)
 	if storageLocation == nil {
 		return errorx.ErrStorageNotFound
 	}
-	return storageLocation.DeleteChunk(name)
+	return cloud.DeleteChunk(ctx, storageLocation, name)
+}
+
+// DeleteChunkReplicas deletes a chunk from every replica location, returning the first error
+func (sm *StorageManager) DeleteChunkReplicas(storageSystemIDs []string, name string) error {
+	return sm.DeleteChunkReplicasContext(context.Background(), storageSystemIDs, name)
+}
+
+// DeleteChunkReplicasContext is DeleteChunkReplicas bounded by ctx
+func (sm *StorageManager) DeleteChunkReplicasContext(ctx context.Context, storageSystemIDs []string, name string) error {
+	var firstErr error
+	for _, id := range storageSystemIDs {
+		if err := sm.DeleteChunkContext(ctx, id, name); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
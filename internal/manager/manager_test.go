@@ -6,6 +6,7 @@ import (
 
 	"github.com/sayuyere/storageX/internal/chunker"
 	"github.com/sayuyere/storageX/internal/cloud"
+	errorx "github.com/sayuyere/storageX/internal/errors"
 	"github.com/sayuyere/storageX/internal/manager"
 )
 
@@ -41,6 +42,13 @@ func (m *mockCloudStorage) DeleteChunk(name string) error {
 }
 func (m *mockCloudStorage) GetRemainingSize() (int64, error) { return 0, nil }
 func (m *mockCloudStorage) StorageSystemID() string          { return m.id }
+func (m *mockCloudStorage) List() ([]cloud.ObjectInfo, error) {
+	var result []cloud.ObjectInfo
+	for name, data := range m.chunks {
+		result = append(result, cloud.ObjectInfo{Name: name, Size: int64(len(data))})
+	}
+	return result, nil
+}
 
 func newMockCloudStorage(id string) *mockCloudStorage {
 	return &mockCloudStorage{
@@ -95,3 +103,81 @@ func TestManager_SearchStorageID(t *testing.T) {
 		t.Errorf("SearchStorageID should return nil for missing id")
 	}
 }
+
+func TestManager_UploadChunkReplicas(t *testing.T) {
+	mock1 := newMockCloudStorage("id1")
+	mock2 := newMockCloudStorage("id2")
+	mock3 := newMockCloudStorage("id3")
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{mock1, mock2, mock3})
+	mgr.SetReplication(2, 2)
+
+	replicas, err := mgr.UploadChunkReplicas("chunk1", chunker.Chunk{Data: []byte("hello")})
+	if err != nil {
+		t.Fatalf("UploadChunkReplicas failed: %v", err)
+	}
+	if len(replicas) != 2 || replicas[0] != mock1 || replicas[1] != mock2 {
+		t.Fatalf("expected replicas on id1 and id2, got %v", replicas)
+	}
+	if _, ok := mock3.chunks["chunk1"]; ok {
+		t.Error("chunk written to more backends than the replication factor")
+	}
+}
+
+func TestManager_UploadChunkReplicas_Quorum(t *testing.T) {
+	mock1 := newMockCloudStorage("id1")
+	mock2 := newMockCloudStorage("id2")
+	mock2.failOps["upload"] = true
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{mock1, mock2})
+
+	// Quorum of 1 tolerates one failed replica
+	mgr.SetReplication(2, 1)
+	replicas, err := mgr.UploadChunkReplicas("chunk1", chunker.Chunk{Data: []byte("hello")})
+	if err != nil {
+		t.Fatalf("UploadChunkReplicas failed: %v", err)
+	}
+	if len(replicas) != 1 || replicas[0] != mock1 {
+		t.Errorf("expected single replica on id1, got %v", replicas)
+	}
+
+	// Quorum of 2 fails and removes the replica that was written
+	mgr.SetReplication(2, 2)
+	_, err = mgr.UploadChunkReplicas("chunk2", chunker.Chunk{Data: []byte("hello")})
+	if !errors.Is(err, errorx.ErrWriteQuorumNotMet) {
+		t.Fatalf("expected ErrWriteQuorumNotMet, got %v", err)
+	}
+	if _, ok := mock1.chunks["chunk2"]; ok {
+		t.Error("partial replica not cleaned up after quorum failure")
+	}
+}
+
+func TestManager_UploadChunkReplicas_DistinctBackends(t *testing.T) {
+	mock := newMockCloudStorage("same")
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{mock, mock})
+	mgr.SetReplication(2, 2)
+	if _, err := mgr.UploadChunkReplicas("chunk1", chunker.Chunk{Data: []byte("hello")}); !errors.Is(err, errorx.ErrNotEnoughBackends) {
+		t.Errorf("expected ErrNotEnoughBackends for duplicate backends, got %v", err)
+	}
+}
+
+func TestManager_GetChunkReplicas_Fallback(t *testing.T) {
+	mock1 := newMockCloudStorage("id1")
+	mock2 := newMockCloudStorage("id2")
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{mock1, mock2})
+	mgr.SetReplication(2, 2)
+	chunk := chunker.Chunk{Data: []byte("hello")}
+	if _, err := mgr.UploadChunkReplicas("chunk1", chunk); err != nil {
+		t.Fatalf("UploadChunkReplicas failed: %v", err)
+	}
+	mock1.failOps["get"] = true
+	data, err := mgr.GetChunkReplicas([]string{"id1", "id2"}, "chunk1")
+	if err != nil {
+		t.Fatalf("GetChunkReplicas failed: %v", err)
+	}
+	if string(data) != string(chunk.Bytes()) {
+		t.Errorf("unexpected data from fallback replica: %q", data)
+	}
+	mock2.failOps["get"] = true
+	if _, err := mgr.GetChunkReplicas([]string{"id1", "id2"}, "chunk1"); err == nil {
+		t.Error("expected error when every replica fails")
+	}
+}
@@ -0,0 +1,324 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+)
+
+// Placement policy names accepted in config
+const (
+	PolicyFirst      = "first"
+	PolicyRoundRobin = "round_robin"
+	PolicyWeighted   = "weighted"
+	PolicyCapacity   = "capacity"
+	PolicyHash       = "hash"
+)
+
+// PlacementPolicy chooses which backends receive a chunk.
+// backends are distinct; Place returns up to n of them in preference order.
+type PlacementPolicy interface {
+	Place(name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage
+}
+
+// ContextPlacementPolicy is implemented by policies that query the backends, so the
+// queries can be cancelled or given a deadline. Use Place, which falls back to the plain
+// method.
+type ContextPlacementPolicy interface {
+	PlaceContext(ctx context.Context, name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage
+}
+
+// Place places through PlaceContext when p supports it
+func Place(ctx context.Context, p PlacementPolicy, name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	if cp, ok := p.(ContextPlacementPolicy); ok {
+		return cp.PlaceContext(ctx, name, size, backends, n)
+	}
+	return p.Place(name, size, backends, n)
+}
+
+// NewPlacementPolicy builds the policy named in config; an empty name selects "first"
+func NewPlacementPolicy(cfg config.PlacementConfig) (PlacementPolicy, error) {
+	switch cfg.Policy {
+	case "", PolicyFirst:
+		return FirstPolicy{}, nil
+	case PolicyRoundRobin:
+		return &RoundRobinPolicy{}, nil
+	case PolicyWeighted:
+		return NewWeightedPolicy(cfg.Weights), nil
+	case PolicyCapacity:
+		return NewCapacityPolicy(defaultCapacityRefresh), nil
+	case PolicyHash:
+		return NewHashPolicy(defaultVirtualNodes), nil
+	}
+	return nil, errorx.WrapWithDetails(errorx.ErrUnknownPlacementPolicy, cfg.Policy)
+}
+
+func firstN(backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	if n > len(backends) {
+		n = len(backends)
+	}
+	return append([]cloud.CloudStorage(nil), backends[:n]...)
+}
+
+// FirstPolicy always prefers backends in configuration order
+type FirstPolicy struct{}
+
+func (FirstPolicy) Place(name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	return firstN(backends, n)
+}
+
+// RoundRobinPolicy rotates the starting backend for every chunk
+type RoundRobinPolicy struct {
+	mu   sync.Mutex
+	next int
+}
+
+func (p *RoundRobinPolicy) Place(name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	if len(backends) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	start := p.next % len(backends)
+	p.next++
+	p.mu.Unlock()
+
+	rotated := append(append([]cloud.CloudStorage(nil), backends[start:]...), backends[:start]...)
+	return firstN(rotated, n)
+}
+
+// WeightedPolicy spreads chunks in proportion to per-backend weights using smooth weighted
+// round-robin. Weights are keyed by storage system ID; unlisted backends weigh 1 and a
+// weight of 0 excludes a backend.
+type WeightedPolicy struct {
+	mu      sync.Mutex
+	weights map[string]int
+	current map[string]int
+}
+
+func NewWeightedPolicy(weights map[string]int) *WeightedPolicy {
+	return &WeightedPolicy{weights: weights, current: make(map[string]int)}
+}
+
+func (p *WeightedPolicy) weight(id string) int {
+	if w, ok := p.weights[id]; ok {
+		return w
+	}
+	return 1
+}
+
+func (p *WeightedPolicy) Place(name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	type candidate struct {
+		svc cloud.CloudStorage
+		id  string
+	}
+	var (
+		candidates []candidate
+		total      int
+	)
+	for _, svc := range backends {
+		id := svc.StorageSystemID()
+		w := p.weight(id)
+		if w <= 0 {
+			continue
+		}
+		total += w
+		p.current[id] += w
+		candidates = append(candidates, candidate{svc, id})
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	// Highest current weight first; the winner pays the total back
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return p.current[candidates[i].id] > p.current[candidates[j].id]
+	})
+	p.current[candidates[0].id] -= total
+
+	result := make([]cloud.CloudStorage, 0, n)
+	for _, c := range candidates {
+		if len(result) == n {
+			break
+		}
+		result = append(result, c.svc)
+	}
+	return result
+}
+
+const defaultCapacityRefresh = 30 * time.Second
+
+// CapacityPolicy prefers the backends with the most remaining space and skips those that
+// cannot hold the chunk. GetRemainingSize is queried at most once per refresh interval;
+// bytes placed in between are subtracted locally.
+type CapacityPolicy struct {
+	mu        sync.Mutex
+	refresh   time.Duration
+	remaining map[string]int64
+	fetchedAt map[string]time.Time
+}
+
+func NewCapacityPolicy(refresh time.Duration) *CapacityPolicy {
+	return &CapacityPolicy{
+		refresh:   refresh,
+		remaining: make(map[string]int64),
+		fetchedAt: make(map[string]time.Time),
+	}
+}
+
+func (p *CapacityPolicy) Place(name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	return p.PlaceContext(context.Background(), name, size, backends, n)
+}
+
+// PlaceContext is Place bounded by ctx. Stale backends are queried before the lock is
+// taken, so concurrent placements never wait on a provider round-trip.
+func (p *CapacityPolicy) PlaceContext(ctx context.Context, name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	now := time.Now()
+	ids := make([]string, len(backends))
+	stale := make([]bool, len(backends))
+	p.mu.Lock()
+	for i, svc := range backends {
+		ids[i] = svc.StorageSystemID()
+		stale[i] = now.Sub(p.fetchedAt[ids[i]]) >= p.refresh
+	}
+	p.mu.Unlock()
+
+	fetched := make(map[string]int64)
+	for i, svc := range backends {
+		if !stale[i] {
+			continue
+		}
+		free, err := cloud.GetRemainingSize(ctx, svc)
+		if err != nil {
+			log.Error("capacity check for %s failed: %v", ids[i], err)
+			continue
+		}
+		fetched[ids[i]] = free
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	type candidate struct {
+		svc  cloud.CloudStorage
+		id   string
+		free int64
+	}
+	var candidates []candidate
+	for i, svc := range backends {
+		id := ids[i]
+		if free, ok := fetched[id]; ok {
+			p.remaining[id] = free
+			p.fetchedAt[id] = now
+		} else if now.Sub(p.fetchedAt[id]) >= p.refresh {
+			// The query failed and no other placement refreshed it meanwhile
+			continue
+		}
+		if p.remaining[id] < size {
+			continue
+		}
+		candidates = append(candidates, candidate{svc, id, p.remaining[id]})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].free > candidates[j].free })
+
+	result := make([]cloud.CloudStorage, 0, n)
+	for _, c := range candidates {
+		if len(result) == n {
+			break
+		}
+		p.remaining[c.id] -= size
+		result = append(result, c.svc)
+	}
+	return result
+}
+
+const defaultVirtualNodes = 64
+
+// HashPolicy places chunks with consistent hashing on the chunk name, so adding or removing
+// a backend only moves a proportional share of chunks. Each backend owns several virtual
+// nodes on the ring to even out the distribution.
+type HashPolicy struct {
+	mu           sync.Mutex
+	virtualNodes int
+	ringKey      string
+	ring         []ringNode
+}
+
+type ringNode struct {
+	hash uint64
+	id   string
+}
+
+func NewHashPolicy(virtualNodes int) *HashPolicy {
+	if virtualNodes < 1 {
+		virtualNodes = 1
+	}
+	return &HashPolicy{virtualNodes: virtualNodes}
+}
+
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// buildRing rebuilds the ring when the backend set changes. Callers must hold the lock.
+func (p *HashPolicy) buildRing(ids []string) {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+	key := ""
+	for _, id := range sorted {
+		key += id + "\x00"
+	}
+	if key == p.ringKey {
+		return
+	}
+	p.ring = p.ring[:0]
+	for _, id := range sorted {
+		for v := 0; v < p.virtualNodes; v++ {
+			p.ring = append(p.ring, ringNode{hash: ringHash(id + "#" + strconv.Itoa(v)), id: id})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	p.ringKey = key
+}
+
+func (p *HashPolicy) Place(name string, size int64, backends []cloud.CloudStorage, n int) []cloud.CloudStorage {
+	if len(backends) == 0 {
+		return nil
+	}
+	byID := make(map[string]cloud.CloudStorage, len(backends))
+	ids := make([]string, 0, len(backends))
+	for _, svc := range backends {
+		id := svc.StorageSystemID()
+		byID[id] = svc
+		ids = append(ids, id)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buildRing(ids)
+
+	h := ringHash(name)
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	result := make([]cloud.CloudStorage, 0, n)
+	seen := make(map[string]bool)
+	// Walk clockwise collecting distinct backends
+	for i := 0; i < len(p.ring) && len(result) < n; i++ {
+		node := p.ring[(start+i)%len(p.ring)]
+		if seen[node.id] {
+			continue
+		}
+		seen[node.id] = true
+		result = append(result, byID[node.id])
+	}
+	return result
+}
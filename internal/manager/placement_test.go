@@ -0,0 +1,200 @@
+package manager_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/manager"
+)
+
+type sizedMock struct {
+	*mockCloudStorage
+	free int64
+}
+
+func (m *sizedMock) GetRemainingSize() (int64, error) { return m.free, nil }
+
+func ids(backends []cloud.CloudStorage) []string {
+	var result []string
+	for _, b := range backends {
+		result = append(result, b.StorageSystemID())
+	}
+	return result
+}
+
+func threeBackends() []cloud.CloudStorage {
+	return []cloud.CloudStorage{newMockCloudStorage("a"), newMockCloudStorage("b"), newMockCloudStorage("c")}
+}
+
+func TestNewPlacementPolicy(t *testing.T) {
+	for _, name := range []string{"", manager.PolicyFirst, manager.PolicyRoundRobin, manager.PolicyWeighted, manager.PolicyCapacity, manager.PolicyHash} {
+		if _, err := manager.NewPlacementPolicy(config.PlacementConfig{Policy: name}); err != nil {
+			t.Errorf("NewPlacementPolicy(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := manager.NewPlacementPolicy(config.PlacementConfig{Policy: "random"}); !errors.Is(err, errorx.ErrUnknownPlacementPolicy) {
+		t.Errorf("expected ErrUnknownPlacementPolicy, got %v", err)
+	}
+}
+
+func TestRoundRobinPolicy(t *testing.T) {
+	backends := threeBackends()
+	p := &manager.RoundRobinPolicy{}
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, ids(p.Place("chunk", 1, backends, 1))...)
+	}
+	want := []string{"a", "b", "c", "a"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("round robin order = %v, want %v", got, want)
+		}
+	}
+	if replicas := ids(p.Place("chunk", 1, backends, 2)); replicas[0] != "b" || replicas[1] != "c" {
+		t.Errorf("expected replicas [b c], got %v", replicas)
+	}
+}
+
+func TestWeightedPolicy(t *testing.T) {
+	backends := threeBackends()
+	p := manager.NewWeightedPolicy(map[string]int{"a": 3, "b": 1, "c": 0})
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		counts[ids(p.Place("chunk", 1, backends, 1))[0]]++
+	}
+	if counts["a"] != 300 || counts["b"] != 100 || counts["c"] != 0 {
+		t.Errorf("expected 300/100/0 split, got %v", counts)
+	}
+	if replicas := p.Place("chunk", 1, backends, 3); len(replicas) != 2 {
+		t.Errorf("zero-weight backend should be excluded, got %v", ids(replicas))
+	}
+}
+
+func TestCapacityPolicy(t *testing.T) {
+	small := &sizedMock{newMockCloudStorage("small"), 100}
+	large := &sizedMock{newMockCloudStorage("large"), 250}
+	backends := []cloud.CloudStorage{small, large}
+	p := manager.NewCapacityPolicy(time.Hour)
+
+	if got := ids(p.Place("c1", 100, backends, 1)); got[0] != "large" {
+		t.Errorf("expected largest backend first, got %v", got)
+	}
+	// large now has 150 left locally, still ahead of small
+	if got := ids(p.Place("c2", 100, backends, 2)); len(got) != 2 || got[0] != "large" || got[1] != "small" {
+		t.Errorf("expected [large small], got %v", got)
+	}
+	// large 50, small 0: nothing fits 100 bytes
+	if got := p.Place("c3", 100, backends, 1); len(got) != 0 {
+		t.Errorf("expected no backend with room, got %v", ids(got))
+	}
+}
+
+// blockingMock answers capacity queries only once release is closed, or ctx ends
+type blockingMock struct {
+	*mockCloudStorage
+	release chan struct{}
+}
+
+func (m *blockingMock) GetRemainingSize() (int64, error) {
+	<-m.release
+	return 1000, nil
+}
+
+func (m *blockingMock) GetRemainingSizeContext(ctx context.Context) (int64, error) {
+	select {
+	case <-m.release:
+		return 1000, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func TestCapacityPolicyQueriesUnlocked(t *testing.T) {
+	slow := &blockingMock{newMockCloudStorage("slow"), make(chan struct{})}
+	fast := &sizedMock{newMockCloudStorage("fast"), 1000}
+	p := manager.NewCapacityPolicy(time.Hour)
+	p.Place("warm", 1, []cloud.CloudStorage{fast}, 1)
+
+	// A placement waiting on a slow provider does not hold up the others
+	done := make(chan []cloud.CloudStorage)
+	go func() { done <- manager.Place(context.Background(), p, "c1", 1, []cloud.CloudStorage{slow, fast}, 2) }()
+	placed := make(chan []cloud.CloudStorage)
+	go func() { placed <- p.Place("c2", 1, []cloud.CloudStorage{fast}, 1) }()
+	select {
+	case got := <-placed:
+		if len(got) != 1 {
+			t.Errorf("placement next to a slow query = %v", ids(got))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("placement waited for another placement's capacity query")
+	}
+	close(slow.release)
+	if got := ids(<-done); len(got) != 2 || got[0] != "slow" {
+		t.Errorf("placement after the slow query = %v", got)
+	}
+
+	// A canceled query skips the backend
+	stuck := &blockingMock{newMockCloudStorage("stuck"), make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := manager.Place(ctx, p, "c3", 1, []cloud.CloudStorage{stuck}, 1); len(got) != 0 {
+		t.Errorf("placement with a canceled context = %v", ids(got))
+	}
+}
+
+func TestHashPolicy(t *testing.T) {
+	backends := threeBackends()
+	p := manager.NewHashPolicy(32)
+	placed := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		name := "chunk-" + strconv.Itoa(i)
+		got := ids(p.Place(name, 1, backends, 2))
+		if len(got) != 2 || got[0] == got[1] {
+			t.Fatalf("expected 2 distinct replicas, got %v", got)
+		}
+		if again := ids(p.Place(name, 1, backends, 2)); again[0] != got[0] {
+			t.Fatalf("placement not stable for %s", name)
+		}
+		placed[name] = got[0]
+		counts[got[0]]++
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if counts[id] == 0 {
+			t.Errorf("backend %s received no chunks: %v", id, counts)
+		}
+	}
+
+	// Adding a backend only moves chunks onto the new backend
+	grown := append(threeBackends(), newMockCloudStorage("d"))
+	for name, before := range placed {
+		after := ids(p.Place(name, 1, grown, 1))[0]
+		if after != before && after != "d" {
+			t.Errorf("chunk %s moved from %s to %s", name, before, after)
+		}
+	}
+}
+
+func TestManager_PlacementPolicy(t *testing.T) {
+	backends := threeBackends()
+	mgr := manager.NewStorageManager(backends)
+	mgr.SetPlacementPolicy(&manager.RoundRobinPolicy{})
+	var got []string
+	for i := 0; i < 3; i++ {
+		storage, err := mgr.UploadChunk("chunk"+strconv.Itoa(i), chunker.Chunk{Data: []byte("x")})
+		if err != nil {
+			t.Fatalf("UploadChunk failed: %v", err)
+		}
+		got = append(got, storage.StorageSystemID())
+	}
+	if got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("expected chunks spread over a, b, c, got %v", got)
+	}
+}
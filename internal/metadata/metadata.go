@@ -2,7 +2,11 @@ package metadata
 
 import (
 	"database/sql"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sayuyere/storageX/internal/config"
@@ -10,17 +14,58 @@ import (
 )
 
 type ChunkMetadata struct {
-	ChunkName string
-	Size      int64
+	ChunkName   string
+	Size        int64
+	Checksum    string
+	Index       int
+	Storage     string   // primary replica
+	Replicas    []string // every storage system holding a copy, primary first
+	FileName    string
+	RefCount    int    // file positions referencing the stored object
+	Codec       string // compression codec of the chunk data; "none" when stored raw
+	StoredSize  int64  // bytes of the object on the backends; 0 for chunks recorded before it was tracked
+	StoredIndex int    // index written in the stored object's header (its first position)
+}
+
+// StripeMetadata describes one erasure-coded stripe: DataCount chunks starting at index
+// Stripe*DataShards, protected by ParityShards parity objects of ShardSize bytes each
+type StripeMetadata struct {
+	FileName     string
+	Stripe       int
+	DataShards   int
+	ParityShards int
+	ShardSize    int64
+	DataCount    int
+	Parity       []ShardMetadata
+}
+
+// ShardMetadata locates one parity shard of a stripe
+type ShardMetadata struct {
+	ShardName string
+	Shard     int // parity position within the stripe, starting at 0
 	Checksum  string
-	Index     int
 	Storage   string
-	FileName  string
 }
 
+// FileMetadata describes a stored file. FileName is its full logical path, with "/"
+// separating directories; Parent is the directory holding it, "" at the root. TotalSize
+// and WrappedKey are those of the current version.
 type FileMetadata struct {
-	FileName  string
-	TotalSize int64
+	FileName   string
+	Parent     string
+	TotalSize  int64
+	WrappedKey []byte    // data key wrapped by the master key; nil for unencrypted files
+	Version    int       // current version, see FileVersion
+	ModifiedAt time.Time // when the current version was uploaded
+}
+
+// ParentDir returns the directory part of a logical path, "" for top-level files
+func ParentDir(fileName string) string {
+	dir := path.Dir(fileName)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
 }
 
 type MetadataService struct {
@@ -64,13 +109,107 @@ func initSchema(db *sql.DB) error {
         file_name TEXT PRIMARY KEY,
         total_size INTEGER
     );
+    CREATE TABLE IF NOT EXISTS chunk_replicas (
+        chunk_name TEXT,
+        storage TEXT,
+        PRIMARY KEY (chunk_name, storage)
+    );
+    CREATE TABLE IF NOT EXISTS stripes (
+        file_name TEXT,
+        stripe INTEGER,
+        data_shards INTEGER,
+        parity_shards INTEGER,
+        shard_size INTEGER,
+        data_count INTEGER,
+        PRIMARY KEY (file_name, stripe)
+    );
+    CREATE TABLE IF NOT EXISTS parity_shards (
+        shard_name TEXT PRIMARY KEY,
+        file_name TEXT,
+        stripe INTEGER,
+        shard INTEGER,
+        checksum TEXT,
+        storage TEXT
+    );
     `)
 	if err != nil {
 		return errorx.Wrap(errorx.ErrMetadataSchemaInitFailed, err)
 	}
+	return migrate(db)
+}
+
+// migrations alter tables created by earlier releases. Entry i upgrades a database from
+// user_version i to i+1; append new entries, never edit existing ones.
+var migrations = []string{
+	`ALTER TABLE files ADD COLUMN wrapped_key BLOB`,
+	// Content-addressed chunks: files reference shared objects through file_chunks
+	`ALTER TABLE chunks ADD COLUMN refcount INTEGER NOT NULL DEFAULT 1;
+    CREATE TABLE file_chunks (
+        file_name TEXT,
+        idx INTEGER,
+        chunk_name TEXT,
+        PRIMARY KEY (file_name, idx)
+    );
+    CREATE INDEX file_chunks_chunk ON file_chunks (chunk_name);
+    INSERT INTO file_chunks (file_name, idx, chunk_name) SELECT file_name, idx, chunk_name FROM chunks;`,
+	`ALTER TABLE chunks ADD COLUMN codec TEXT NOT NULL DEFAULT 'none';
+    ALTER TABLE chunks ADD COLUMN stored_size INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE upload_sessions (
+        file_name TEXT PRIMARY KEY,
+        source_path TEXT NOT NULL,
+        source_size INTEGER NOT NULL,
+        source_mtime INTEGER NOT NULL,
+        options TEXT NOT NULL,
+        created_at INTEGER NOT NULL,
+        expires_at INTEGER NOT NULL
+    );`,
+	// Hierarchical namespace: files are keyed by their full path and indexed by directory
+	`ALTER TABLE files ADD COLUMN parent TEXT NOT NULL DEFAULT '';
+    CREATE INDEX files_parent ON files (parent);`,
+	// Versioning: every upload of a path adds a version; files points at the current one
+	`CREATE TABLE file_versions (
+        file_name TEXT NOT NULL,
+        version INTEGER NOT NULL,
+        storage_name TEXT NOT NULL UNIQUE,
+        total_size INTEGER NOT NULL,
+        wrapped_key BLOB,
+        created_at INTEGER NOT NULL,
+        PRIMARY KEY (file_name, version)
+    );
+    ALTER TABLE files ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+    ALTER TABLE files ADD COLUMN latest_version INTEGER NOT NULL DEFAULT 1;
+    ALTER TABLE upload_sessions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+    INSERT INTO file_versions (file_name, version, storage_name, total_size, wrapped_key, created_at)
+        SELECT file_name, 1, file_name, total_size, wrapped_key, CAST(strftime('%s', 'now') AS INTEGER) FROM files;`,
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return errorx.Wrap(errorx.ErrMetadataSchemaInitFailed, err)
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return errorx.Wrap(errorx.ErrMetadataSchemaInitFailed, err)
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			_ = tx.Rollback()
+			return errorx.Wrap(errorx.ErrMetadataSchemaInitFailed, err)
+		}
+		if _, err := tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(version+1)); err != nil {
+			_ = tx.Rollback()
+			return errorx.Wrap(errorx.ErrMetadataSchemaInitFailed, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return errorx.Wrap(errorx.ErrMetadataSchemaInitFailed, err)
+		}
+	}
 	return nil
 }
 
+// AddChunk records a newly stored object and references it from fileName at meta.Index.
+// Objects are shared by name: use AddChunkRef to reference one that already exists.
 func (m *MetadataService) AddChunk(fileName string, meta ChunkMetadata) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -86,35 +225,130 @@ func (m *MetadataService) AddChunk(fileName string, meta ChunkMetadata) error {
 		return errorx.Wrap(errorx.ErrDBQueryFailed, err)
 	}
 
+	tx, err := m.db.Begin()
+	if err != nil {
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+	}
+	defer tx.Rollback()
+
 	// Insert chunk
-	_, err = m.db.Exec(`INSERT OR REPLACE INTO chunks (chunk_name, file_name, size, checksum, idx, storage) VALUES (?, ?, ?, ?, ?, ?)`,
-		meta.ChunkName, fileName, meta.Size, meta.Checksum, meta.Index, meta.Storage)
+	codec := meta.Codec
+	if codec == "" {
+		codec = "none"
+	}
+	_, err = tx.Exec(`INSERT INTO chunks (chunk_name, file_name, size, checksum, idx, storage, refcount, codec, stored_size) VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?)`,
+		meta.ChunkName, fileName, meta.Size, meta.Checksum, meta.Index, meta.Storage, codec, meta.StoredSize)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+	}
+	_, err = tx.Exec(`INSERT OR REPLACE INTO file_chunks (file_name, idx, chunk_name) VALUES (?, ?, ?)`, fileName, meta.Index, meta.ChunkName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+	}
+
+	// Record every replica location
+	replicas := meta.Replicas
+	if len(replicas) == 0 {
+		replicas = []string{meta.Storage}
+	}
+	for _, storage := range replicas {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO chunk_replicas (chunk_name, storage) VALUES (?, ?)`, meta.ChunkName, storage)
+		if err != nil {
+			return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+		}
+	}
+	if err := addToFile(tx, fileName, meta.Size); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+	}
+	return nil
+}
+
+// AddChunkRef references an already stored object from fileName at index and bumps its refcount
+func (m *MetadataService) AddChunkRef(fileName string, index int, chunkName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	tx, err := m.db.Begin()
 	if err != nil {
 		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
 	}
+	defer tx.Rollback()
 
-	// Ensure file entry exists
-	_, err = m.db.Exec(`INSERT OR IGNORE INTO files (file_name, total_size) VALUES (?, 0)`, fileName)
+	res, err := tx.Exec(`UPDATE chunks SET refcount = refcount + 1 WHERE chunk_name = ?`, chunkName)
 	if err != nil {
-		return errorx.Wrap(errorx.ErrFileInsertFailed, err)
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errorx.WrapWithDetails(errorx.ErrChunkNotFound, chunkName)
+	}
+	_, err = tx.Exec(`INSERT INTO file_chunks (file_name, idx, chunk_name) VALUES (?, ?, ?)`, fileName, index, chunkName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
+	}
+	var size int64
+	if err := tx.QueryRow(`SELECT size FROM chunks WHERE chunk_name = ?`, chunkName).Scan(&size); err != nil {
+		return errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	if err := addToFile(tx, fileName, size); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errorx.Wrap(errorx.ErrChunkInsertFailed, err)
 	}
+	return nil
+}
 
-	// Update total size
-	_, err = m.db.Exec(`UPDATE files SET total_size = total_size + ? WHERE file_name = ?`, meta.Size, fileName)
+// addToFile ensures the file entry exists and adds a chunk's size to its total. Chunks
+// recorded under the storage name of a version are skipped: the version carries its size.
+func addToFile(tx *sql.Tx, fileName string, size int64) error {
+	var versioned int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM file_versions WHERE storage_name = ?`, fileName).Scan(&versioned)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	if versioned > 0 {
+		return nil
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO files (file_name, total_size) VALUES (?, 0)`, fileName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	_, err = tx.Exec(`UPDATE files SET total_size = total_size + ? WHERE file_name = ?`, size, fileName)
 	if err != nil {
 		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
 	}
 	return nil
 }
 
+// AddFile records fileName as a file with a single version stored under its own name.
+// Existing files are left unchanged; BeginUpload adds versions.
 func (m *MetadataService) AddFile(fileName string, fileSize uint64) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	_, err := m.db.Exec(`INSERT OR IGNORE INTO files (file_name, total_size) VALUES (?, ?)`, fileName, fileSize)
+	tx, err := m.db.Begin()
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO files (file_name, parent, total_size, version) VALUES (?, ?, ?, 1)`, fileName, ParentDir(fileName), fileSize)
 	if err != nil {
 		return errorx.Wrap(errorx.ErrFileInsertFailed, err)
 	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		_, err = tx.Exec(`INSERT OR IGNORE INTO file_versions (file_name, version, storage_name, total_size, created_at) VALUES (?, 1, ?, ?, ?)`,
+			fileName, fileName, fileSize, time.Now().Unix())
+		if err != nil {
+			return errorx.Wrap(errorx.ErrFileInsertFailed, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
 	return nil
 }
 
@@ -122,11 +356,17 @@ func (m *MetadataService) GetChunk(chunkName string) (ChunkMetadata, bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	row := m.db.QueryRow(`SELECT chunk_name, file_name, size, checksum, idx, storage FROM chunks WHERE chunk_name = ?`, chunkName)
+	row := m.db.QueryRow(`SELECT chunk_name, file_name, size, checksum, idx, storage, refcount, codec, stored_size FROM chunks WHERE chunk_name = ?`, chunkName)
 	var meta ChunkMetadata
-	if err := row.Scan(&meta.ChunkName, &meta.FileName, &meta.Size, &meta.Checksum, &meta.Index, &meta.Storage); err != nil {
+	if err := row.Scan(&meta.ChunkName, &meta.FileName, &meta.Size, &meta.Checksum, &meta.Index, &meta.Storage, &meta.RefCount, &meta.Codec, &meta.StoredSize); err != nil {
+		return ChunkMetadata{}, false
+	}
+	meta.StoredIndex = meta.Index
+	replicas, err := loadReplicas(m.db, `chunk_name = ?`, chunkName)
+	if err != nil {
 		return ChunkMetadata{}, false
 	}
+	meta.Replicas = replicasOrPrimary(replicas[meta.ChunkName], meta.Storage)
 	return meta, true
 }
 
@@ -134,19 +374,39 @@ func (m *MetadataService) GetFile(fileName string) (FileMetadata, bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	row := m.db.QueryRow(`SELECT file_name, total_size FROM files WHERE file_name = ?`, fileName)
-	var meta FileMetadata
-	if err := row.Scan(&meta.FileName, &meta.TotalSize); err != nil {
+	meta, err := scanFile(m.db.QueryRow(`SELECT `+fileColumns+` FROM files WHERE file_name = ?`, fileName))
+	if err != nil {
 		return FileMetadata{}, false
 	}
 	return meta, true
 }
 
+// fileColumns selects a files row with the creation time of its current version
+const fileColumns = `file_name, parent, total_size, wrapped_key, version,
+	COALESCE((SELECT created_at FROM file_versions v WHERE v.file_name = files.file_name AND v.version = files.version), 0)`
+
+func scanFile(row scanner) (FileMetadata, error) {
+	var (
+		meta     FileMetadata
+		modified int64
+	)
+	if err := row.Scan(&meta.FileName, &meta.Parent, &meta.TotalSize, &meta.WrappedKey, &meta.Version, &modified); err != nil {
+		return FileMetadata{}, err
+	}
+	if modified > 0 {
+		meta.ModifiedAt = time.Unix(modified, 0)
+	}
+	return meta, nil
+}
+
+// ListChunks returns the chunks making up a file in order. Index is the position within
+// the file; a shared object appears once per position referencing it.
 func (m *MetadataService) ListChunks(fileName string) ([]ChunkMetadata, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	rows, err := m.db.Query(`SELECT chunk_name, file_name, size, checksum, idx, storage FROM chunks WHERE file_name = ? ORDER BY idx`, fileName)
+	rows, err := m.db.Query(`SELECT c.chunk_name, fc.file_name, c.size, c.checksum, fc.idx, c.storage, c.refcount, c.codec, c.stored_size, c.idx
+		FROM file_chunks fc JOIN chunks c ON c.chunk_name = fc.chunk_name WHERE fc.file_name = ? ORDER BY fc.idx`, fileName)
 	if err != nil {
 		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
 	}
@@ -155,19 +415,129 @@ func (m *MetadataService) ListChunks(fileName string) ([]ChunkMetadata, error) {
 	var result []ChunkMetadata
 	for rows.Next() {
 		var meta ChunkMetadata
-		if err := rows.Scan(&meta.ChunkName, &meta.FileName, &meta.Size, &meta.Checksum, &meta.Index, &meta.Storage); err != nil {
+		if err := rows.Scan(&meta.ChunkName, &meta.FileName, &meta.Size, &meta.Checksum, &meta.Index, &meta.Storage, &meta.RefCount, &meta.Codec, &meta.StoredSize, &meta.StoredIndex); err != nil {
 			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
 		}
 		result = append(result, meta)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+
+	replicas, err := loadReplicas(m.db, `chunk_name IN (SELECT chunk_name FROM file_chunks WHERE file_name = ?)`, fileName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		result[i].Replicas = replicasOrPrimary(replicas[result[i].ChunkName], result[i].Storage)
+	}
 	return result, nil
 }
 
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// loadReplicas returns replica locations keyed by chunk name, in insertion order.
+// Callers must hold the lock.
+func loadReplicas(q querier, where string, args ...interface{}) (map[string][]string, error) {
+	rows, err := q.Query(`SELECT chunk_name, storage FROM chunk_replicas WHERE `+where+` ORDER BY rowid`, args...)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer rows.Close()
+
+	result := make(map[string][]string)
+	for rows.Next() {
+		var chunkName, storage string
+		if err := rows.Scan(&chunkName, &storage); err != nil {
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		result[chunkName] = append(result[chunkName], storage)
+	}
+	return result, rows.Err()
+}
+
+// replicasOrPrimary falls back to the primary location for chunks recorded before replication
+func replicasOrPrimary(replicas []string, primary string) []string {
+	if len(replicas) == 0 {
+		return []string{primary}
+	}
+	return replicas
+}
+
 func (m *MetadataService) ListFiles() ([]FileMetadata, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	rows, err := m.db.Query(`SELECT file_name, total_size FROM files`)
+	return m.queryFiles(`SELECT ` + fileColumns + ` FROM files`)
+}
+
+// ListDir returns the files directly inside dir ("" for the root) and the names of its
+// subdirectories, both sorted. Directories exist only as the parents of stored files.
+func (m *MetadataService) ListDir(dir string) ([]FileMetadata, []string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	files, err := m.queryFiles(`SELECT `+fileColumns+` FROM files WHERE parent = ? ORDER BY file_name`, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	lo, hi := treeRange(dir)
+	rows, err := m.db.Query(`SELECT DISTINCT parent FROM files WHERE parent >= ? AND parent < ? ORDER BY parent`, lo, hi)
+	if err != nil {
+		return nil, nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer rows.Close()
+	var subdirs []string
+	for rows.Next() {
+		var parent string
+		if err := rows.Scan(&parent); err != nil {
+			return nil, nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(parent, lo), "/")
+		if name != "" && (len(subdirs) == 0 || subdirs[len(subdirs)-1] != name) {
+			subdirs = append(subdirs, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+	return files, subdirs, nil
+}
+
+// ListTree returns every file below dir, at any depth, sorted by path. An empty dir
+// lists all files.
+func (m *MetadataService) ListTree(dir string) ([]FileMetadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	lo, hi := treeRange(dir)
+	return m.queryFiles(`SELECT `+fileColumns+` FROM files WHERE file_name >= ? AND file_name < ? ORDER BY file_name`, lo, hi)
+}
+
+// ListPage returns up to limit files whose path starts with prefix and sorts after after,
+// in path order. Passing the last name of a page as after returns the next one.
+func (m *MetadataService) ListPage(prefix, after string, limit int) ([]FileMetadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.queryFiles(`SELECT `+fileColumns+` FROM files WHERE file_name >= ? AND file_name < ? AND file_name > ? ORDER BY file_name LIMIT ?`,
+		prefix, prefix+"\xff", after, limit)
+}
+
+// treeRange bounds the paths below dir: they all start with dir + "/", and "0" is the
+// byte after "/"
+func treeRange(dir string) (string, string) {
+	if dir == "" {
+		return "", "\xff"
+	}
+	return dir + "/", dir + "0"
+}
+
+func (m *MetadataService) queryFiles(query string, args ...interface{}) ([]FileMetadata, error) {
+	rows, err := m.db.Query(query, args...)
 	if err != nil {
 		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
 	}
@@ -175,41 +545,286 @@ func (m *MetadataService) ListFiles() ([]FileMetadata, error) {
 
 	var result []FileMetadata
 	for rows.Next() {
-		var meta FileMetadata
-		if err := rows.Scan(&meta.FileName, &meta.TotalSize); err != nil {
+		meta, err := scanFile(rows)
+		if err != nil {
 			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
 		}
 		result = append(result, meta)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
 	return result, nil
 }
 
+// AddStripe records the layout and parity shard locations of an erasure-coded stripe
+func (m *MetadataService) AddStripe(fileName string, stripe StripeMetadata) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, err := m.db.Exec(`INSERT OR REPLACE INTO stripes (file_name, stripe, data_shards, parity_shards, shard_size, data_count) VALUES (?, ?, ?, ?, ?, ?)`,
+		fileName, stripe.Stripe, stripe.DataShards, stripe.ParityShards, stripe.ShardSize, stripe.DataCount)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrStripeInsertFailed, err)
+	}
+	for _, shard := range stripe.Parity {
+		_, err = m.db.Exec(`INSERT OR REPLACE INTO parity_shards (shard_name, file_name, stripe, shard, checksum, storage) VALUES (?, ?, ?, ?, ?, ?)`,
+			shard.ShardName, fileName, stripe.Stripe, shard.Shard, shard.Checksum, shard.Storage)
+		if err != nil {
+			return errorx.Wrap(errorx.ErrStripeInsertFailed, err)
+		}
+	}
+	return nil
+}
+
+// ListStripes returns the stripes of an erasure-coded file ordered by stripe; replicated files have none
+func (m *MetadataService) ListStripes(fileName string) ([]StripeMetadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	rows, err := m.db.Query(`SELECT file_name, stripe, data_shards, parity_shards, shard_size, data_count FROM stripes WHERE file_name = ? ORDER BY stripe`, fileName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer rows.Close()
+
+	var result []StripeMetadata
+	byStripe := make(map[int]int)
+	for rows.Next() {
+		var st StripeMetadata
+		if err := rows.Scan(&st.FileName, &st.Stripe, &st.DataShards, &st.ParityShards, &st.ShardSize, &st.DataCount); err != nil {
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		byStripe[st.Stripe] = len(result)
+		result = append(result, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	shardRows, err := m.db.Query(`SELECT shard_name, stripe, shard, checksum, storage FROM parity_shards WHERE file_name = ? ORDER BY stripe, shard`, fileName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer shardRows.Close()
+	for shardRows.Next() {
+		var (
+			shard  ShardMetadata
+			stripe int
+		)
+		if err := shardRows.Scan(&shard.ShardName, &stripe, &shard.Shard, &shard.Checksum, &shard.Storage); err != nil {
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		if i, ok := byStripe[stripe]; ok {
+			result[i].Parity = append(result[i].Parity, shard)
+		}
+	}
+	return result, shardRows.Err()
+}
+
+// DeleteFile removes a file's metadata, dropping its references to shared objects
 func (m *MetadataService) DeleteFile(fileName string) error {
+	_, err := m.ReleaseFile(fileName)
+	return err
+}
+
+// ReleaseFile removes a file's metadata, every version and any unfinished upload session
+// included, and decrements the refcount of every object it references. Objects no longer
+// referenced by any file are removed as well and returned, with their replicas, together
+// with the parity shards of its stripes, so the caller can delete them from the backends.
+func (m *MetadataService) ReleaseFile(fileName string) ([]ChunkMetadata, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	_, err := m.db.Exec(`DELETE FROM chunks WHERE file_name = ?`, fileName)
+	tx, err := m.db.Begin()
 	if err != nil {
-		return errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+		return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
 	}
-	_, err = m.db.Exec(`DELETE FROM files WHERE file_name = ?`, fileName)
+	defer tx.Rollback()
+
+	// Chunks recorded by AddChunk alone sit under the file name without a version
+	names, err := queryStrings(tx, `SELECT storage_name FROM file_versions WHERE file_name = ?
+		UNION SELECT ? WHERE NOT EXISTS (SELECT 1 FROM file_versions WHERE storage_name = ?)`, fileName, fileName, fileName)
 	if err != nil {
-		return errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+		return nil, err
 	}
-	return nil
+	var released []ChunkMetadata
+	for _, name := range names {
+		parity, err := releaseRefs(tx, name)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, parity...)
+	}
+	unreferenced, err := releaseUnreferenced(tx)
+	if err != nil {
+		return nil, err
+	}
+	released = append(released, unreferenced...)
+
+	for _, table := range []string{"files", "file_versions", "upload_sessions"} {
+		_, err = tx.Exec(`DELETE FROM `+table+` WHERE file_name = ?`, fileName)
+		if err != nil {
+			return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+	}
+	return released, nil
 }
 
+// releaseRefs drops the stripes and chunk references recorded under storageName and
+// returns its parity shards
+func releaseRefs(tx *sql.Tx, storageName string) ([]ChunkMetadata, error) {
+	rows, err := tx.Query(`SELECT shard_name, checksum, storage FROM parity_shards WHERE file_name = ?`, storageName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	var parity []ChunkMetadata
+	for rows.Next() {
+		meta := ChunkMetadata{FileName: storageName}
+		if err := rows.Scan(&meta.ChunkName, &meta.Checksum, &meta.Storage); err != nil {
+			rows.Close()
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		meta.Replicas = []string{meta.Storage}
+		parity = append(parity, meta)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+
+	_, err = tx.Exec(`DELETE FROM parity_shards WHERE file_name = ?`, storageName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	_, err = tx.Exec(`DELETE FROM stripes WHERE file_name = ?`, storageName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+
+	// One reference per position, so a chunk repeated within the file is released as often
+	_, err = tx.Exec(`UPDATE chunks SET refcount = refcount -
+		(SELECT COUNT(*) FROM file_chunks fc WHERE fc.file_name = ? AND fc.chunk_name = chunks.chunk_name)
+		WHERE chunk_name IN (SELECT chunk_name FROM file_chunks WHERE file_name = ?)`, storageName, storageName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	_, err = tx.Exec(`DELETE FROM file_chunks WHERE file_name = ?`, storageName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	return parity, nil
+}
+
+// releaseUnreferenced removes the objects whose refcount dropped to zero and returns them
+// with their replicas
+func releaseUnreferenced(tx *sql.Tx) ([]ChunkMetadata, error) {
+	rows, err := tx.Query(`SELECT chunk_name, file_name, size, checksum, idx, storage, refcount, codec, stored_size FROM chunks WHERE refcount <= 0`)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	var released []ChunkMetadata
+	for rows.Next() {
+		var meta ChunkMetadata
+		if err := rows.Scan(&meta.ChunkName, &meta.FileName, &meta.Size, &meta.Checksum, &meta.Index, &meta.Storage, &meta.RefCount, &meta.Codec, &meta.StoredSize); err != nil {
+			rows.Close()
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		meta.StoredIndex = meta.Index
+		released = append(released, meta)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+	if len(released) > 0 {
+		replicas, err := loadReplicas(tx, `chunk_name IN (SELECT chunk_name FROM chunks WHERE refcount <= 0)`)
+		if err != nil {
+			return nil, err
+		}
+		for i := range released {
+			released[i].Replicas = replicasOrPrimary(replicas[released[i].ChunkName], released[i].Storage)
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM chunk_replicas WHERE chunk_name IN (SELECT chunk_name FROM chunks WHERE refcount <= 0)`)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	_, err = tx.Exec(`DELETE FROM chunks WHERE refcount <= 0`)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	return released, nil
+}
+
+// queryStrings returns the single text column selected by query
+func queryStrings(q querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		result = append(result, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+	return result, nil
+}
+
+// DeleteChunk removes an object and every file reference to it
 func (m *MetadataService) DeleteChunk(chunkName string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	_, err := m.db.Exec(`DELETE FROM chunks WHERE chunk_name = ?`, chunkName)
+	_, err := m.db.Exec(`DELETE FROM chunk_replicas WHERE chunk_name = ?`, chunkName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	_, err = m.db.Exec(`DELETE FROM file_chunks WHERE chunk_name = ?`, chunkName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
+	}
+	_, err = m.db.Exec(`DELETE FROM chunks WHERE chunk_name = ?`, chunkName)
 	if err != nil {
 		return errorx.Wrap(errorx.ErrChunkDeleteFailed, err)
 	}
 	return nil
 }
 
+// ReferencedObjects returns the names of the objects metadata expects on the storage system
+// storageID: chunk replicas, chunks recorded before replication and parity shards.
+// Anything else found there is an orphan.
+func (m *MetadataService) ReferencedObjects(storageID string) (map[string]bool, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	names, err := queryStrings(m.db, `SELECT chunk_name FROM chunk_replicas WHERE storage = ?
+		UNION SELECT chunk_name FROM chunks c WHERE storage = ? AND NOT EXISTS (SELECT 1 FROM chunk_replicas r WHERE r.chunk_name = c.chunk_name)
+		UNION SELECT shard_name FROM parity_shards WHERE storage = ?`, storageID, storageID, storageID)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]bool, len(names))
+	for _, name := range names {
+		result[name] = true
+	}
+	return result, nil
+}
+
 func (m *MetadataService) ChunkExists(chunkName string) (bool, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
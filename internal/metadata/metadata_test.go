@@ -1,10 +1,16 @@
 package metadata_test
 
 import (
+	"database/sql"
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
+	errorx "github.com/sayuyere/storageX/internal/errors"
 	"github.com/sayuyere/storageX/internal/metadata"
 )
 
@@ -42,6 +48,9 @@ func TestAddAndGetFile(t *testing.T) {
 	if meta.FileName != fileName {
 		t.Errorf("expected %q, got %q", fileName, meta.FileName)
 	}
+	if meta.ModifiedAt.IsZero() || time.Since(meta.ModifiedAt) > time.Minute {
+		t.Errorf("unexpected modification time %v", meta.ModifiedAt)
+	}
 }
 
 func TestAddChunkAndExists(t *testing.T) {
@@ -154,3 +163,399 @@ func TestDeleteChunkAndFile(t *testing.T) {
 		t.Errorf("File should not exist after delete")
 	}
 }
+
+func TestChunkReplicas(t *testing.T) {
+	metaSvc := setupTestDB(t)
+	fileName := "replicated.txt"
+	_ = metaSvc.AddFile(fileName, 0)
+	chunk := metadata.ChunkMetadata{
+		ChunkName: "chunk1",
+		Size:      10,
+		Checksum:  "abc",
+		Index:     0,
+		FileName:  fileName,
+		Storage:   "s1",
+		Replicas:  []string{"s1", "s2"},
+	}
+	if err := metaSvc.AddChunk(fileName, chunk); err != nil {
+		t.Fatalf("AddChunk failed: %v", err)
+	}
+	legacy := chunk
+	legacy.ChunkName, legacy.Index, legacy.Replicas = "chunk2", 1, nil
+	if err := metaSvc.AddChunk(fileName, legacy); err != nil {
+		t.Fatalf("AddChunk failed: %v", err)
+	}
+
+	chunks, err := metaSvc.ListChunks(fileName)
+	if err != nil || len(chunks) != 2 {
+		t.Fatalf("ListChunks failed: %v, got %d", err, len(chunks))
+	}
+	if !reflect.DeepEqual(chunks[0].Replicas, []string{"s1", "s2"}) {
+		t.Errorf("expected replicas [s1 s2], got %v", chunks[0].Replicas)
+	}
+	if !reflect.DeepEqual(chunks[1].Replicas, []string{"s1"}) {
+		t.Errorf("expected primary-only replicas, got %v", chunks[1].Replicas)
+	}
+	meta, ok := metaSvc.GetChunk("chunk1")
+	if !ok || !reflect.DeepEqual(meta.Replicas, []string{"s1", "s2"}) {
+		t.Errorf("GetChunk replicas mismatch: %+v", meta)
+	}
+
+	if err := metaSvc.DeleteFile(fileName); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	_ = metaSvc.AddChunk(fileName, legacy)
+	if meta, _ := metaSvc.GetChunk("chunk2"); len(meta.Replicas) != 1 {
+		t.Errorf("stale replicas survived DeleteFile: %v", meta.Replicas)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	// files table as created before per-file keys existed
+	if _, err := db.Exec(`CREATE TABLE files (file_name TEXT PRIMARY KEY, total_size INTEGER);
+		INSERT INTO files (file_name, total_size) VALUES ('old.txt', 42);`); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	db.Close()
+
+	metaSvc, err := metadata.NewMetadataService(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open legacy database: %v", err)
+	}
+	meta, ok := metaSvc.GetFile("old.txt")
+	if !ok || meta.TotalSize != 42 || meta.WrappedKey != nil {
+		t.Fatalf("legacy file not readable after migration: %+v", meta)
+	}
+	if ver, ok := metaSvc.GetVersion("old.txt", 0); !ok || ver.Version != 1 || ver.StorageName != "old.txt" || ver.WrappedKey != nil {
+		t.Errorf("legacy file has no first version: %+v", ver)
+	}
+
+	// Reopening must not re-run applied migrations
+	if _, err := metadata.NewMetadataService(dbPath); err != nil {
+		t.Fatalf("reopening migrated database failed: %v", err)
+	}
+}
+
+func TestChunkRefCounting(t *testing.T) {
+	metaSvc := setupTestDB(t)
+	shared := metadata.ChunkMetadata{ChunkName: "shared", Size: 10, Checksum: "s", Storage: "s1", Replicas: []string{"s1", "s2"}}
+	own := metadata.ChunkMetadata{ChunkName: "own", Size: 5, Checksum: "o", Index: 1, Storage: "s1"}
+
+	_ = metaSvc.AddFile("a", 0)
+	if err := metaSvc.AddChunk("a", shared); err != nil {
+		t.Fatalf("AddChunk failed: %v", err)
+	}
+	if err := metaSvc.AddChunk("a", own); err != nil {
+		t.Fatalf("AddChunk failed: %v", err)
+	}
+	// "a" repeats the shared chunk at position 2, "b" consists of it twice
+	if err := metaSvc.AddChunkRef("a", 2, "shared"); err != nil {
+		t.Fatalf("AddChunkRef failed: %v", err)
+	}
+	_ = metaSvc.AddFile("b", 0)
+	for i := 0; i < 2; i++ {
+		if err := metaSvc.AddChunkRef("b", i, "shared"); err != nil {
+			t.Fatalf("AddChunkRef failed: %v", err)
+		}
+	}
+	if err := metaSvc.AddChunkRef("b", 5, "missing"); !errors.Is(err, errorx.ErrChunkNotFound) {
+		t.Errorf("expected ErrChunkNotFound, got %v", err)
+	}
+
+	if meta, _ := metaSvc.GetChunk("shared"); meta.RefCount != 4 {
+		t.Errorf("expected refcount 4, got %d", meta.RefCount)
+	}
+	chunks, err := metaSvc.ListChunks("a")
+	if err != nil || len(chunks) != 3 {
+		t.Fatalf("ListChunks failed: %v, got %d", err, len(chunks))
+	}
+	if chunks[2].ChunkName != "shared" || chunks[2].Index != 2 || !reflect.DeepEqual(chunks[2].Replicas, []string{"s1", "s2"}) {
+		t.Errorf("unexpected repeated chunk: %+v", chunks[2])
+	}
+
+	released, err := metaSvc.ReleaseFile("a")
+	if err != nil {
+		t.Fatalf("ReleaseFile failed: %v", err)
+	}
+	if len(released) != 1 || released[0].ChunkName != "own" {
+		t.Errorf("expected only the unshared chunk to be released, got %+v", released)
+	}
+	if meta, ok := metaSvc.GetChunk("shared"); !ok || meta.RefCount != 2 {
+		t.Errorf("expected shared chunk to keep refcount 2, got %+v", meta)
+	}
+
+	released, err = metaSvc.ReleaseFile("b")
+	if err != nil {
+		t.Fatalf("ReleaseFile failed: %v", err)
+	}
+	if len(released) != 1 || released[0].ChunkName != "shared" || !reflect.DeepEqual(released[0].Replicas, []string{"s1", "s2"}) {
+		t.Errorf("expected shared chunk with its replicas to be released, got %+v", released)
+	}
+	if ok, _ := metaSvc.ChunkExists("shared"); ok {
+		t.Error("released chunk still exists")
+	}
+}
+
+func TestMigrateLegacyChunks(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open db: %v", err)
+	}
+	// chunks table as created before content addressing
+	if _, err := db.Exec(`CREATE TABLE chunks (chunk_name TEXT PRIMARY KEY, file_name TEXT, size INTEGER, checksum TEXT, idx INTEGER, storage TEXT);
+		INSERT INTO chunks VALUES ('old.txt-chunk-0', 'old.txt', 3, 'x', 0, 's1'), ('old.txt-chunk-1', 'old.txt', 3, 'y', 1, 's1');`); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	db.Close()
+
+	metaSvc, err := metadata.NewMetadataService(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open legacy database: %v", err)
+	}
+	chunks, err := metaSvc.ListChunks("old.txt")
+	if err != nil || len(chunks) != 2 || chunks[1].ChunkName != "old.txt-chunk-1" || chunks[1].RefCount != 1 {
+		t.Fatalf("legacy chunks not migrated: %v, %+v", err, chunks)
+	}
+	released, err := metaSvc.ReleaseFile("old.txt")
+	if err != nil || len(released) != 2 {
+		t.Errorf("expected both legacy chunks released, got %v, %+v", err, released)
+	}
+}
+
+func TestUploadSessions(t *testing.T) {
+	metaSvc := setupTestDB(t)
+	now := time.Unix(1700000000, 0)
+	sess := metadata.UploadSession{
+		FileName:    "big.iso",
+		Version:     1,
+		SourcePath:  "/data/big.iso",
+		SourceSize:  1 << 30,
+		SourceMTime: 42,
+		Options:     `{"Mode":"replicate"}`,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+	if _, err := metaSvc.BeginUpload(sess, sess.SourceSize, nil); err != nil {
+		t.Fatalf("BeginUpload failed: %v", err)
+	}
+	if _, err := metaSvc.BeginUpload(sess, sess.SourceSize, nil); !errors.Is(err, errorx.ErrUploadSessionExists) {
+		t.Errorf("expected ErrUploadSessionExists, got %v", err)
+	}
+	got, ok := metaSvc.GetUploadSession("big.iso")
+	if !ok || !reflect.DeepEqual(got, sess) {
+		t.Fatalf("GetUploadSession = %+v, %v; want %+v", got, ok, sess)
+	}
+	if got.Expired(now) || !got.Expired(now.Add(time.Hour)) {
+		t.Error("Expired does not honour ExpiresAt")
+	}
+	if list, err := metaSvc.ListUploadSessions(); err != nil || len(list) != 1 {
+		t.Errorf("ListUploadSessions = %+v, %v", list, err)
+	}
+
+	// Releasing the file ends its session
+	if err := metaSvc.DeleteFile("big.iso"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := metaSvc.GetUploadSession("big.iso"); ok {
+		t.Error("session left after DeleteFile")
+	}
+
+	_, _ = metaSvc.BeginUpload(sess, sess.SourceSize, nil)
+	if err := metaSvc.EndUploadSession("big.iso"); err != nil {
+		t.Fatalf("EndUploadSession failed: %v", err)
+	}
+	if list, _ := metaSvc.ListUploadSessions(); len(list) != 0 {
+		t.Errorf("sessions left after EndUploadSession: %+v", list)
+	}
+}
+
+func TestListDirAndTree(t *testing.T) {
+	metaSvc := setupTestDB(t)
+	for _, name := range []string{"top.txt", "docs/a.md", "docs/b.md", "docs/old/c.md", "docs2/d.md", "src/main.go"} {
+		if err := metaSvc.AddFile(name, 1); err != nil {
+			t.Fatalf("AddFile(%s) failed: %v", name, err)
+		}
+	}
+
+	files, subdirs, err := metaSvc.ListDir("")
+	if err != nil || len(files) != 1 || files[0].FileName != "top.txt" || files[0].Parent != "" {
+		t.Errorf("ListDir(root) files = %v, %v", files, err)
+	}
+	if !reflect.DeepEqual(subdirs, []string{"docs", "docs2", "src"}) {
+		t.Errorf("ListDir(root) subdirs = %v", subdirs)
+	}
+	files, subdirs, err = metaSvc.ListDir("docs")
+	if err != nil || len(files) != 2 || files[1].Parent != "docs" || !reflect.DeepEqual(subdirs, []string{"old"}) {
+		t.Errorf("ListDir(docs) = %v, %v, %v", files, subdirs, err)
+	}
+
+	tree, err := metaSvc.ListTree("docs")
+	if err != nil {
+		t.Fatalf("ListTree failed: %v", err)
+	}
+	var names []string
+	for _, f := range tree {
+		names = append(names, f.FileName)
+	}
+	if !reflect.DeepEqual(names, []string{"docs/a.md", "docs/b.md", "docs/old/c.md"}) {
+		t.Errorf("ListTree(docs) = %v", names)
+	}
+	if all, _ := metaSvc.ListTree(""); len(all) != 6 {
+		t.Errorf("ListTree(root) returned %d files, want 6", len(all))
+	}
+
+	// Pages of two under "docs", which also matches "docs2"
+	names = nil
+	for after := ""; ; {
+		page, err := metaSvc.ListPage("docs", after, 2)
+		if err != nil {
+			t.Fatalf("ListPage failed: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, f := range page {
+			names = append(names, f.FileName)
+		}
+		after = page[len(page)-1].FileName
+	}
+	if !reflect.DeepEqual(names, []string{"docs/a.md", "docs/b.md", "docs/old/c.md", "docs2/d.md"}) {
+		t.Errorf("ListPage(docs) = %v", names)
+	}
+}
+
+func TestFileVersions(t *testing.T) {
+	metaSvc := setupTestDB(t)
+	now := time.Unix(1700000000, 0)
+	sess := metadata.UploadSession{FileName: "doc.txt", SourcePath: "/doc.txt", Options: "{}", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	v1, err := metaSvc.BeginUpload(sess, 10, nil)
+	if err != nil || v1.Version != 1 || v1.StorageName != "doc.txt" {
+		t.Fatalf("BeginUpload = %+v, %v", v1, err)
+	}
+	if _, err := metaSvc.BeginUpload(sess, 10, nil); !errors.Is(err, errorx.ErrUploadSessionExists) {
+		t.Errorf("expected ErrUploadSessionExists, got %v", err)
+	}
+	if list, _ := metaSvc.ListVersions("doc.txt"); len(list) != 0 {
+		t.Errorf("unfinished version listed: %+v", list)
+	}
+	if err := metaSvc.EndUploadSession("doc.txt"); err != nil {
+		t.Fatalf("EndUploadSession failed: %v", err)
+	}
+
+	v2, err := metaSvc.BeginUpload(sess, 20, []byte{7})
+	if err != nil || v2.Version != 2 || v2.StorageName != "doc.txt;v2" || v2.Current {
+		t.Fatalf("BeginUpload = %+v, %v", v2, err)
+	}
+	if err := metaSvc.AddChunk(v2.StorageName, metadata.ChunkMetadata{ChunkName: "c1", Size: 20, Storage: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Reads keep the previous version until the upload ends
+	if file, _ := metaSvc.GetFile("doc.txt"); file.Version != 1 || file.TotalSize != 10 {
+		t.Errorf("file changed before the upload ended: %+v", file)
+	}
+	if err := metaSvc.EndUploadSession("doc.txt"); err != nil {
+		t.Fatalf("EndUploadSession failed: %v", err)
+	}
+	if file, _ := metaSvc.GetFile("doc.txt"); file.Version != 2 || file.TotalSize != 20 || !reflect.DeepEqual(file.WrappedKey, []byte{7}) {
+		t.Errorf("file not at version 2: %+v", file)
+	}
+	if files, _ := metaSvc.ListFiles(); len(files) != 1 {
+		t.Errorf("versions leaked into the file list: %+v", files)
+	}
+
+	list, err := metaSvc.ListVersions("doc.txt")
+	if err != nil || len(list) != 2 || list[0].Version != 2 || !list[0].Current || list[1].Current {
+		t.Fatalf("ListVersions = %+v, %v", list, err)
+	}
+	if _, err := metaSvc.ReleaseVersion("doc.txt", 2); !errors.Is(err, errorx.ErrVersionIsCurrent) {
+		t.Errorf("expected ErrVersionIsCurrent, got %v", err)
+	}
+	if err := metaSvc.SetCurrentVersion("doc.txt", 1); err != nil {
+		t.Fatalf("SetCurrentVersion failed: %v", err)
+	}
+	if err := metaSvc.SetCurrentVersion("doc.txt", 9); !errors.Is(err, errorx.ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+	released, err := metaSvc.ReleaseVersion("doc.txt", 2)
+	if err != nil || len(released) != 1 || released[0].ChunkName != "c1" {
+		t.Fatalf("ReleaseVersion = %+v, %v", released, err)
+	}
+	if _, ok := metaSvc.GetVersion("doc.txt", 2); ok {
+		t.Error("released version still recorded")
+	}
+	// Numbers are not reused while the file exists
+	if v3, err := metaSvc.BeginUpload(sess, 30, nil); err != nil || v3.Version != 3 {
+		t.Errorf("BeginUpload after release = %+v, %v", v3, err)
+	}
+}
+
+func TestRenameFileAndDir(t *testing.T) {
+	metaSvc := setupTestDB(t)
+	for _, name := range []string{"docs/a.md", "docs/old/b.md", "top.txt"} {
+		if err := metaSvc.AddFile(name, 1); err != nil {
+			t.Fatalf("AddFile(%s) failed: %v", name, err)
+		}
+	}
+	// A file recorded by AddChunk alone keeps finding its chunks under the old name
+	if err := metaSvc.AddChunk("legacy.bin", metadata.ChunkMetadata{ChunkName: "c1", Size: 4, Storage: "s1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := metaSvc.RenameFile("legacy.bin", "archive/legacy.bin"); err != nil {
+		t.Fatalf("RenameFile failed: %v", err)
+	}
+	ver, ok := metaSvc.GetVersion("archive/legacy.bin", 0)
+	if !ok || ver.StorageName != "legacy.bin" {
+		t.Fatalf("renamed version = %+v, %v", ver, ok)
+	}
+	if chunks, _ := metaSvc.ListChunks(ver.StorageName); len(chunks) != 1 {
+		t.Errorf("renamed file has %d chunks, want 1", len(chunks))
+	}
+	if file, ok := metaSvc.GetFile("archive/legacy.bin"); !ok || file.Parent != "archive" || file.TotalSize != 4 {
+		t.Errorf("renamed file = %+v, %v", file, ok)
+	}
+	if _, ok := metaSvc.GetFile("legacy.bin"); ok {
+		t.Error("old name still listed")
+	}
+
+	if err := metaSvc.RenameFile("top.txt", "docs/a.md"); !errors.Is(err, errorx.ErrFileAlreadyExists) {
+		t.Errorf("expected ErrFileAlreadyExists, got %v", err)
+	}
+	if err := metaSvc.RenameFile("missing.txt", "x.txt"); !errors.Is(err, errorx.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound, got %v", err)
+	}
+
+	if err := metaSvc.RenameDir("docs", "docs/sub"); !errors.Is(err, errorx.ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath moving a directory into itself, got %v", err)
+	}
+	if err := metaSvc.RenameDir("docs", "notes"); err != nil {
+		t.Fatalf("RenameDir failed: %v", err)
+	}
+	tree, _ := metaSvc.ListTree("notes")
+	if len(tree) != 2 || tree[0].FileName != "notes/a.md" || tree[1].FileName != "notes/old/b.md" || tree[1].Parent != "notes/old" {
+		t.Errorf("ListTree(notes) = %+v", tree)
+	}
+	if _, subdirs, _ := metaSvc.ListDir(""); !reflect.DeepEqual(subdirs, []string{"archive", "notes"}) {
+		t.Errorf("root subdirs after RenameDir = %v", subdirs)
+	}
+
+	// Nothing moves when one destination is taken
+	if err := metaSvc.AddFile("docs2/a.md", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := metaSvc.RenameDir("notes", "docs2"); !errors.Is(err, errorx.ErrFileAlreadyExists) {
+		t.Errorf("expected ErrFileAlreadyExists, got %v", err)
+	}
+	if tree, _ := metaSvc.ListTree("notes"); len(tree) != 2 {
+		t.Errorf("failed RenameDir moved files: %+v", tree)
+	}
+	if err := metaSvc.RenameDir("missing", "x"); !errors.Is(err, errorx.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound, got %v", err)
+	}
+}
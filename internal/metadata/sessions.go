@@ -0,0 +1,141 @@
+package metadata
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// UploadSession marks a file whose upload has not finished. The chunks stored so far are
+// the chunk rows of the version being uploaded, each recorded only once its object is on
+// the backends, so a session plus ListChunks is enough to resume.
+type UploadSession struct {
+	FileName    string
+	Version     int // version being uploaded; recorded as 1 when zero
+	SourcePath  string
+	SourceSize  int64
+	SourceMTime int64  // modification time of the source, in Unix nanoseconds
+	Options     string // encoded upload options, opaque to metadata
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Expired reports whether the session can no longer be resumed at now
+func (u UploadSession) Expired(now time.Time) bool {
+	return !now.Before(u.ExpiresAt)
+}
+
+// execQuerier is satisfied by *sql.DB and *sql.Tx
+type execQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func insertUploadSession(e execQuerier, sess UploadSession) error {
+	if sess.Version == 0 {
+		sess.Version = 1
+	}
+	_, err := e.Exec(`INSERT INTO upload_sessions (file_name, version, source_path, source_size, source_mtime, options, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		sess.FileName, sess.Version, sess.SourcePath, sess.SourceSize, sess.SourceMTime, sess.Options, sess.CreatedAt.Unix(), sess.ExpiresAt.Unix())
+	if err != nil {
+		if _, ok := getUploadSession(e, sess.FileName); ok {
+			return errorx.WrapWithDetails(errorx.ErrUploadSessionExists, sess.FileName)
+		}
+		return errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	return nil
+}
+
+// GetUploadSession returns the unfinished upload of fileName, if any
+func (m *MetadataService) GetUploadSession(fileName string) (UploadSession, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return getUploadSession(m.db, fileName)
+}
+
+func getUploadSession(q execQuerier, fileName string) (UploadSession, bool) {
+	row := q.QueryRow(`SELECT file_name, version, source_path, source_size, source_mtime, options, created_at, expires_at FROM upload_sessions WHERE file_name = ?`, fileName)
+	sess, err := scanUploadSession(row)
+	if err != nil {
+		return UploadSession{}, false
+	}
+	return sess, true
+}
+
+// EndUploadSession marks the upload of fileName as complete: the version it stored
+// becomes the current one
+func (m *MetadataService) EndUploadSession(fileName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRow(`SELECT version FROM upload_sessions WHERE file_name = ?`, fileName).Scan(&version)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	// Sessions started without BeginUpload have no version of their own
+	if err := setCurrentVersion(tx, fileName, version); err != nil && !errors.Is(err, errorx.ErrVersionNotFound) {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM upload_sessions WHERE file_name = ?`, fileName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	return nil
+}
+
+// ListUploadSessions returns every unfinished upload, oldest first
+func (m *MetadataService) ListUploadSessions() ([]UploadSession, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	rows, err := m.db.Query(`SELECT file_name, version, source_path, source_size, source_mtime, options, created_at, expires_at FROM upload_sessions ORDER BY created_at, file_name`)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer rows.Close()
+	var sessions []UploadSession
+	for rows.Next() {
+		sess, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		sessions = append(sessions, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+	return sessions, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUploadSession(row scanner) (UploadSession, error) {
+	var (
+		sess             UploadSession
+		created, expires int64
+	)
+	err := row.Scan(&sess.FileName, &sess.Version, &sess.SourcePath, &sess.SourceSize, &sess.SourceMTime, &sess.Options, &created, &expires)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	sess.CreatedAt = time.Unix(created, 0)
+	sess.ExpiresAt = time.Unix(expires, 0)
+	return sess, nil
+}
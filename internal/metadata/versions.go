@@ -0,0 +1,269 @@
+package metadata
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// FileVersion is one upload of a file. Its chunks, stripes and parity shards are recorded
+// under StorageName: the file name itself for the first version, "<name>;v<N>" for later
+// ones, so versions never share erasure-coded objects.
+type FileVersion struct {
+	FileName    string
+	Version     int // 1 for the first upload, counting up
+	StorageName string
+	TotalSize   int64
+	WrappedKey  []byte // data key wrapped by the master key; nil for unencrypted versions
+	CreatedAt   time.Time
+	Current     bool // the version reads return
+}
+
+// BeginUpload starts the upload of a new version of sess.FileName, of size bytes and
+// encrypted with wrappedKey. In one transaction it creates the file when it is new,
+// allocates the version number and storage name and records the session. The version
+// becomes current once EndUploadSession is called; until then reads return the previous one.
+func (m *MetadataService) BeginUpload(sess UploadSession, size int64, wrappedKey []byte) (FileVersion, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return FileVersion{}, errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	defer tx.Rollback()
+
+	if _, ok := getUploadSession(tx, sess.FileName); ok {
+		return FileVersion{}, errorx.WrapWithDetails(errorx.ErrUploadSessionExists, sess.FileName)
+	}
+	// Numbers are never reused while the file exists, even those of pruned or failed uploads
+	var latest int
+	err = tx.QueryRow(`SELECT latest_version FROM files WHERE file_name = ?`, sess.FileName).Scan(&latest)
+	if err != nil && err != sql.ErrNoRows {
+		return FileVersion{}, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	ver := FileVersion{
+		FileName:   sess.FileName,
+		Version:    latest + 1,
+		TotalSize:  size,
+		WrappedKey: wrappedKey,
+		CreatedAt:  time.Unix(sess.CreatedAt.Unix(), 0),
+	}
+	if ver.StorageName, err = allocStorageName(tx, ver.FileName, ver.Version); err != nil {
+		return FileVersion{}, err
+	}
+	_, err = tx.Exec(`INSERT INTO file_versions (file_name, version, storage_name, total_size, wrapped_key, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		ver.FileName, ver.Version, ver.StorageName, ver.TotalSize, ver.WrappedKey, ver.CreatedAt.Unix())
+	if err != nil {
+		return FileVersion{}, errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	// A new file is listed at once, with its only version current but still uploading
+	res, err := tx.Exec(`INSERT OR IGNORE INTO files (file_name, parent, total_size, wrapped_key, version, latest_version) VALUES (?, ?, ?, ?, ?, ?)`,
+		ver.FileName, ParentDir(ver.FileName), ver.TotalSize, ver.WrappedKey, ver.Version, ver.Version)
+	if err != nil {
+		return FileVersion{}, errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		ver.Current = true
+	} else if _, err = tx.Exec(`UPDATE files SET latest_version = ? WHERE file_name = ?`, ver.Version, ver.FileName); err != nil {
+		return FileVersion{}, errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+
+	sess.Version = ver.Version
+	if err := insertUploadSession(tx, sess); err != nil {
+		return FileVersion{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return FileVersion{}, errorx.Wrap(errorx.ErrFileInsertFailed, err)
+	}
+	return ver, nil
+}
+
+// allocStorageName picks a storage name no other version or chunk row uses yet
+func allocStorageName(tx *sql.Tx, fileName string, version int) (string, error) {
+	name := fileName
+	if version > 1 {
+		name = fmt.Sprintf("%s;v%d", fileName, version)
+	}
+	for attempt := 1; ; attempt++ {
+		var taken int
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM file_versions WHERE storage_name = ?) OR EXISTS (SELECT 1 FROM file_chunks WHERE file_name = ?)`,
+			name, name).Scan(&taken)
+		if err != nil {
+			return "", errorx.Wrap(errorx.ErrDBQueryFailed, err)
+		}
+		if taken == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s;v%d.%d", fileName, version, attempt)
+	}
+}
+
+const versionColumns = `v.file_name, v.version, v.storage_name, v.total_size, v.wrapped_key, v.created_at, v.version = f.version`
+
+func scanVersion(row scanner) (FileVersion, error) {
+	var (
+		ver     FileVersion
+		created int64
+	)
+	if err := row.Scan(&ver.FileName, &ver.Version, &ver.StorageName, &ver.TotalSize, &ver.WrappedKey, &created, &ver.Current); err != nil {
+		return FileVersion{}, err
+	}
+	ver.CreatedAt = time.Unix(created, 0)
+	return ver, nil
+}
+
+// GetVersion returns a version of fileName, or its current version when version is 0.
+// Versions still uploading are returned too; GetUploadSession tells them apart.
+func (m *MetadataService) GetVersion(fileName string, version int) (FileVersion, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	row := m.db.QueryRow(`SELECT `+versionColumns+` FROM file_versions v JOIN files f ON f.file_name = v.file_name
+		WHERE v.file_name = ? AND v.version = CASE WHEN ? = 0 THEN f.version ELSE ? END`, fileName, version, version)
+	ver, err := scanVersion(row)
+	if err != nil {
+		return FileVersion{}, false
+	}
+	return ver, true
+}
+
+// ListVersions returns the finished versions of fileName, newest first
+func (m *MetadataService) ListVersions(fileName string) ([]FileVersion, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	rows, err := m.db.Query(`SELECT `+versionColumns+` FROM file_versions v JOIN files f ON f.file_name = v.file_name
+		WHERE v.file_name = ? AND NOT EXISTS (SELECT 1 FROM upload_sessions s WHERE s.file_name = v.file_name AND s.version = v.version)
+		ORDER BY v.version DESC`, fileName)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	defer rows.Close()
+	var result []FileVersion
+	for rows.Next() {
+		ver, err := scanVersion(rows)
+		if err != nil {
+			return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+		}
+		result = append(result, ver)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+	return result, nil
+}
+
+// SetCurrentVersion makes a finished version of fileName the one reads return, as when
+// restoring an older upload. Newer versions are kept.
+func (m *MetadataService) SetCurrentVersion(fileName string, version int) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	defer tx.Rollback()
+
+	if sess, ok := getUploadSession(tx, fileName); ok && sess.Version == version {
+		return errorx.WrapWithDetails(errorx.ErrUploadInProgress, fileName)
+	}
+	if err := setCurrentVersion(tx, fileName, version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	return nil
+}
+
+// setCurrentVersion points the file row at version, copying its size and data key
+func setCurrentVersion(tx *sql.Tx, fileName string, version int) error {
+	var (
+		size       int64
+		wrappedKey []byte
+	)
+	err := tx.QueryRow(`SELECT total_size, wrapped_key FROM file_versions WHERE file_name = ? AND version = ?`, fileName, version).Scan(&size, &wrappedKey)
+	if err == sql.ErrNoRows {
+		return errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, version))
+	}
+	if err != nil {
+		return errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	_, err = tx.Exec(`UPDATE files SET version = ?, total_size = ?, wrapped_key = ? WHERE file_name = ?`, version, size, wrappedKey, fileName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	return nil
+}
+
+// SetVersionSize records the size of a version whose upload started before its size was
+// known, as for uploads from a stream. EndUploadSession copies it to the file.
+func (m *MetadataService) SetVersionSize(fileName string, version int, size int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	res, err := m.db.Exec(`UPDATE file_versions SET total_size = ? WHERE file_name = ? AND version = ?`, size, fileName, version)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileUpdateFailed, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, version))
+	}
+	return nil
+}
+
+// ReleaseVersion removes one version of fileName that is not the current one, ending its
+// upload session if it was still uploading. Like ReleaseFile it returns the objects no
+// longer referenced, and the version's parity shards, for deletion from the backends.
+func (m *MetadataService) ReleaseVersion(fileName string, version int) ([]ChunkMetadata, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+	}
+	defer tx.Rollback()
+
+	var (
+		storageName string
+		current     bool
+	)
+	err = tx.QueryRow(`SELECT v.storage_name, v.version = f.version FROM file_versions v JOIN files f ON f.file_name = v.file_name
+		WHERE v.file_name = ? AND v.version = ?`, fileName, version).Scan(&storageName, &current)
+	if err == sql.ErrNoRows {
+		return nil, errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, version))
+	}
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrDBQueryFailed, err)
+	}
+	if current {
+		return nil, errorx.WrapWithDetails(errorx.ErrVersionIsCurrent, fmt.Sprintf("%s version %d", fileName, version))
+	}
+
+	released, err := releaseRefs(tx, storageName)
+	if err != nil {
+		return nil, err
+	}
+	unreferenced, err := releaseUnreferenced(tx)
+	if err != nil {
+		return nil, err
+	}
+	released = append(released, unreferenced...)
+	_, err = tx.Exec(`DELETE FROM file_versions WHERE file_name = ? AND version = ?`, fileName, version)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+	}
+	_, err = tx.Exec(`DELETE FROM upload_sessions WHERE file_name = ? AND version = ?`, fileName, version)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errorx.Wrap(errorx.ErrFileDeleteFailed, err)
+	}
+	return released, nil
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// sentinel names an error of internal/errors in the error label
+type sentinel struct {
+	label string
+	err   error
+}
+
+// sentinels is checked in order with errors.Is: context errors first, then the errors
+// that wrap a provider error, like ErrRetriesExhausted, before the provider errors
+var sentinels = []sentinel{
+	{"canceled", context.Canceled},
+	{"deadline_exceeded", context.DeadlineExceeded},
+	{"retries_exhausted", errorx.ErrRetriesExhausted},
+	{"write_quorum_not_met", errorx.ErrWriteQuorumNotMet},
+	{"not_enough_backends", errorx.ErrNotEnoughBackends},
+	{"stripe_unrecoverable", errorx.ErrStripeUnrecoverable},
+	{"chunk_corrupted", errorx.ErrChunkCorrupted},
+	{"storage_not_found", errorx.ErrStorageNotFound},
+	{"dropbox_upload", errorx.ErrDropboxUpload},
+	{"dropbox_download", errorx.ErrDropboxDownload},
+	{"dropbox_delete", errorx.ErrDropboxDelete},
+	{"dropbox_list", errorx.ErrDropboxList},
+	{"unknown_storage_mode", errorx.ErrUnknownStorageMode},
+	{"invalid_erasure_layout", errorx.ErrInvalidErasureLayout},
+	{"file_reader_closed", errorx.ErrFileReaderClosed},
+	{"invalid_offset", errorx.ErrInvalidOffset},
+	{"invalid_path", errorx.ErrInvalidPath},
+	{"invalid_pattern", errorx.ErrInvalidPattern},
+	{"unknown_symlink_mode", errorx.ErrUnknownSymlinkMode},
+	{"local_upload", errorx.ErrLocalUpload},
+	{"local_download", errorx.ErrLocalDownload},
+	{"local_delete", errorx.ErrLocalDelete},
+	{"local_stat", errorx.ErrLocalStat},
+	{"local_list", errorx.ErrLocalList},
+	{"s3_upload", errorx.ErrS3Upload},
+	{"s3_download", errorx.ErrS3Download},
+	{"s3_delete", errorx.ErrS3Delete},
+	{"s3_list", errorx.ErrS3List},
+	{"file_already_exists", errorx.ErrFileAlreadyExists},
+	{"chunk_already_exists", errorx.ErrChunkAlreadyExists},
+	{"file_info_fetch_failed", errorx.ErrFileInfoFetchFailed},
+	{"file_not_found", errorx.ErrFileNotFound},
+	{"chunk_not_found", errorx.ErrChunkNotFound},
+	{"file_delete_failed", errorx.ErrFileDeleteFailed},
+	{"chunk_delete_failed", errorx.ErrChunkDeleteFailed},
+	{"metadata_db_open_failed", errorx.ErrMetadataDBOpenFailed},
+	{"metadata_schema_init_failed", errorx.ErrMetadataSchemaInitFailed},
+	{"chunk_insert_failed", errorx.ErrChunkInsertFailed},
+	{"file_insert_failed", errorx.ErrFileInsertFailed},
+	{"file_update_failed", errorx.ErrFileUpdateFailed},
+	{"db_query_failed", errorx.ErrDBQueryFailed},
+	{"db_scan_failed", errorx.ErrDBScanFailed},
+	{"stripe_insert_failed", errorx.ErrStripeInsertFailed},
+	{"upload_session_exists", errorx.ErrUploadSessionExists},
+	{"upload_session_not_found", errorx.ErrUploadSessionNotFound},
+	{"upload_session_expired", errorx.ErrUploadSessionExpired},
+	{"upload_in_progress", errorx.ErrUploadInProgress},
+	{"upload_source_changed", errorx.ErrUploadSourceChanged},
+	{"version_not_found", errorx.ErrVersionNotFound},
+	{"version_is_current", errorx.ErrVersionIsCurrent},
+	{"chunk_read_failed", errorx.ErrChunkReadFailed},
+	{"unknown_chunking_strategy", errorx.ErrUnknownChunkingStrategy},
+	{"invalid_chunk_sizes", errorx.ErrInvalidChunkSizes},
+	{"invalid_encryption_key", errorx.ErrInvalidEncryptionKey},
+	{"encrypt_failed", errorx.ErrEncryptFailed},
+	{"decrypt_failed", errorx.ErrDecryptFailed},
+	{"unsupported_cipher_version", errorx.ErrUnsupportedCipherVersion},
+	{"key_unwrap_failed", errorx.ErrKeyUnwrapFailed},
+	{"encryption_key_missing", errorx.ErrEncryptionKeyMissing},
+	{"unknown_codec", errorx.ErrUnknownCodec},
+	{"compress_failed", errorx.ErrCompressFailed},
+	{"decompress_failed", errorx.ErrDecompressFailed},
+}
+
+// errorLabel returns the label of the first sentinel err matches, or "other". Labels come
+// from a fixed set so that error messages, which carry file and object names, never
+// become label values.
+func errorLabel(err error) string {
+	for _, s := range sentinels {
+		if errors.Is(err, s.err) {
+			return s.label
+		}
+	}
+	return "other"
+}
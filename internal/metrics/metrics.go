@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Worker pools reported by storagex_workers_in_flight
+const (
+	PoolUpload   = "upload"
+	PoolDownload = "download"
+	PoolDelete   = "delete"
+)
+
+var (
+	// registry holds the storagex metrics, runtimeRegistry the Go and process ones; only the
+	// former is written to textfiles, since a CLI run's runtime is not worth keeping
+	registry        = prometheus.NewRegistry()
+	runtimeRegistry = prometheus.NewRegistry()
+
+	backendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "storagex",
+		Name:      "backend_operation_duration_seconds",
+		Help:      "Duration of single backend calls, retries counted separately, by operation: upload, get, delete, list or query (remaining size).",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"backend", "op"})
+	backendChunks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "backend_chunks_total",
+		Help:      "Objects successfully uploaded, downloaded or deleted per backend.",
+	}, []string{"backend", "op"})
+	backendBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "backend_bytes_total",
+		Help:      "Stored bytes successfully uploaded or downloaded per backend.",
+	}, []string{"backend", "op"})
+	backendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "backend_errors_total",
+		Help:      "Failed backend calls by operation and error.",
+	}, []string{"backend", "op", "error"})
+	backendRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "backend_retries_total",
+		Help:      "Backend calls retried after a transient failure.",
+	}, []string{"backend", "op"})
+	backendRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "storagex",
+		Name:      "backend_remaining_bytes",
+		Help:      "Free space last reported by each backend.",
+	}, []string{"backend"})
+
+	replicaFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "replica_failures_total",
+		Help:      "Replica writes that failed, and reads that fell over to another replica, per backend.",
+	}, []string{"backend", "op"})
+	quorumFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "write_quorum_failures_total",
+		Help:      "Chunk writes that reached fewer replicas than the write quorum.",
+	})
+	reconstructions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "stripe_reconstructions_total",
+		Help:      "Erasure-coded chunks rebuilt from their stripe because no copy was usable.",
+	})
+
+	opDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "storagex",
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of StorageService operations: upload, download, read_chunk or delete.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+	}, []string{"op"})
+	opBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "operation_bytes_total",
+		Help:      "File bytes stored by uploads and returned by downloads and chunk reads.",
+	}, []string{"op"})
+	opErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "storagex",
+		Name:      "operation_errors_total",
+		Help:      "Failed StorageService operations by error.",
+	}, []string{"op", "error"})
+	workers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "storagex",
+		Name:      "workers_in_flight",
+		Help:      "Chunk uploads, downloads and deletes running, per worker pool.",
+	}, []string{"pool"})
+)
+
+func init() {
+	registry.MustRegister(backendDuration, backendChunks, backendBytes, backendErrors, backendRetries, backendRemaining,
+		replicaFailures, quorumFailures, reconstructions, opDuration, opBytes, opErrors, workers)
+	runtimeRegistry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// ObserveBackend records a backend call of op that started at start and moved size stored
+// bytes (0 for calls that move none)
+func ObserveBackend(backend, op string, size int, start time.Time, err error) {
+	backendDuration.WithLabelValues(backend, op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		backendErrors.WithLabelValues(backend, op, errorLabel(err)).Inc()
+		return
+	}
+	switch op {
+	case "upload", "get":
+		backendBytes.WithLabelValues(backend, op).Add(float64(size))
+		fallthrough
+	case "delete":
+		backendChunks.WithLabelValues(backend, op).Inc()
+	}
+}
+
+// BackendRetry counts a retry of op on backend
+func BackendRetry(backend, op string) {
+	backendRetries.WithLabelValues(backend, op).Inc()
+}
+
+// SetRemaining records the free space reported by backend
+func SetRemaining(backend string, size int64) {
+	backendRemaining.WithLabelValues(backend).Set(float64(size))
+}
+
+// ReplicaFailure counts a failed replica write (op "upload") or read (op "get") on backend
+func ReplicaFailure(backend, op string) {
+	replicaFailures.WithLabelValues(backend, op).Inc()
+}
+
+// QuorumFailure counts a chunk write that missed the write quorum
+func QuorumFailure() {
+	quorumFailures.Inc()
+}
+
+// Reconstruction counts a chunk rebuilt from its stripe
+func Reconstruction() {
+	reconstructions.Inc()
+}
+
+// ObserveOperation records a StorageService operation that started at start and moved
+// size file bytes
+func ObserveOperation(op string, size int64, start time.Time, err error) {
+	opDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	opBytes.WithLabelValues(op).Add(float64(size))
+	if err != nil {
+		opErrors.WithLabelValues(op, errorLabel(err)).Inc()
+	}
+}
+
+// TrackWorker counts a worker of pool as running until the returned function is called:
+//
+//	defer metrics.TrackWorker(metrics.PoolUpload)()
+func TrackWorker(pool string) func() {
+	g := workers.WithLabelValues(pool)
+	g.Inc()
+	return g.Dec
+}
+
+// Handler serves every metric, the Go runtime and process ones included, in the
+// Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.Gatherers{registry, runtimeRegistry}, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the storagex metrics to path for the node exporter's textfile
+// collector. The file is replaced atomically, so a scrape never sees half of it.
+func WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, registry)
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+func TestErrorLabel(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errorx.WrapWithDetails(errorx.ErrS3Upload, "chunk_1"), "s3_upload"},
+		{errorx.Wrap(errorx.ErrRetriesExhausted, errorx.Wrap(errorx.ErrS3Upload, errors.New("503"))), "retries_exhausted"},
+		{fmt.Errorf("upload: %w", context.Canceled), "canceled"},
+		{errorx.Wrap(errorx.ErrChunkCorrupted, context.DeadlineExceeded), "deadline_exceeded"},
+		{errors.New("disk on fire"), "other"},
+	}
+	for _, tt := range tests {
+		if got := errorLabel(tt.err); got != tt.want {
+			t.Errorf("errorLabel(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestObserveBackend(t *testing.T) {
+	start := time.Now()
+	ObserveBackend("test:a", "upload", 100, start, nil)
+	ObserveBackend("test:a", "upload", 50, start, nil)
+	ObserveBackend("test:a", "delete", 0, start, nil)
+	ObserveBackend("test:a", "get", 10, start, errorx.ErrLocalDownload)
+
+	if got := testutil.ToFloat64(backendBytes.WithLabelValues("test:a", "upload")); got != 150 {
+		t.Errorf("uploaded bytes = %v, want 150", got)
+	}
+	if got := testutil.ToFloat64(backendChunks.WithLabelValues("test:a", "upload")); got != 2 {
+		t.Errorf("uploaded chunks = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(backendChunks.WithLabelValues("test:a", "delete")); got != 1 {
+		t.Errorf("deleted chunks = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(backendBytes.WithLabelValues("test:a", "get")); got != 0 {
+		t.Errorf("failed download counted %v bytes", got)
+	}
+	if got := testutil.ToFloat64(backendErrors.WithLabelValues("test:a", "get", "local_download")); got != 1 {
+		t.Errorf("download errors = %v, want 1", got)
+	}
+}
+
+func TestTrackWorker(t *testing.T) {
+	done := TrackWorker(PoolDelete)
+	TrackWorker(PoolDelete)()
+	if got := testutil.ToFloat64(workers.WithLabelValues(PoolDelete)); got != 1 {
+		t.Errorf("workers in flight = %v, want 1", got)
+	}
+	done()
+	if got := testutil.ToFloat64(workers.WithLabelValues(PoolDelete)); got != 0 {
+		t.Errorf("workers in flight = %v after both finished", got)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	SetRemaining("test:b", 4096)
+	path := filepath.Join(t.TempDir(), "storagex.prom")
+	if err := WriteTextfile(path); err != nil {
+		t.Fatalf("WriteTextfile failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	if !strings.Contains(text, `storagex_backend_remaining_bytes{backend="test:b"} 4096`) {
+		t.Errorf("textfile lacks the remaining size:\n%s", text)
+	}
+	if strings.Contains(text, "go_goroutines") {
+		t.Error("textfile contains runtime metrics")
+	}
+}
@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	storagexv1 "github.com/sayuyere/storageX/api/storagex/v1"
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+// NewServer returns a gRPC server with the storagex.v1.StorageService API over the given
+// services registered. It serves TLS when cfg names a certificate and key, and requires
+// one of cfg.Tokens as a bearer token on every call when any are configured.
+//
+// Streams are flow controlled by gRPC. Upload content is staged in a temporary file below
+// cfg.StagingDir as it arrives and stored once the client closes the stream, so a slow
+// client never holds the storage write lock that every other call waits on. Download
+// sends the next message only when the client has room for it.
+func NewServer(st *storage.StorageService, meta *metadata.MetadataService, cfg config.GRPCConfig) (*grpc.Server, error) {
+	auth := authenticator{tokens: cfg.Tokens}
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(auth.unary),
+		grpc.StreamInterceptor(auth.stream),
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, errorx.ErrGRPCIncompleteTLS
+		}
+		creds, err := credentials.NewServerTLSFromFile(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	srv := grpc.NewServer(opts...)
+	storagexv1.RegisterStorageServiceServer(srv, &service{storage: st, meta: meta, staging: cfg.StagingDir})
+	return srv, nil
+}
+
+// authenticator checks the "authorization: Bearer <token>" metadata of incoming calls
+type authenticator struct {
+	tokens []string
+}
+
+func (a authenticator) check(ctx context.Context) error {
+	if len(a.tokens) == 0 {
+		return nil
+	}
+	md, _ := grpcmd.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if !ok {
+			continue
+		}
+		for _, want := range a.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+				return nil
+			}
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+func (a authenticator) unary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a authenticator) stream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// errorCode maps an error from internal/errors to a gRPC status code
+type errorCode struct {
+	err  error
+	code codes.Code
+}
+
+// errorCodes is checked in order with errors.Is, so a context error wins over the storage
+// error it caused
+var errorCodes = []errorCode{
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	{context.Canceled, codes.Canceled},
+	{errorx.ErrFileNotFound, codes.NotFound},
+	{errorx.ErrVersionNotFound, codes.NotFound},
+	{errorx.ErrFileAlreadyExists, codes.AlreadyExists},
+	{errorx.ErrUploadInProgress, codes.FailedPrecondition},
+	{errorx.ErrUploadSessionExists, codes.FailedPrecondition},
+	{errorx.ErrVersionIsCurrent, codes.FailedPrecondition},
+	{errorx.ErrInvalidRequest, codes.InvalidArgument},
+	{errorx.ErrInvalidPath, codes.InvalidArgument},
+	{errorx.ErrInvalidOffset, codes.OutOfRange},
+	{errorx.ErrUnknownStorageMode, codes.InvalidArgument},
+	{errorx.ErrInvalidErasureLayout, codes.InvalidArgument},
+	{errorx.ErrUnknownCodec, codes.InvalidArgument},
+	{errorx.ErrMissingUploadHeader, codes.InvalidArgument},
+	{errorx.ErrUnexpectedHeader, codes.InvalidArgument},
+	{errorx.ErrNotEnoughBackends, codes.Unavailable},
+	{errorx.ErrWriteQuorumNotMet, codes.Unavailable},
+	{errorx.ErrRetriesExhausted, codes.Unavailable},
+	{errorx.ErrStripeUnrecoverable, codes.DataLoss},
+	{errorx.ErrChunkCorrupted, codes.DataLoss},
+}
+
+// toStatus turns err into a gRPC status error; errors that already are one, like those
+// of a failed stream, are passed through and unknown errors are Internal
+func toStatus(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return status.Error(c.code, err.Error())
+		}
+	}
+	log.Error("gRPC call failed: %v", err)
+	return status.Error(codes.Internal, err.Error())
+}
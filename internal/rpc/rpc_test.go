@@ -0,0 +1,311 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	storagexv1 "github.com/sayuyere/storageX/api/storagex/v1"
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/manager"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+func setupClient(t *testing.T, cfg config.GRPCConfig) (storagexv1.StorageServiceClient, *metadata.MetadataService) {
+	t.Helper()
+	dir := t.TempDir()
+	meta, err := metadata.NewMetadataService(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	local, err := cloud.NewLocalStorage(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatalf("failed to create local storage: %v", err)
+	}
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{local})
+	st := storage.NewStorageService(mgr, meta, chunker.NewFileChunker(chunker.ChunkMetadataSize+64))
+	srv, err := NewServer(st, meta, cfg)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return storagexv1.NewStorageServiceClient(conn), meta
+}
+
+// upload sends data in messages of 100 bytes
+func upload(ctx context.Context, client storagexv1.StorageServiceClient, path string, data []byte) (*storagexv1.UploadResponse, error) {
+	stream, err := client.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Header{Header: &storagexv1.UploadHeader{Path: path}}}); err != nil {
+		return nil, err
+	}
+	for len(data) > 0 {
+		n := min(len(data), 100)
+		if err := stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Content{Content: data[:n]}}); err != nil {
+			return nil, err
+		}
+		data = data[n:]
+	}
+	return stream.CloseAndRecv()
+}
+
+func download(t *testing.T, client storagexv1.StorageServiceClient, req *storagexv1.DownloadRequest) (*storagexv1.FileInfo, []byte) {
+	t.Helper()
+	stream, err := client.Download(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	var (
+		info *storagexv1.FileInfo
+		data []byte
+	)
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return info, data
+		}
+		if err != nil {
+			t.Fatalf("Download of %s failed: %v", req.Path, err)
+		}
+		if resp.File != nil {
+			info = resp.File
+		}
+		data = append(data, resp.Content...)
+	}
+}
+
+func TestService_Files(t *testing.T) {
+	client, _ := setupClient(t, config.GRPCConfig{})
+	ctx := context.Background()
+	v1 := bytes.Repeat([]byte("0123456789abcdef"), 40)
+	v2 := bytes.ToUpper(v1)
+
+	resp, err := upload(ctx, client, "/docs/a.txt", v1)
+	if err != nil || resp.File.Path != "docs/a.txt" || resp.File.Size != int64(len(v1)) || resp.File.Version != 1 {
+		t.Fatalf("Upload = %v, %v", resp, err)
+	}
+	if resp, err = upload(ctx, client, "docs/a.txt", v2); err != nil || resp.File.Version != 2 {
+		t.Fatalf("second Upload = %v, %v", resp, err)
+	}
+
+	if info, data := download(t, client, &storagexv1.DownloadRequest{Path: "docs/a.txt"}); info.Version != 2 || !bytes.Equal(data, v2) {
+		t.Errorf("Download = version %d, %d bytes", info.Version, len(data))
+	}
+	if _, data := download(t, client, &storagexv1.DownloadRequest{Path: "docs/a.txt", Version: 1, Offset: 100, Length: 50}); !bytes.Equal(data, v1[100:150]) {
+		t.Errorf("ranged Download of version 1 = %q", data)
+	}
+	if info, data := download(t, client, &storagexv1.DownloadRequest{Path: "docs/a.txt", Offset: int64(len(v2))}); info == nil || len(data) != 0 {
+		t.Errorf("Download at the end = %v, %d bytes", info, len(data))
+	}
+	stream, _ := client.Download(ctx, &storagexv1.DownloadRequest{Path: "docs/a.txt", Offset: int64(len(v2)) + 1})
+	if _, err := stream.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Errorf("Download past the end = %v, want OutOfRange", err)
+	}
+
+	stat, err := client.Stat(ctx, &storagexv1.StatRequest{Path: "docs/a.txt", Version: 1})
+	if err != nil || stat.File.Version != 1 || stat.File.Size != int64(len(v1)) {
+		t.Errorf("Stat of version 1 = %v, %v", stat, err)
+	}
+	if _, err := client.Stat(ctx, &storagexv1.StatRequest{Path: "docs/missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Stat of a missing file = %v, want NotFound", err)
+	}
+
+	verify, err := client.Verify(ctx, &storagexv1.VerifyRequest{Path: "docs/a.txt", Deep: true})
+	if err != nil || len(verify.Issues) != 0 || verify.Objects == 0 || verify.Verified != verify.Objects {
+		t.Errorf("Verify = %v, %v", verify, err)
+	}
+
+	if _, err := client.Delete(ctx, &storagexv1.DeleteRequest{Path: "docs/a.txt", Version: 1}); err != nil {
+		t.Fatalf("Delete of version 1 failed: %v", err)
+	}
+	if _, err := client.Stat(ctx, &storagexv1.StatRequest{Path: "docs/a.txt", Version: 1}); status.Code(err) != codes.NotFound {
+		t.Errorf("Stat of a deleted version = %v, want NotFound", err)
+	}
+	if _, err := client.Delete(ctx, &storagexv1.DeleteRequest{Path: "docs/a.txt"}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := client.Delete(ctx, &storagexv1.DeleteRequest{Path: "docs/a.txt"}); status.Code(err) != codes.NotFound {
+		t.Errorf("second Delete = %v, want NotFound", err)
+	}
+}
+
+func TestService_List(t *testing.T) {
+	client, _ := setupClient(t, config.GRPCConfig{})
+	ctx := context.Background()
+	for _, name := range []string{"a/1", "a/2", "a/3", "b/1"} {
+		if _, err := upload(ctx, client, name, []byte(name)); err != nil {
+			t.Fatalf("Upload of %s failed: %v", name, err)
+		}
+	}
+
+	var got []string
+	req := &storagexv1.ListRequest{Prefix: "a/", PageSize: 2}
+	for {
+		resp, err := client.List(ctx, req)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		for _, f := range resp.Files {
+			got = append(got, f.Path)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if len(got) != 3 || got[0] != "a/1" || got[2] != "a/3" {
+		t.Errorf("List = %v", got)
+	}
+	if _, err := client.List(ctx, &storagexv1.ListRequest{PageSize: -1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("List with a negative page size = %v, want InvalidArgument", err)
+	}
+}
+
+func TestService_UploadErrors(t *testing.T) {
+	client, meta := setupClient(t, config.GRPCConfig{})
+
+	stream, err := client.Upload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Content{Content: []byte("data")}})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Upload without a header = %v, want InvalidArgument", err)
+	}
+
+	// A stream cut short by the client stores nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = client.Upload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Header{Header: &storagexv1.UploadHeader{Path: "cut.bin"}}})
+	stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Content{Content: bytes.Repeat([]byte("x"), 1000)}})
+	cancel()
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.Canceled {
+		t.Errorf("canceled Upload = %v, want Canceled", err)
+	}
+	// The server notices the cancellation asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f, ok := meta.GetFile("cut.bin")
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("canceled Upload left %+v", f)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// staged reports whether a file of size bytes is staged in dir
+func staged(t *testing.T, dir string, size int) bool {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && info.Size() == int64(size) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestService_StalledUpload(t *testing.T) {
+	staging := t.TempDir()
+	client, _ := setupClient(t, config.GRPCConfig{StagingDir: staging})
+	if _, err := upload(context.Background(), client, "other.txt", []byte("other")); err != nil {
+		t.Fatalf("Upload of other.txt failed: %v", err)
+	}
+
+	// A client that sends part of its content and then stalls
+	stream, err := client.Upload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Header{Header: &storagexv1.UploadHeader{Path: "slow.txt"}}})
+	stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Content{Content: []byte("first half, ")}})
+	// Wait until the server has staged what was sent
+	deadline := time.Now().Add(5 * time.Second)
+	for !staged(t, staging, len("first half, ")) {
+		if time.Now().After(deadline) {
+			t.Fatal("the upload content was not staged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := client.Stat(ctx, &storagexv1.StatRequest{Path: "other.txt"}); err != nil {
+		t.Errorf("Stat while an upload stalls: %v", err)
+	}
+	dl, err := client.Download(ctx, &storagexv1.DownloadRequest{Path: "other.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := dl.Recv(); err != nil || string(resp.Content) != "other" {
+		t.Errorf("Download while an upload stalls = %v, %v", resp, err)
+	}
+
+	stream.Send(&storagexv1.UploadRequest{Data: &storagexv1.UploadRequest_Content{Content: []byte("second half")}})
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("stalled Upload failed: %v", err)
+	}
+	if _, data := download(t, client, &storagexv1.DownloadRequest{Path: "slow.txt"}); string(data) != "first half, second half" {
+		t.Errorf("Download of slow.txt = %q", data)
+	}
+}
+
+func TestService_Auth(t *testing.T) {
+	client, _ := setupClient(t, config.GRPCConfig{Tokens: []string{"s3cret", "other"}})
+	req := &storagexv1.ListRequest{}
+
+	if _, err := client.List(context.Background(), req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("List without a token = %v, want Unauthenticated", err)
+	}
+	ctx := grpcmd.AppendToOutgoingContext(context.Background(), "authorization", "Bearer wrong")
+	if _, err := client.List(ctx, req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("List with a wrong token = %v, want Unauthenticated", err)
+	}
+	ctx = grpcmd.AppendToOutgoingContext(context.Background(), "authorization", "Bearer other")
+	if _, err := client.List(ctx, req); err != nil {
+		t.Errorf("List with a valid token failed: %v", err)
+	}
+	if _, err := upload(ctx, client, "a.txt", []byte("hello")); err != nil {
+		t.Errorf("Upload with a valid token failed: %v", err)
+	}
+
+	if _, err := NewServer(nil, nil, config.GRPCConfig{CertFile: "cert.pem"}); err == nil {
+		t.Error("NewServer accepted a certificate without a key")
+	}
+}
@@ -0,0 +1,274 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	storagexv1 "github.com/sayuyere/storageX/api/storagex/v1"
+	"github.com/sayuyere/storageX/internal/defaults"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+// service implements storagexv1.StorageServiceServer over a StorageService
+type service struct {
+	storagexv1.UnimplementedStorageServiceServer
+	storage *storage.StorageService
+	meta    *metadata.MetadataService
+	staging string // uploads are staged here, the system temp dir when empty
+}
+
+// filePath returns the cleaned file name of a request
+func filePath(name string) (string, error) {
+	name, err := storage.CleanPath(name)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", errorx.WrapWithDetails(errorx.ErrInvalidPath, "empty file name")
+	}
+	return name, nil
+}
+
+// stat describes a version of fileName; version 0 is the current one
+func (s *service) stat(fileName string, version int) (*storagexv1.FileInfo, error) {
+	meta, ok := s.meta.GetFile(fileName)
+	if !ok {
+		return nil, errorx.WrapWithDetails(errorx.ErrFileNotFound, fileName)
+	}
+	if ver, ok := s.meta.GetVersion(fileName, version); ok {
+		return &storagexv1.FileInfo{
+			Path:     fileName,
+			Size:     ver.TotalSize,
+			Version:  int64(ver.Version),
+			Modified: timestamppb.New(ver.CreatedAt),
+		}, nil
+	}
+	if version != 0 && version != meta.Version {
+		return nil, errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, version))
+	}
+	// Files recorded before versioning
+	return fileInfo(meta), nil
+}
+
+func fileInfo(f metadata.FileMetadata) *storagexv1.FileInfo {
+	return &storagexv1.FileInfo{
+		Path:     f.FileName,
+		Size:     f.TotalSize,
+		Version:  int64(f.Version),
+		Modified: timestamppb.New(f.ModifiedAt),
+	}
+}
+
+// uploadOptions overrides the configured upload options with the fields set in header
+func uploadOptions(header *storagexv1.UploadHeader) storage.UploadOptions {
+	opts := storage.DefaultUploadOptions()
+	if header.Mode != "" {
+		opts.Mode = header.Mode
+	}
+	if header.Compression != "" {
+		opts.Compression = header.Compression
+	}
+	if header.DataShards != 0 {
+		opts.DataShards = int(header.DataShards)
+	}
+	if header.ParityShards != 0 {
+		opts.ParityShards = int(header.ParityShards)
+	}
+	return opts
+}
+
+// uploadReader reads the content messages of an Upload stream. A message is received
+// only when the previous one has been consumed, which lets gRPC flow control slow the
+// client down to the pace of the staging disk.
+type uploadReader struct {
+	stream grpc.ClientStreamingServer[storagexv1.UploadRequest, storagexv1.UploadResponse]
+	buf    []byte
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		req, err := r.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		if req.GetHeader() != nil {
+			return 0, errorx.ErrUnexpectedHeader
+		}
+		r.buf = req.GetContent()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (s *service) Upload(stream grpc.ClientStreamingServer[storagexv1.UploadRequest, storagexv1.UploadResponse]) error {
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return toStatus(errorx.ErrMissingUploadHeader)
+	}
+	if err != nil {
+		return err
+	}
+	header := req.GetHeader()
+	if header == nil {
+		return toStatus(errorx.ErrMissingUploadHeader)
+	}
+	name, err := filePath(header.Path)
+	if err != nil {
+		return toStatus(err)
+	}
+	// The content is staged first, so a slow client never holds the storage write lock
+	tmp, err := storage.StageReader(&uploadReader{stream: stream}, s.staging)
+	if err != nil {
+		return toStatus(err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, err := s.storage.UploadReaderContext(stream.Context(), tmp, name, uploadOptions(header))
+	if err != nil {
+		return toStatus(err)
+	}
+	log.Info("Stored %s (%d bytes) over gRPC", name, n)
+	info, err := s.stat(name, 0)
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(&storagexv1.UploadResponse{File: info})
+}
+
+func (s *service) Download(req *storagexv1.DownloadRequest, stream grpc.ServerStreamingServer[storagexv1.DownloadResponse]) error {
+	name, err := filePath(req.Path)
+	if err != nil {
+		return toStatus(err)
+	}
+	if req.Offset < 0 || req.Length < 0 {
+		return toStatus(errorx.WrapWithDetails(errorx.ErrInvalidRequest, "offset and length must not be negative"))
+	}
+	f, err := s.storage.OpenFileVersionContext(stream.Context(), name, int(req.Version))
+	if err != nil {
+		return toStatus(err)
+	}
+	defer f.Close()
+	info, err := s.stat(name, int(req.Version))
+	if err != nil {
+		return toStatus(err)
+	}
+	if req.Offset > f.Size() {
+		return toStatus(errorx.WrapWithDetails(errorx.ErrInvalidOffset, fmt.Sprintf("offset %d beyond size %d", req.Offset, f.Size())))
+	}
+	length := f.Size() - req.Offset
+	if req.Length > 0 {
+		length = min(length, req.Length)
+	}
+	if _, err := f.Seek(req.Offset, io.SeekStart); err != nil {
+		return toStatus(err)
+	}
+
+	// The first message describes the file, even when the range is empty. Send returns
+	// once the message is encoded, so the buffer is reused; it blocks while the client's
+	// flow control window is full.
+	r := io.LimitReader(f, length)
+	buf := make([]byte, defaults.DefaultGRPCMessageSize)
+	resp := &storagexv1.DownloadResponse{File: info}
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || resp.File != nil {
+			resp.Content = buf[:n]
+			if sendErr := stream.Send(resp); sendErr != nil {
+				return sendErr
+			}
+			resp.File = nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return toStatus(err)
+		}
+	}
+}
+
+func (s *service) Stat(_ context.Context, req *storagexv1.StatRequest) (*storagexv1.StatResponse, error) {
+	name, err := filePath(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	info, err := s.stat(name, int(req.Version))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storagexv1.StatResponse{File: info}, nil
+}
+
+func (s *service) List(_ context.Context, req *storagexv1.ListRequest) (*storagexv1.ListResponse, error) {
+	if req.PageSize < 0 {
+		return nil, toStatus(errorx.WrapWithDetails(errorx.ErrInvalidRequest, "page_size must not be negative"))
+	}
+	limit := defaults.DefaultListPageSize
+	if req.PageSize > 0 {
+		limit = min(int(req.PageSize), defaults.MaxListPageSize)
+	}
+	// One extra row tells whether another page follows
+	files, err := s.meta.ListPage(req.Prefix, req.PageToken, limit+1)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &storagexv1.ListResponse{}
+	if len(files) > limit {
+		files = files[:limit]
+		resp.NextPageToken = files[limit-1].FileName
+	}
+	for _, f := range files {
+		resp.Files = append(resp.Files, fileInfo(f))
+	}
+	return resp, nil
+}
+
+func (s *service) Delete(ctx context.Context, req *storagexv1.DeleteRequest) (*storagexv1.DeleteResponse, error) {
+	name, err := filePath(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if _, ok := s.meta.GetFile(name); !ok {
+		return nil, toStatus(errorx.WrapWithDetails(errorx.ErrFileNotFound, name))
+	}
+	if req.Version > 0 {
+		err = s.storage.DeleteVersionContext(ctx, name, int(req.Version))
+	} else {
+		err = s.storage.DeleteFileContext(ctx, name)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &storagexv1.DeleteResponse{}, nil
+}
+
+func (s *service) Verify(ctx context.Context, req *storagexv1.VerifyRequest) (*storagexv1.VerifyResponse, error) {
+	name, err := filePath(req.Path)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	report, err := s.storage.FsckFileContext(ctx, name, int(req.Version), storage.FsckOptions{Deep: req.Deep, Repair: req.Repair})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &storagexv1.VerifyResponse{Objects: int64(report.Objects), Verified: int64(report.Verified)}
+	for _, issue := range report.Issues {
+		resp.Issues = append(resp.Issues, &storagexv1.Issue{
+			Kind:     issue.Kind,
+			Object:   issue.Object,
+			Storage:  issue.Storage,
+			Detail:   issue.Detail,
+			Repaired: issue.Repaired,
+		})
+	}
+	return resp, nil
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/defaults"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+// VersionHeader carries the version number of the file a response describes
+const VersionHeader = "X-Storagex-Version"
+
+// Server exposes a StorageService over HTTP:
+//
+//	PUT    /files/{path}  upload the request body as a new version
+//	GET    /files/{path}  download, with Range support; ?version=N for an older version
+//	HEAD   /files/{path}  size, modification time and version only
+//	DELETE /files/{path}  delete every version; ?version=N deletes one
+//	GET    /files         list files; ?prefix=, ?limit= and ?cursor= page through them
+//	GET    /metrics       Prometheus metrics
+//
+// Errors are JSON objects mapped from internal/errors, see statusFor.
+type Server struct {
+	storage *storage.StorageService
+	meta    *metadata.MetadataService
+	mux     *http.ServeMux
+}
+
+// NewServer returns a server for the given services; it is an http.Handler
+func NewServer(st *storage.StorageService, meta *metadata.MetadataService) *Server {
+	s := &Server{storage: st, meta: meta, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /files", s.handleList)
+	s.mux.HandleFunc("GET /files/{path...}", s.handleGet)
+	s.mux.HandleFunc("PUT /files/{path...}", s.handlePut)
+	s.mux.HandleFunc("DELETE /files/{path...}", s.handleDelete)
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// FileInfo describes a stored file in responses
+type FileInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Version  int       `json:"version"`
+	Modified time.Time `json:"modified"`
+}
+
+func fileInfo(f metadata.FileMetadata) FileInfo {
+	return FileInfo{Name: f.FileName, Size: f.TotalSize, Version: f.Version, Modified: f.ModifiedAt}
+}
+
+// ListResponse is one page of a listing; NextCursor is empty on the last page
+type ListResponse struct {
+	Files      []FileInfo `json:"files"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// filePath returns the cleaned file name of a /files/{path} request
+func filePath(r *http.Request) (string, error) {
+	name, err := storage.CleanPath(r.PathValue("path"))
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", errorx.WrapWithDetails(errorx.ErrInvalidPath, "empty file name")
+	}
+	return name, nil
+}
+
+// intParam parses an optional non-negative integer query parameter
+func intParam(r *http.Request, key string, fallback int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, errorx.WrapWithDetails(errorx.ErrInvalidRequest, key+" must be a non-negative integer")
+	}
+	return n, nil
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	limit, err := intParam(r, "limit", defaults.DefaultListPageSize)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if limit == 0 {
+		writeError(w, errorx.WrapWithDetails(errorx.ErrInvalidRequest, "limit must be positive"))
+		return
+	}
+	limit = min(limit, defaults.MaxListPageSize)
+	q := r.URL.Query()
+	// One extra row tells whether another page follows
+	files, err := s.meta.ListPage(q.Get("prefix"), q.Get("cursor"), limit+1)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := ListResponse{Files: []FileInfo{}}
+	if len(files) > limit {
+		files = files[:limit]
+		resp.NextCursor = files[limit-1].FileName
+	}
+	for _, f := range files {
+		resp.Files = append(resp.Files, fileInfo(f))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("path") == "" {
+		s.handleList(w, r)
+		return
+	}
+	name, err := filePath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := intParam(r, "version", 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, err := s.storage.OpenFileVersionContext(r.Context(), name, version)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	var modified time.Time
+	if ver, ok := s.meta.GetVersion(name, version); ok {
+		version, modified = ver.Version, ver.CreatedAt
+	} else if meta, ok := s.meta.GetFile(name); ok {
+		// Files recorded before versioning
+		version, modified = meta.Version, meta.ModifiedAt
+	}
+	w.Header().Set(VersionHeader, strconv.Itoa(version))
+	// ServeContent answers HEAD and Range requests, reading only the chunks a range covers
+	http.ServeContent(w, r, path.Base(name), modified, f)
+}
+
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
+	name, err := filePath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	opts, err := uploadOptions(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// The body is staged first, so a slow client never holds the storage write lock
+	tmp, err := storage.StageReader(&stallReader{r: r.Body, rc: http.NewResponseController(w)}, "")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, err := s.storage.UploadReaderContext(r.Context(), tmp, name, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	log.Info("Stored %s (%d bytes) from %s", name, n, r.RemoteAddr)
+	meta, ok := s.meta.GetFile(name)
+	if !ok {
+		writeError(w, errorx.WrapWithDetails(errorx.ErrFileNotFound, name))
+		return
+	}
+	w.Header().Set(VersionHeader, strconv.Itoa(meta.Version))
+	writeJSON(w, http.StatusCreated, fileInfo(meta))
+}
+
+// bodyStallTimeout is how long an upload body may send nothing before it is cut off
+const bodyStallTimeout = time.Minute
+
+// stallReader moves the connection's read deadline forward before every read, so a
+// body may take as long as it needs but not stall for more than bodyStallTimeout
+type stallReader struct {
+	r  io.Reader
+	rc *http.ResponseController
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	// Connections that do not support deadlines are read without one
+	_ = s.rc.SetReadDeadline(time.Now().Add(bodyStallTimeout))
+	return s.r.Read(p)
+}
+
+// uploadOptions overrides the configured upload options with the mode, data_shards,
+// parity_shards and compress query parameters
+func uploadOptions(r *http.Request) (storage.UploadOptions, error) {
+	opts := storage.DefaultUploadOptions()
+	q := r.URL.Query()
+	if q.Has("mode") {
+		opts.Mode = q.Get("mode")
+	}
+	if q.Has("compress") {
+		opts.Compression = q.Get("compress")
+	}
+	var err error
+	if opts.DataShards, err = intParam(r, "data_shards", opts.DataShards); err != nil {
+		return opts, err
+	}
+	if opts.ParityShards, err = intParam(r, "parity_shards", opts.ParityShards); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	name, err := filePath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	version, err := intParam(r, "version", 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, ok := s.meta.GetFile(name); !ok {
+		writeError(w, errorx.WrapWithDetails(errorx.ErrFileNotFound, name))
+		return
+	}
+	if version > 0 {
+		err = s.storage.DeleteVersionContext(r.Context(), name, version)
+	} else {
+		err = s.storage.DeleteFileContext(r.Context(), name)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errorStatus maps an error from internal/errors to an HTTP status and a stable code
+type errorStatus struct {
+	err    error
+	status int
+	code   string
+}
+
+// statuses is checked in order with errors.Is, so a context error wins over the storage
+// error it caused
+var statuses = []errorStatus{
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout"},
+	{context.Canceled, http.StatusServiceUnavailable, "canceled"},
+	{errorx.ErrFileNotFound, http.StatusNotFound, "not_found"},
+	{errorx.ErrVersionNotFound, http.StatusNotFound, "not_found"},
+	{errorx.ErrUploadInProgress, http.StatusConflict, "conflict"},
+	{errorx.ErrUploadSessionExists, http.StatusConflict, "conflict"},
+	{errorx.ErrVersionIsCurrent, http.StatusConflict, "conflict"},
+	{errorx.ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{errorx.ErrInvalidPath, http.StatusBadRequest, "invalid_request"},
+	{errorx.ErrInvalidOffset, http.StatusBadRequest, "invalid_request"},
+	{errorx.ErrUnknownStorageMode, http.StatusBadRequest, "invalid_request"},
+	{errorx.ErrInvalidErasureLayout, http.StatusBadRequest, "invalid_request"},
+	{errorx.ErrUnknownCodec, http.StatusBadRequest, "invalid_request"},
+	{errorx.ErrNotEnoughBackends, http.StatusServiceUnavailable, "backend_unavailable"},
+	{errorx.ErrWriteQuorumNotMet, http.StatusServiceUnavailable, "backend_unavailable"},
+	{errorx.ErrRetriesExhausted, http.StatusBadGateway, "backend_error"},
+	{errorx.ErrStripeUnrecoverable, http.StatusBadGateway, "backend_error"},
+	{errorx.ErrChunkCorrupted, http.StatusBadGateway, "backend_error"},
+}
+
+// statusFor returns the HTTP status and error code of err; unknown errors are 500s
+func statusFor(err error) (int, string) {
+	for _, s := range statuses {
+		if errors.Is(err, s.err) {
+			return s.status, s.code
+		}
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status, code := statusFor(err)
+	if status >= http.StatusInternalServerError {
+		log.Error("Request failed: %v", err)
+	}
+	var resp ErrorResponse
+	resp.Error.Code = code
+	resp.Error.Message = err.Error()
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Writing response failed: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/cloud"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/manager"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/storage"
+)
+
+func setupServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	meta, err := metadata.NewMetadataService(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	local, err := cloud.NewLocalStorage(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatalf("failed to create local storage: %v", err)
+	}
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{cloud.NewInstrumentedStorage(local)})
+	st := storage.NewStorageService(mgr, meta, chunker.NewFileChunker(chunker.ChunkMetadataSize+64))
+	ts := httptest.NewServer(NewServer(st, meta))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method, url string, body []byte, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading %s %s: %v", method, url, err)
+	}
+	return resp, data
+}
+
+func errorCode(t *testing.T, body []byte) string {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("error body %q is not JSON: %v", body, err)
+	}
+	return resp.Error.Code
+}
+
+func TestServer_Files(t *testing.T) {
+	ts := setupServer(t)
+	v1 := bytes.Repeat([]byte("0123456789abcdef"), 20)
+	v2 := bytes.ToUpper(v1)
+	url := ts.URL + "/files/docs/a.txt"
+
+	resp, body := do(t, http.MethodPut, url, v1)
+	var info FileInfo
+	if resp.StatusCode != http.StatusCreated || json.Unmarshal(body, &info) != nil {
+		t.Fatalf("PUT = %d %s", resp.StatusCode, body)
+	}
+	if info.Name != "docs/a.txt" || info.Size != int64(len(v1)) || info.Version != 1 {
+		t.Errorf("PUT returned %+v", info)
+	}
+
+	resp, body = do(t, http.MethodGet, url, nil)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, v1) || resp.Header.Get(VersionHeader) != "1" {
+		t.Errorf("GET = %d, version %q, %d bytes", resp.StatusCode, resp.Header.Get(VersionHeader), len(body))
+	}
+	// The range spans a chunk boundary
+	resp, body = do(t, http.MethodGet, url, nil, "Range", "bytes=50-149")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, v1[50:150]) {
+		t.Errorf("ranged GET = %d %q", resp.StatusCode, body)
+	}
+	resp, body = do(t, http.MethodHead, url, nil)
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != int64(len(v1)) || len(body) != 0 {
+		t.Errorf("HEAD = %d, length %d, %d body bytes", resp.StatusCode, resp.ContentLength, len(body))
+	}
+
+	if resp, body = do(t, http.MethodPut, url, v2); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("second PUT = %d %s", resp.StatusCode, body)
+	}
+	if _, body = do(t, http.MethodGet, url, nil); !bytes.Equal(body, v2) {
+		t.Error("GET did not return the new version")
+	}
+	if _, body = do(t, http.MethodGet, url+"?version=1", nil); !bytes.Equal(body, v1) {
+		t.Error("GET ?version=1 did not return the first version")
+	}
+
+	resp, body = do(t, http.MethodDelete, url+"?version=2", nil)
+	if resp.StatusCode != http.StatusConflict || errorCode(t, body) != "conflict" {
+		t.Errorf("deleting the current version = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ = do(t, http.MethodDelete, url+"?version=1", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("deleting version 1 = %d", resp.StatusCode)
+	}
+	if resp, _ = do(t, http.MethodDelete, url, nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE = %d", resp.StatusCode)
+	}
+	resp, body = do(t, http.MethodGet, url, nil)
+	if resp.StatusCode != http.StatusNotFound || errorCode(t, body) != "not_found" {
+		t.Errorf("GET after DELETE = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ = do(t, http.MethodDelete, url, nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE of a missing file = %d", resp.StatusCode)
+	}
+
+	resp, body = do(t, http.MethodPut, url+"?mode=mirror", v1)
+	if resp.StatusCode != http.StatusBadRequest || errorCode(t, body) != "invalid_request" {
+		t.Errorf("PUT with an unknown mode = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServer_List(t *testing.T) {
+	ts := setupServer(t)
+	names := []string{"a.txt", "docs/b.txt", "docs/c.txt", "docs/d/e.txt", "src/f.go"}
+	for _, name := range names {
+		if resp, body := do(t, http.MethodPut, ts.URL+"/files/"+name, []byte(name)); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("PUT %s = %d %s", name, resp.StatusCode, body)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(names) {
+			t.Fatal("listing does not end")
+		}
+		resp, body := do(t, http.MethodGet, ts.URL+"/files?prefix=docs/&limit=2&cursor="+cursor, nil)
+		var page ListResponse
+		if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &page) != nil {
+			t.Fatalf("list = %d %s", resp.StatusCode, body)
+		}
+		for _, f := range page.Files {
+			got = append(got, f.Name)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if fmt.Sprint(got) != fmt.Sprint(names[1:4]) {
+		t.Errorf("listing docs/ returned %v", got)
+	}
+
+	resp, body := do(t, http.MethodGet, ts.URL+"/files/", nil)
+	var all ListResponse
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &all) != nil || len(all.Files) != len(names) || all.NextCursor != "" {
+		t.Errorf("listing everything = %d %s", resp.StatusCode, body)
+	}
+	if resp, body = do(t, http.MethodGet, ts.URL+"/files?limit=x", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad limit = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestServer_StalledUpload(t *testing.T) {
+	ts := setupServer(t)
+	if resp, body := do(t, http.MethodPut, ts.URL+"/files/other.txt", []byte("other")); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT other.txt = %d %s", resp.StatusCode, body)
+	}
+
+	// A client that sends part of its body and then stalls
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+	put := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/files/slow.txt", pr)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			put <- 0
+			return
+		}
+		resp.Body.Close()
+		put <- resp.StatusCode
+	}()
+	pw.Write([]byte("first half, "))
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(ts.URL + "/files/other.txt")
+	if err != nil {
+		t.Fatalf("GET while an upload stalls: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "other" {
+		t.Errorf("GET while an upload stalls = %d %q", resp.StatusCode, data)
+	}
+
+	pw.Write([]byte("second half"))
+	pw.Close()
+	if code := <-put; code != http.StatusCreated {
+		t.Fatalf("stalled PUT = %d", code)
+	}
+	if resp, body := do(t, http.MethodGet, ts.URL+"/files/slow.txt", nil); string(body) != "first half, second half" {
+		t.Errorf("GET slow.txt = %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	ts := setupServer(t)
+	if resp, body := do(t, http.MethodPut, ts.URL+"/files/m.txt", []byte("measured")); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT = %d %s", resp.StatusCode, body)
+	}
+	resp, body := do(t, http.MethodGet, ts.URL+"/metrics", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /metrics = %d %s", resp.StatusCode, body)
+	}
+	for _, want := range []string{
+		`storagex_backend_chunks_total{backend="local:`,
+		`storagex_operation_duration_seconds_count{op="upload"}`,
+		"go_goroutines",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("metrics lack %s", want)
+		}
+	}
+}
+
+func TestStatusFor(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+	}{
+		{errorx.WrapWithDetails(errorx.ErrFileNotFound, "a.txt"), http.StatusNotFound},
+		{errorx.Wrap(errorx.ErrWriteQuorumNotMet, errorx.ErrLocalUpload), http.StatusServiceUnavailable},
+		{errorx.Wrap(errorx.ErrChunkCorrupted, context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{errorx.ErrDBQueryFailed, http.StatusInternalServerError},
+	} {
+		if status, _ := statusFor(tc.err); status != tc.status {
+			t.Errorf("statusFor(%v) = %d, want %d", tc.err, status, tc.status)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/compression"
+	"github.com/sayuyere/storageX/internal/encryption"
+	"github.com/sayuyere/storageX/internal/metadata"
+)
+
+// chunkCodec turns chunks into the objects stored on the backends and back again.
+// Chunk data is compressed first, then the serialized chunk is encrypted.
+type chunkCodec struct {
+	cipher      *encryption.Cipher // file data key; nil for unencrypted files
+	compression string             // codec for new chunks; reads use the codec in metadata
+}
+
+// objectName is the content address a chunk is stored under: the hex SHA-256 of its data,
+// or for encrypted files a hash keyed by the file's data key so names leak nothing
+func (cc chunkCodec) objectName(chunk chunker.Chunk) string {
+	if cc.cipher == nil {
+		return hex.EncodeToString(chunk.Checksum[:])
+	}
+	return cc.cipher.ObjectName(chunk.Checksum[:])
+}
+
+// seal serializes a chunk into the object to store and returns the codec actually used.
+// The header keeps the checksum and length of the uncompressed data.
+func (cc chunkCodec) seal(chunk chunker.Chunk) ([]byte, string, error) {
+	codec, data, err := compression.Compress(cc.compression, chunk.Data)
+	if err != nil {
+		return nil, "", err
+	}
+	chunk.Data = data
+	object := chunk.Bytes()
+	if cc.cipher == nil {
+		return object, codec, nil
+	}
+	object, err = cc.cipher.Seal(object)
+	if err != nil {
+		return nil, "", err
+	}
+	return object, codec, nil
+}
+
+// open turns a stored object back into the chunk data, verifying the header checksum,
+// length and index against metadata and the checksum of the decoded data. Any mismatch
+// is reported as a *CorruptChunkError.
+func (cc chunkCodec) open(meta metadata.ChunkMetadata, object []byte) ([]byte, error) {
+	corrupt := func(reason string, err error) error {
+		return &CorruptChunkError{ChunkName: meta.ChunkName, Reason: reason, Err: err}
+	}
+	if cc.cipher != nil {
+		plaintext, err := cc.cipher.Open(object)
+		if err != nil {
+			return nil, corrupt("decryption failed", err)
+		}
+		object = plaintext
+	}
+	chunk := chunker.ChunkFromBytes(object)
+	if chunk == nil {
+		return nil, corrupt("object shorter than the chunk header", nil)
+	}
+	if string(chunk.Checksum[:]) != meta.Checksum {
+		return nil, corrupt("header checksum does not match metadata", nil)
+	}
+	if chunk.N != uint64(meta.Size) {
+		return nil, corrupt(fmt.Sprintf("header length %d, metadata %d", chunk.N, meta.Size), nil)
+	}
+	if chunk.Index != uint64(meta.StoredIndex) {
+		return nil, corrupt(fmt.Sprintf("header index %d, metadata %d", chunk.Index, meta.StoredIndex), nil)
+	}
+	data, err := compression.Decompress(meta.Codec, chunk.Data, meta.Size)
+	if err != nil {
+		return nil, corrupt("decompression failed", err)
+	}
+	if sum := sha256.Sum256(data); string(sum[:]) != meta.Checksum {
+		return nil, corrupt("data checksum does not match metadata", nil)
+	}
+	return data, nil
+}
+
+// storedSize returns the object size of a chunk recorded before stored sizes were tracked
+func (cc chunkCodec) storedSize(meta metadata.ChunkMetadata) int64 {
+	if meta.StoredSize > 0 {
+		return meta.StoredSize
+	}
+	size := int64(chunker.ChunkMetadataSize) + meta.Size
+	if cc.cipher != nil {
+		size += encryption.Overhead
+	}
+	return size
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+)
+
+// Symlink modes for DirOptions.Symlinks
+const (
+	SymlinkSkip   = "skip"   // links are left out (default)
+	SymlinkFollow = "follow" // links are uploaded as their targets; directories seen before are not entered again
+)
+
+// DirOptions selects what a directory upload or download covers
+type DirOptions struct {
+	Upload   UploadOptions // how each file is stored
+	Prefix   string        // upload: logical directory the tree goes under; defaults to the local directory's name
+	Include  []string      // glob patterns a file must match, when any are given
+	Exclude  []string      // glob patterns of files and directories to leave out
+	Symlinks string        // upload: SymlinkSkip or SymlinkFollow
+	Resume   bool          // upload: finish interrupted uploads and skip files already stored
+}
+
+func (o DirOptions) validatePatterns() error {
+	for _, pattern := range append(append([]string{}, o.Include...), o.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errorx.WrapWithDetails(errorx.ErrInvalidPattern, pattern)
+		}
+	}
+	return nil
+}
+
+// selects reports whether the file at the relative path rel passes the patterns; a file
+// in an excluded directory is excluded too
+func (o DirOptions) selects(rel string) bool {
+	for dir := rel; dir != "."; dir = path.Dir(dir) {
+		if matchAny(o.Exclude, dir) {
+			return false
+		}
+	}
+	return len(o.Include) == 0 || matchAny(o.Include, rel)
+}
+
+// matchAny reports whether the slash-separated relative path rel matches one of patterns.
+// Patterns without a "/" match the base name at any depth, others the whole path.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanPath normalizes a logical file path: OS separators become "/", empty and "."
+// elements are dropped, and so is a leading "/". Paths containing ".." are rejected.
+func CleanPath(name string) (string, error) {
+	name = filepath.ToSlash(name)
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", errorx.WrapWithDetails(errorx.ErrInvalidPath, name)
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+name), "/"), nil
+}
+
+// UploadDirContext uploads every regular file below the local directory dirPath, stored
+// under opts.Prefix plus its path relative to dirPath. A failed file does not stop the
+// others; their errors are joined in the result. It returns the logical paths stored.
+func (s *StorageService) UploadDirContext(ctx context.Context, dirPath string, opts DirOptions) ([]string, error) {
+	if err := opts.Upload.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.validatePatterns(); err != nil {
+		return nil, err
+	}
+	switch opts.Symlinks {
+	case "", SymlinkSkip, SymlinkFollow:
+	default:
+		return nil, errorx.WrapWithDetails(errorx.ErrUnknownSymlinkMode, opts.Symlinks)
+	}
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errorx.WrapWithDetails(errorx.ErrInvalidPath, dirPath+" is not a directory")
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		abs, err := filepath.Abs(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		prefix = filepath.Base(abs)
+	}
+	if prefix, err = CleanPath(prefix); err != nil {
+		return nil, err
+	}
+
+	var (
+		stored []string
+		errs   []error
+	)
+	walkErr := walkTree(ctx, dirPath, "", opts, make(map[string]bool), func(localPath, rel string) error {
+		name := path.Join(prefix, rel)
+		skipped, err := s.uploadDirFile(ctx, localPath, name, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", localPath, err))
+		} else if !skipped {
+			stored = append(stored, name)
+		}
+		return nil
+	})
+	return stored, errors.Join(append(errs, walkErr)...)
+}
+
+// uploadDirFile uploads one file of a directory upload. With Resume, an open session is
+// finished and a file already stored is skipped, reported by skipped.
+func (s *StorageService) uploadDirFile(ctx context.Context, localPath, name string, opts DirOptions) (skipped bool, err error) {
+	if opts.Resume {
+		if _, ok := s.metaSvc.GetUploadSession(name); ok {
+			return false, s.resumeUpload(ctx, localPath, name)
+		}
+		exists, err := s.metaSvc.FileExists(name)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			log.Info("Skipping %s: already stored", name)
+			return true, nil
+		}
+	}
+	return false, s.uploadFile(ctx, localPath, name, opts.Upload)
+}
+
+// walkTree calls fn for every regular file below dir in lexical order, applying the
+// patterns and symlink mode of opts. rel is dir's path relative to the walk's root, and
+// visited holds the real paths of the directories entered so far.
+func walkTree(ctx context.Context, dir, rel string, opts DirOptions, visited map[string]bool, fn func(localPath, rel string) error) error {
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if visited[real] {
+			log.Info("Skipping %s: directory already uploaded", dir)
+			return nil
+		}
+		visited[real] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		localPath := filepath.Join(dir, entry.Name())
+		childRel := path.Join(rel, entry.Name())
+		mode := entry.Type()
+		if mode&fs.ModeSymlink != 0 {
+			if opts.Symlinks != SymlinkFollow {
+				log.Info("Skipping symlink %s", localPath)
+				continue
+			}
+			info, err := os.Stat(localPath)
+			if err != nil {
+				log.Info("Skipping broken symlink %s: %v", localPath, err)
+				continue
+			}
+			mode = info.Mode().Type()
+		}
+		switch {
+		case mode.IsDir():
+			if matchAny(opts.Exclude, childRel) {
+				continue
+			}
+			if err := walkTree(ctx, localPath, childRel, opts, visited, fn); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if !opts.selects(childRel) {
+				continue
+			}
+			if err := fn(localPath, childRel); err != nil {
+				return err
+			}
+		default:
+			log.Info("Skipping %s: not a regular file", localPath)
+		}
+	}
+	return nil
+}
+
+// DownloadDirContext writes every file below the logical directory dir into destDir,
+// recreating their relative paths; Include and Exclude in opts filter the files. A
+// failed file does not stop the others. It returns the local paths written.
+func (s *StorageService) DownloadDirContext(ctx context.Context, dir, destDir string, opts DirOptions) ([]string, error) {
+	if err := opts.validatePatterns(); err != nil {
+		return nil, err
+	}
+	dir, err := CleanPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.metaSvc.ListTree(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errorx.WrapWithDetails(errorx.ErrFileNotFound, dir+"/")
+	}
+
+	var (
+		written []string
+		errs    []error
+	)
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		rel := file.FileName
+		if dir != "" {
+			rel = strings.TrimPrefix(rel, dir+"/")
+		}
+		if !opts.selects(rel) {
+			continue
+		}
+		// Names are cleaned on upload, but never write outside destDir
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			errs = append(errs, errorx.WrapWithDetails(errorx.ErrInvalidPath, file.FileName))
+			continue
+		}
+		target := filepath.Join(destDir, filepath.FromSlash(rel))
+		if err := s.downloadTo(ctx, file.FileName, target); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.FileName, err))
+			continue
+		}
+		written = append(written, target)
+	}
+	return written, errors.Join(errs...)
+}
+
+// downloadTo writes a stored file to the local path target, creating its directory.
+// A partial file is removed on failure.
+func (s *StorageService) downloadTo(ctx context.Context, fileName, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if err := s.GetFileContext(ctx, fileName, out); err != nil {
+		out.Close()
+		os.Remove(target)
+		return err
+	}
+	return out.Close()
+}
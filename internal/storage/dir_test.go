@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// writeTree creates files (slash-separated path -> content) below a new directory
+func writeTree(t *testing.T, files map[string][]byte) string {
+	root := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	return root
+}
+
+func TestUploadDir_PreservesTree(t *testing.T) {
+	ss, _, metaSvc := setupErasureService(t, 3)
+	tree := map[string][]byte{
+		"config.json":     []byte(`{"top": true}`),
+		"a/config.json":   []byte(`{"nested": true}`),
+		"a/b/data.bin":    erasureTestData(),
+		"tmp/scratch.txt": []byte("scratch"),
+		"notes.log":       []byte("log line"),
+	}
+	root := writeTree(t, tree)
+	if err := os.Symlink(filepath.Join(root, "a"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "notes.log"), filepath.Join(root, "filelink")); err != nil {
+		t.Fatalf("symlink failed: %v", err)
+	}
+
+	// Both config.json files are stored, even with erasure chunk names derived from the path
+	ctx := context.Background()
+	opts := DirOptions{
+		Upload:  UploadOptions{Mode: ModeErasure, DataShards: 2, ParityShards: 1, Compression: "none"},
+		Prefix:  "proj",
+		Exclude: []string{"tmp", "*.log"},
+	}
+	stored, err := ss.UploadDirContext(ctx, root, opts)
+	if err != nil {
+		t.Fatalf("UploadDirContext failed: %v", err)
+	}
+	want := []string{"proj/a/b/data.bin", "proj/a/config.json", "proj/config.json"}
+	if !reflect.DeepEqual(stored, want) {
+		t.Fatalf("stored %v, want %v", stored, want)
+	}
+	files, subdirs, err := metaSvc.ListDir("proj")
+	if err != nil || len(files) != 1 || files[0].FileName != "proj/config.json" || !reflect.DeepEqual(subdirs, []string{"a"}) {
+		t.Errorf("ListDir(proj) = %v, %v, %v", files, subdirs, err)
+	}
+
+	out := t.TempDir()
+	written, err := ss.DownloadDirContext(ctx, "proj", out, DirOptions{})
+	if err != nil || len(written) != 3 {
+		t.Fatalf("DownloadDirContext wrote %v: %v", written, err)
+	}
+	for _, name := range []string{"config.json", "a/config.json", "a/b/data.bin"} {
+		got, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, tree[name]) {
+			t.Errorf("%s: downloaded content mismatch (err %v)", name, err)
+		}
+	}
+
+	// Again: existing files get a second version, unless resuming, which skips them
+	if stored, err := ss.UploadDirContext(ctx, root, opts); err != nil || len(stored) != 3 {
+		t.Errorf("second upload stored %v: %v", stored, err)
+	}
+	if file, _ := metaSvc.GetFile("proj/a/b/data.bin"); file.Version != 2 {
+		t.Errorf("re-uploaded file is at version %d, want 2", file.Version)
+	}
+	opts.Resume = true
+	if stored, err := ss.UploadDirContext(ctx, root, opts); err != nil || len(stored) != 0 {
+		t.Errorf("resumed upload stored %v: %v", stored, err)
+	}
+
+	// Followed links are uploaded as their targets; the linked directory is only walked once
+	stored, err = ss.UploadDirContext(ctx, root, DirOptions{
+		Prefix:   "follow",
+		Include:  []string{"*.json", "filelink"},
+		Symlinks: SymlinkFollow,
+		Upload:   opts.Upload,
+	})
+	want = []string{"follow/a/config.json", "follow/config.json", "follow/filelink"}
+	if err != nil || !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored %v (%v), want %v", stored, err, want)
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"a/b/c.txt":     "a/b/c.txt",
+		"/a//b/./c.txt": "a/b/c.txt",
+		"dir/":          "dir",
+		"":              "",
+	} {
+		if got, err := CleanPath(in); err != nil || got != want {
+			t.Errorf("CleanPath(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"..", "../etc/passwd", "a/../../b"} {
+		if _, err := CleanPath(in); !errors.Is(err, errorx.ErrInvalidPath) {
+			t.Errorf("CleanPath(%q): expected ErrInvalidPath, got %v", in, err)
+		}
+	}
+}
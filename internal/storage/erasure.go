@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+// Erasure-coded layout: every DataShards consecutive chunks form a stripe. Data chunks are
+// stored unchanged (so normal reads need no decoding); ParityShards parity objects are
+// computed over the serialized chunks zero-padded to the longest one. Each shard of a
+// stripe goes to a distinct backend, so any DataShards surviving shards rebuild the stripe.
+// A short final stripe treats its missing data shards as all-zero and does not store them.
+
+func stripeName(fileName string, stripe int) string {
+	return fileName + "-stripe-" + strconv.Itoa(stripe)
+}
+
+func parityName(fileName string, stripe, shard int) string {
+	return stripeName(fileName, stripe) + "-parity-" + strconv.Itoa(shard)
+}
+
+// uploadErasure groups the chunk stream into stripes and uploads them in parallel.
+// It returns the objects written so far, keyed by name, for rollback.
+func (s *StorageService) uploadErasure(ctx context.Context, fileName string, chunks <-chan chunker.Chunk, cc chunkCodec, dataShards, parityShards int) (map[string][]string, error) {
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidErasureLayout, err)
+	}
+
+	var (
+		uploaded    = make(map[string][]string)
+		errOnce     sync.Once
+		uploadErr   error
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		maxParallel = config.GetConfig().Parallel.Upload
+		sem         = make(chan struct{}, maxParallel)
+		stripe      = make([]chunker.Chunk, 0, dataShards)
+	)
+	defer drain(chunks)
+
+	// Stripes are numbered by position, so a resumed upload that skips whole stripes keeps
+	// the layout
+	flush := func() bool {
+		if !acquire(ctx, sem) {
+			errOnce.Do(func() { uploadErr = ctx.Err() })
+			return false
+		}
+		group, idx := stripe, int(stripe[0].Index)/dataShards
+		stripe = make([]chunker.Chunk, 0, dataShards)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer metrics.TrackWorker(metrics.PoolUpload)()
+			written, err := s.uploadStripe(ctx, enc, cc, fileName, idx, group, dataShards, parityShards)
+			mu.Lock()
+			for name, replicas := range written {
+				uploaded[name] = replicas
+			}
+			mu.Unlock()
+			if err != nil {
+				errOnce.Do(func() { uploadErr = err })
+			}
+		}()
+		return true
+	}
+
+	failed := false
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			errOnce.Do(func() { uploadErr = chunk.Err })
+			failed = true
+			break
+		}
+		// Erasure-coded chunks are named after the version's storage name and their index,
+		// not their content, so they are never shared; a name already recorded means the
+		// metadata is inconsistent
+		if exists, _ := s.metaSvc.ChunkExists(chunk.Name); exists {
+			errOnce.Do(func() {
+				uploadErr = errorx.WrapWithDetails(errorx.ErrChunkAlreadyExists, chunk.Name)
+			})
+			failed = true
+			break
+		}
+		stripe = append(stripe, chunk)
+		if len(stripe) == dataShards && !flush() {
+			break
+		}
+	}
+	if !failed && len(stripe) > 0 {
+		flush()
+	}
+	wg.Wait()
+	return uploaded, uploadErr
+}
+
+// uploadStripe encodes one stripe, writes its shards to distinct backends and records metadata
+func (s *StorageService) uploadStripe(ctx context.Context, enc reedsolomon.Encoder, cc chunkCodec, fileName string, stripeIdx int, group []chunker.Chunk, dataShards, parityShards int) (map[string][]string, error) {
+	total := dataShards + parityShards
+	blobs := make([][]byte, len(group))
+	codecs := make([]string, len(group))
+	shardSize := 0
+	for i := range group {
+		blob, codec, err := cc.seal(group[i])
+		if err != nil {
+			return nil, err
+		}
+		blobs[i], codecs[i] = blob, codec
+		if len(blobs[i]) > shardSize {
+			shardSize = len(blobs[i])
+		}
+	}
+
+	shards := make([][]byte, total)
+	for i := range shards {
+		shards[i] = make([]byte, shardSize)
+		if i < len(blobs) {
+			copy(shards[i], blobs[i])
+		}
+	}
+	if err := enc.Encode(shards); err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidErasureLayout, err)
+	}
+
+	targets := s.manager.GetCloudSvcsForChunkContext(ctx, stripeName(fileName, stripeIdx), int64(shardSize), total)
+	if len(targets) < total {
+		return nil, errorx.WrapWithDetails(errorx.ErrNotEnoughBackends,
+			fmt.Sprintf("erasure coding needs %d distinct backends, have %d", total, len(targets)))
+	}
+
+	// Data chunks are stored as-is; padding only exists for the parity computation
+	names := make([]string, total)
+	objects := make([][]byte, total)
+	for i := range group {
+		names[i] = group[i].Name
+		objects[i] = blobs[i]
+	}
+	for j := 0; j < parityShards; j++ {
+		names[dataShards+j] = parityName(fileName, stripeIdx, j)
+		objects[dataShards+j] = shards[dataShards+j]
+	}
+	if err := s.manager.UploadShardsContext(ctx, names, objects, targets); err != nil {
+		return nil, err
+	}
+
+	written := make(map[string][]string)
+	for i, obj := range objects {
+		if obj != nil {
+			written[names[i]] = []string{targets[i].StorageSystemID()}
+		}
+	}
+
+	for i, chunk := range group {
+		err := s.metaSvc.AddChunk(fileName, metadata.ChunkMetadata{
+			ChunkName:  chunk.Name,
+			Size:       int64(len(chunk.Data)),
+			Checksum:   string(chunk.Checksum[:]),
+			Index:      int(chunk.Index),
+			FileName:   fileName,
+			Storage:    targets[i].StorageSystemID(),
+			Codec:      codecs[i],
+			StoredSize: int64(len(blobs[i])),
+		})
+		if err != nil {
+			return written, err
+		}
+	}
+	stripeMeta := metadata.StripeMetadata{
+		FileName:     fileName,
+		Stripe:       stripeIdx,
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		ShardSize:    int64(shardSize),
+		DataCount:    len(group),
+	}
+	for j := 0; j < parityShards; j++ {
+		sum := sha256.Sum256(shards[dataShards+j])
+		stripeMeta.Parity = append(stripeMeta.Parity, metadata.ShardMetadata{
+			ShardName: names[dataShards+j],
+			Shard:     j,
+			Checksum:  string(sum[:]),
+			Storage:   targets[dataShards+j].StorageSystemID(),
+		})
+	}
+	return written, s.metaSvc.AddStripe(fileName, stripeMeta)
+}
+
+// stripeIndex maps chunk indices to the stripe protecting them
+type stripeIndex struct {
+	dataShards int
+	codec      chunkCodec
+	stripes    map[int]metadata.StripeMetadata
+	chunks     map[int]metadata.ChunkMetadata
+}
+
+func newStripeIndex(stripes []metadata.StripeMetadata, metas []metadata.ChunkMetadata, cc chunkCodec) *stripeIndex {
+	if len(stripes) == 0 {
+		return nil
+	}
+	idx := &stripeIndex{
+		dataShards: stripes[0].DataShards,
+		codec:      cc,
+		stripes:    make(map[int]metadata.StripeMetadata, len(stripes)),
+		chunks:     make(map[int]metadata.ChunkMetadata, len(metas)),
+	}
+	for _, st := range stripes {
+		idx.stripes[st.Stripe] = st
+	}
+	for _, meta := range metas {
+		idx.chunks[meta.Index] = meta
+	}
+	return idx
+}
+
+// lookup returns the stripe holding chunk index i
+func (si *stripeIndex) lookup(i int) (metadata.StripeMetadata, bool) {
+	if si == nil || si.dataShards < 1 {
+		return metadata.StripeMetadata{}, false
+	}
+	st, ok := si.stripes[i/si.dataShards]
+	return st, ok
+}
+
+// dataShard downloads a data chunk of a stripe from the first replica that verifies, so a
+// silently corrupted copy never takes part in a reconstruction
+func (s *StorageService) dataShard(ctx context.Context, cc chunkCodec, meta metadata.ChunkMetadata) ([]byte, bool) {
+	for _, id := range meta.Replicas {
+		object, err := s.manager.GetChunkContext(ctx, id, meta.ChunkName)
+		if err != nil {
+			continue
+		}
+		if _, err := cc.open(meta, object); err != nil {
+			log.Error("data shard %s on %s: %v", meta.ChunkName, id, err)
+			metrics.ReplicaFailure(id, "get")
+			continue
+		}
+		return object, true
+	}
+	return nil, false
+}
+
+// reconstructChunk rebuilds the serialized chunk at index from any DataShards surviving
+// shards of its stripe. Data shards are verified like reads, parity shards against their
+// recorded checksum; shards that fail are skipped.
+func (s *StorageService) reconstructChunk(ctx context.Context, si *stripeIndex, index int) ([]byte, error) {
+	st, ok := si.lookup(index)
+	if !ok {
+		return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable, fmt.Sprintf("chunk %d has no stripe", index))
+	}
+	log.Info("Reconstructing chunk %d from stripe %d", index, st.Stripe)
+	enc, err := reedsolomon.New(st.DataShards, st.ParityShards)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidErasureLayout, err)
+	}
+
+	pad := func(b []byte) []byte {
+		shard := make([]byte, st.ShardSize)
+		copy(shard, b)
+		return shard
+	}
+	shards := make([][]byte, st.DataShards+st.ParityShards)
+	have := 0
+	first := st.Stripe * st.DataShards
+	target := index - first
+	for pos := 0; pos < st.DataShards && have < st.DataShards; pos++ {
+		if pos >= st.DataCount {
+			// Missing data shards of a short stripe are known zeros
+			shards[pos] = make([]byte, st.ShardSize)
+			have++
+			continue
+		}
+		if pos == target {
+			continue
+		}
+		meta, ok := si.chunks[first+pos]
+		if !ok {
+			continue
+		}
+		object, ok := s.dataShard(ctx, si.codec, meta)
+		if !ok {
+			continue
+		}
+		shards[pos] = pad(object)
+		have++
+	}
+	for _, parity := range st.Parity {
+		if have >= st.DataShards {
+			break
+		}
+		data, err := s.manager.GetChunkContext(ctx, parity.Storage, parity.ShardName)
+		if err != nil || int64(len(data)) != st.ShardSize {
+			continue
+		}
+		if sum := sha256.Sum256(data); string(sum[:]) != parity.Checksum {
+			log.Error("parity shard %s on %s does not match its checksum", parity.ShardName, parity.Storage)
+			metrics.ReplicaFailure(parity.Storage, "get")
+			continue
+		}
+		shards[st.DataShards+parity.Shard] = data
+		have++
+	}
+	if have < st.DataShards {
+		return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable,
+			fmt.Sprintf("stripe %d: %d of %d shards available", st.Stripe, have, st.DataShards))
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, errorx.Wrap(errorx.ErrStripeUnrecoverable, err)
+	}
+
+	// Trim the zero padding back to the stored object
+	blob := shards[target]
+	meta, ok := si.chunks[index]
+	if !ok {
+		return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable, fmt.Sprintf("chunk %d has no metadata", index))
+	}
+	end := si.codec.storedSize(meta)
+	if end > int64(len(blob)) {
+		return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable, "reconstructed chunk size is invalid")
+	}
+	return blob[:end], nil
+}
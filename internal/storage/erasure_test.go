@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/encryption"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/manager"
+	"github.com/sayuyere/storageX/internal/metadata"
+)
+
+func setupErasureService(t *testing.T, backends int) (*StorageService, []*mockCloudStorage, *metadata.MetadataService) {
+	dbPath := filepath.Join(t.TempDir(), "erasure.db")
+	metaSvc, err := metadata.NewMetadataService(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	var (
+		mocks []*mockCloudStorage
+		svcs  []cloud.CloudStorage
+	)
+	for i := 0; i < backends; i++ {
+		m := &mockCloudStorage{id: "backend" + strconv.Itoa(i), chunks: make(map[string][]byte)}
+		mocks = append(mocks, m)
+		svcs = append(svcs, m)
+	}
+	mgr := manager.NewStorageManager(svcs)
+	return NewStorageService(mgr, metaSvc, chunker.NewFileChunker(DefaultChunkSize)), mocks, metaSvc
+}
+
+func writeTempFile(t *testing.T, data []byte) string {
+	path := filepath.Join(t.TempDir(), "erasure-test.bin")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	return path
+}
+
+func erasureTestData() []byte {
+	var data []byte
+	for i := 0; i < 7; i++ {
+		// 7 chunks of 16 bytes with a short last one: two full stripes of 3 plus a short stripe
+		data = append(data, bytes.Repeat([]byte{byte('a' + i)}, 16)...)
+	}
+	return data[:len(data)-5]
+}
+
+func TestUploadFile_ErasureSurvivesParityLosses(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 5)
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+
+	stripes, err := metaSvc.ListStripes(filepath.Base(path))
+	if err != nil || len(stripes) != 3 {
+		t.Fatalf("expected 3 stripes, got %d (%v)", len(stripes), err)
+	}
+	if stripes[2].DataCount != 1 || len(stripes[2].Parity) != 2 {
+		t.Errorf("unexpected short stripe layout: %+v", stripes[2])
+	}
+
+	// Losing any two backends must still allow a full read
+	for a := 0; a < len(mocks); a++ {
+		for b := a + 1; b < len(mocks); b++ {
+			mocks[a].failGet, mocks[b].failGet = true, true
+			var buf bytes.Buffer
+			if err := ss.GetFile(filepath.Base(path), &buf); err != nil {
+				t.Fatalf("GetFile failed with backends %d,%d down: %v", a, b, err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Fatalf("data mismatch with backends %d,%d down", a, b)
+			}
+			mocks[a].failGet, mocks[b].failGet = false, false
+		}
+	}
+
+	// Three lost shards exceed the parity
+	mocks[0].failGet, mocks[1].failGet, mocks[2].failGet = true, true, true
+	var buf bytes.Buffer
+	if err := ss.GetFile(filepath.Base(path), &buf); !errors.Is(err, errorx.ErrStripeUnrecoverable) {
+		t.Errorf("expected ErrStripeUnrecoverable, got %v", err)
+	}
+}
+
+func TestDeleteFile_ErasureRemovesParity(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 4)
+	path := writeTempFile(t, erasureTestData())
+	if err := ss.UploadFileWithOptions(path, UploadOptions{Mode: ModeErasure, DataShards: 2, ParityShards: 2}); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	if err := ss.DeleteFile(filepath.Base(path)); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	for _, m := range mocks {
+		if len(m.chunks) != 0 {
+			t.Errorf("%s still holds %d objects after delete", m.id, len(m.chunks))
+		}
+	}
+}
+
+func TestUploadFile_ErasureNeedsDistinctBackends(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 3)
+	path := writeTempFile(t, erasureTestData())
+	err := ss.UploadFileWithOptions(path, UploadOptions{Mode: ModeErasure, DataShards: 2, ParityShards: 2})
+	if !errors.Is(err, errorx.ErrNotEnoughBackends) {
+		t.Fatalf("expected ErrNotEnoughBackends, got %v", err)
+	}
+	if ok, _ := metaSvc.FileExists(filepath.Base(path)); ok {
+		t.Error("file metadata left behind after failed erasure upload")
+	}
+	for _, m := range mocks {
+		if len(m.chunks) != 0 {
+			t.Errorf("%s holds %d objects after rollback", m.id, len(m.chunks))
+		}
+	}
+}
+
+func TestUploadOptions_Validate(t *testing.T) {
+	if err := (UploadOptions{Mode: "mirror"}).validate(); !errors.Is(err, errorx.ErrUnknownStorageMode) {
+		t.Errorf("expected ErrUnknownStorageMode, got %v", err)
+	}
+	if err := (UploadOptions{Mode: ModeErasure, DataShards: 4}).validate(); !errors.Is(err, errorx.ErrInvalidErasureLayout) {
+		t.Errorf("expected ErrInvalidErasureLayout, got %v", err)
+	}
+}
+
+func TestUploadFile_ErasureEncrypted(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 5)
+	ss.SetMasterKey(bytes.Repeat([]byte{0x42}, encryption.KeySize))
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+
+	// Reconstruction works on ciphertext and must still trim to the sealed object
+	mocks[0].failGet, mocks[1].failGet = true, true
+	var buf bytes.Buffer
+	if err := ss.GetFile(filepath.Base(path), &buf); err != nil {
+		t.Fatalf("GetFile failed with two backends down: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("data mismatch after reconstructing encrypted chunks")
+	}
+}
+
+func TestUploadFile_ErasureCompressedEncrypted(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 5)
+	ss.SetMasterKey(bytes.Repeat([]byte{0x42}, encryption.KeySize))
+	// Mix compressible and incompressible chunks so stored sizes differ within a stripe
+	data := append(bytes.Repeat([]byte{'z'}, 64), erasureTestData()...)
+	path := writeTempFile(t, data)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2, Compression: "auto"}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+
+	mocks[0].failGet, mocks[2].failGet = true, true
+	var buf bytes.Buffer
+	if err := ss.GetFile(filepath.Base(path), &buf); err != nil {
+		t.Fatalf("GetFile failed with two backends down: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("data mismatch after reconstructing compressed chunks")
+	}
+}
+
+func TestGetFile_ErasureRebuildsCorruptChunk(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 5)
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+
+	chunks, _ := metaSvc.ListChunks(filepath.Base(path))
+	for _, m := range mocks {
+		if object, ok := m.chunks[chunks[1].ChunkName]; ok {
+			object[len(object)-1] ^= 0x01
+		}
+	}
+	var buf bytes.Buffer
+	if err := ss.GetFile(filepath.Base(path), &buf); err != nil {
+		t.Fatalf("GetFile failed with a corrupt data shard: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("data mismatch after rebuilding corrupt chunk")
+	}
+}
+
+func TestUploadFile_ErasureDuplicateContent(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 5)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	// Repeated blocks inside the file, the same content in another file and again as a
+	// second version: erasure-coded chunks are not shared, so every upload stores its own
+	data := bytes.Repeat([]byte("x"), 16*7)
+	first := writeTempFile(t, data)
+	second := filepath.Join(t.TempDir(), "copy.bin")
+	if err := os.WriteFile(second, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{first, second, first} {
+		if err := ss.UploadFileWithOptions(path, opts); err != nil {
+			t.Fatalf("uploading %s failed: %v", path, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, ver := range []struct {
+		path    string
+		version int
+	}{{first, 1}, {second, 1}, {first, 2}} {
+		fileName := filepath.Base(ver.path)
+		if got := readVersion(t, ss, fileName, ver.version); !bytes.Equal(got, data) {
+			t.Errorf("%s version %d content mismatch", fileName, ver.version)
+		}
+		v, _ := metaSvc.GetVersion(fileName, ver.version)
+		chunks, _ := metaSvc.ListChunks(v.StorageName)
+		for _, c := range chunks {
+			if seen[c.ChunkName] {
+				t.Errorf("erasure-coded chunk %s is shared", c.ChunkName)
+			}
+			seen[c.ChunkName] = true
+		}
+	}
+
+	// Each copy survives the loss of two backends from its own stripes
+	mocks[0].failGet, mocks[1].failGet = true, true
+	if got := readVersion(t, ss, filepath.Base(second), 0); !bytes.Equal(got, data) {
+		t.Error("content mismatch after losing two backends")
+	}
+}
+
+func TestGetFile_ErasureSkipsCorruptShards(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 5)
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	fileName := filepath.Base(path)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	chunks, _ := metaSvc.ListChunks(fileName)
+	stripes, _ := metaSvc.ListStripes(fileName)
+	flip := func(name string) {
+		for _, m := range mocks {
+			if object, ok := m.chunks[name]; ok {
+				object[len(object)-1] ^= 0x01
+			}
+		}
+	}
+
+	// Chunk 1 is lost and parity 0 silently corrupt: data 0, data 2 and parity 1 remain
+	flip(chunks[1].ChunkName)
+	flip(stripes[0].Parity[0].ShardName)
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, data) {
+		t.Error("content mismatch with a corrupt parity shard")
+	}
+
+	// Chunk 0 silently corrupt as well: data 2 and parity 1 are not enough
+	flip(chunks[0].ChunkName)
+	var buf bytes.Buffer
+	if err := ss.GetFile(fileName, &buf); !errors.Is(err, errorx.ErrChunkCorrupted) && !errors.Is(err, errorx.ErrStripeUnrecoverable) {
+		t.Errorf("expected an unrecoverable stripe, got %v", err)
+	}
+	flip(stripes[0].Parity[0].ShardName)
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, data) {
+		t.Error("content mismatch with a corrupt data shard")
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+// CorruptChunkError reports a stored copy of a chunk that does not match its metadata.
+// It matches errorx.ErrChunkCorrupted and, when set, the underlying decode error.
+type CorruptChunkError struct {
+	ChunkName string
+	Storage   string // storage system the copy came from; empty for reconstructed data
+	Reason    string
+	Err       error
+}
+
+func (e *CorruptChunkError) Error() string {
+	msg := errorx.ErrChunkCorrupted.Error() + ": " + e.ChunkName
+	if e.Storage != "" {
+		msg += " from " + e.Storage
+	}
+	msg += ": " + e.Reason
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *CorruptChunkError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{errorx.ErrChunkCorrupted}
+	}
+	return []error{errorx.ErrChunkCorrupted, e.Err}
+}
+
+// fetchChunk downloads and verifies one chunk. Replicas are tried in order, skipping
+// copies that cannot be read or fail verification; erasure-coded chunks are rebuilt
+// from their stripe when no copy is usable. Nothing more is tried once ctx is done.
+func (s *StorageService) fetchChunk(ctx context.Context, meta metadata.ChunkMetadata, cc chunkCodec, si *stripeIndex) (data []byte, err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("read_chunk", int64(len(data)), start, err) }(time.Now())
+	log.Info("Retrieving chunk: %s", meta.ChunkName)
+	var lastErr error = errorx.ErrStorageNotFound
+	for _, id := range meta.Replicas {
+		object, err := s.manager.GetChunkContext(ctx, id, meta.ChunkName)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Error("replica read of %s from %s failed: %v", meta.ChunkName, id, err)
+			metrics.ReplicaFailure(id, "get")
+			lastErr = err
+			continue
+		}
+		data, err := cc.open(meta, object)
+		if err == nil {
+			return data, nil
+		}
+		var corrupt *CorruptChunkError
+		if errors.As(err, &corrupt) {
+			corrupt.Storage = id
+		}
+		log.Error("%v", err)
+		metrics.ReplicaFailure(id, "get")
+		lastErr = err
+	}
+	if si == nil {
+		return nil, lastErr
+	}
+	metrics.Reconstruction()
+	object, err := s.reconstructChunk(ctx, si, meta.Index)
+	if err != nil {
+		return nil, err
+	}
+	return cc.open(meta, object)
+}
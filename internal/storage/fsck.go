@@ -0,0 +1,353 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/klauspost/reedsolomon"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+)
+
+// Kinds of problems reported by Fsck
+const (
+	IssueMissingChunk   = "missing_chunk"   // a chunk index of the version has no chunk
+	IssueSizeMismatch   = "size_mismatch"   // the recorded size is not the sum of the chunk sizes
+	IssueMissingStripe  = "missing_stripe"  // an erasure-coded chunk belongs to no stripe
+	IssueUnknownStorage = "unknown_storage" // a copy is recorded on a storage system not configured
+	IssueMissingObject  = "missing_object"  // deep: a copy could not be downloaded
+	IssueCorruptObject  = "corrupt_object"  // deep: a copy failed verification
+)
+
+// FsckOptions controls a consistency check
+type FsckOptions struct {
+	// Deep downloads every copy of every chunk and parity shard and verifies it against
+	// its checksum in metadata
+	Deep bool
+	// Repair rewrites missing and corrupt copies from a good replica, or rebuilds them from
+	// their stripe; it implies Deep
+	Repair bool
+}
+
+// FsckIssue is one problem found by Fsck
+type FsckIssue struct {
+	Kind     string `json:"kind"`
+	File     string `json:"file"`
+	Version  int    `json:"version"`
+	Object   string `json:"object,omitempty"`
+	Storage  string `json:"storage,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckReport is the outcome of Fsck, meant to be encoded as JSON
+type FsckReport struct {
+	Files    int         `json:"files"`
+	Versions int         `json:"versions"`
+	Objects  int         `json:"objects"`  // distinct chunks and parity shards checked
+	Verified int         `json:"verified"` // deep: copies downloaded and found intact
+	Issues   []FsckIssue `json:"issues"`
+}
+
+// Unresolved counts the issues not repaired
+func (r *FsckReport) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks every finished version of every file: chunk indices without gaps, sizes
+// adding up, stripes and storage systems that exist, and with opts.Deep the stored
+// objects themselves. Unfinished uploads are not checked.
+func (s *StorageService) Fsck(opts FsckOptions) (*FsckReport, error) {
+	return s.FsckContext(context.Background(), opts)
+}
+
+// FsckContext is Fsck bounded by ctx; the report so far is returned when ctx ends
+func (s *StorageService) FsckContext(ctx context.Context, opts FsckOptions) (*FsckReport, error) {
+	opts.Deep = opts.Deep || opts.Repair
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	report := &FsckReport{Issues: []FsckIssue{}}
+	files, err := s.metaSvc.ListFiles()
+	if err != nil {
+		return report, err
+	}
+	// Deduplicated objects are shared between files and versions and checked once
+	checked := make(map[string]bool)
+	for _, file := range files {
+		versions, err := s.metaSvc.ListVersions(file.FileName)
+		if err != nil {
+			return report, err
+		}
+		report.Files++
+		for _, ver := range versions {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			report.Versions++
+			if err := s.fsckVersion(ctx, ver, opts, report, checked); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, ctx.Err()
+}
+
+// FsckFileContext runs the checks of FsckContext on one version of fileName; version 0 is
+// the current one
+func (s *StorageService) FsckFileContext(ctx context.Context, fileName string, version int, opts FsckOptions) (*FsckReport, error) {
+	opts.Deep = opts.Deep || opts.Repair
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	report := &FsckReport{Issues: []FsckIssue{}}
+	if _, ok := s.metaSvc.GetFile(fileName); !ok {
+		return report, errorx.WrapWithDetails(errorx.ErrFileNotFound, fileName)
+	}
+	ver, ok := s.metaSvc.GetVersion(fileName, version)
+	if !ok {
+		return report, errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, version))
+	}
+	report.Files, report.Versions = 1, 1
+	if err := s.fsckVersion(ctx, ver, opts, report, make(map[string]bool)); err != nil {
+		return report, err
+	}
+	return report, ctx.Err()
+}
+
+// fsck carries the state of checking one file version
+type fsck struct {
+	s      *StorageService
+	ctx    context.Context
+	opts   FsckOptions
+	ver    metadata.FileVersion
+	cc     chunkCodec
+	si     *stripeIndex
+	report *FsckReport
+}
+
+func (f *fsck) add(kind, object, storage, detail string, repaired bool) {
+	f.report.Issues = append(f.report.Issues, FsckIssue{
+		Kind:     kind,
+		File:     f.ver.FileName,
+		Version:  f.ver.Version,
+		Object:   object,
+		Storage:  storage,
+		Detail:   detail,
+		Repaired: repaired,
+	})
+}
+
+func (s *StorageService) fsckVersion(ctx context.Context, ver metadata.FileVersion, opts FsckOptions, report *FsckReport, checked map[string]bool) error {
+	metas, err := s.metaSvc.ListChunks(ver.StorageName)
+	if err != nil {
+		return err
+	}
+	stripes, err := s.metaSvc.ListStripes(ver.StorageName)
+	if err != nil {
+		return err
+	}
+	fileCipher, err := s.versionCipher(ver)
+	if err != nil {
+		return err
+	}
+	cc := chunkCodec{cipher: fileCipher}
+	f := &fsck{s: s, ctx: ctx, opts: opts, ver: ver, cc: cc, si: newStripeIndex(stripes, metas, cc), report: report}
+
+	var (
+		total int64
+		next  int
+	)
+	for _, meta := range metas {
+		for ; next < meta.Index; next++ {
+			f.add(IssueMissingChunk, "", "", fmt.Sprintf("no chunk at index %d", next), false)
+		}
+		next = meta.Index + 1
+		total += meta.Size
+		if f.si != nil {
+			if _, ok := f.si.lookup(meta.Index); !ok {
+				f.add(IssueMissingStripe, meta.ChunkName, "", fmt.Sprintf("chunk %d has no stripe", meta.Index), false)
+			}
+		}
+	}
+	if total != ver.TotalSize {
+		f.add(IssueSizeMismatch, "", "", fmt.Sprintf("recorded %d bytes, chunks hold %d", ver.TotalSize, total), false)
+	}
+
+	for _, meta := range metas {
+		if checked[meta.ChunkName] {
+			continue
+		}
+		checked[meta.ChunkName] = true
+		report.Objects++
+		if err := f.checkChunk(meta); err != nil {
+			return err
+		}
+	}
+	for _, st := range stripes {
+		for _, parity := range st.Parity {
+			report.Objects++
+			if err := f.checkParity(st, parity); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// badCopy is a copy of an object that failed a deep check
+type badCopy struct {
+	storage string
+	kind    string
+	detail  string
+}
+
+// knownCopies reports the locations of an object that are not configured and returns the rest
+func (f *fsck) knownCopies(object string, ids []string) []string {
+	var known []string
+	for _, id := range ids {
+		if f.s.manager.SearchStorageID(id) == nil {
+			f.add(IssueUnknownStorage, object, id, "storage system is not configured", false)
+			continue
+		}
+		known = append(known, id)
+	}
+	return known
+}
+
+// checkChunk downloads and verifies each copy of a chunk, repairing bad ones when asked
+func (f *fsck) checkChunk(meta metadata.ChunkMetadata) error {
+	replicas := f.knownCopies(meta.ChunkName, meta.Replicas)
+	if !f.opts.Deep {
+		return nil
+	}
+	var (
+		good []byte
+		bad  []badCopy
+	)
+	for _, id := range replicas {
+		object, err := f.s.manager.GetChunkContext(f.ctx, id, meta.ChunkName)
+		if f.ctx.Err() != nil {
+			return f.ctx.Err()
+		}
+		if err != nil {
+			bad = append(bad, badCopy{id, IssueMissingObject, err.Error()})
+			continue
+		}
+		if _, err := f.cc.open(meta, object); err != nil {
+			bad = append(bad, badCopy{id, IssueCorruptObject, err.Error()})
+			continue
+		}
+		f.report.Verified++
+		good = object
+	}
+	if len(bad) == 0 {
+		return nil
+	}
+	if f.opts.Repair && good == nil && f.si != nil {
+		if object, err := f.s.reconstructChunk(f.ctx, f.si, meta.Index); err == nil {
+			if _, err := f.cc.open(meta, object); err == nil {
+				good = object
+			}
+		}
+	}
+	f.repair(meta.ChunkName, bad, good)
+	return f.ctx.Err()
+}
+
+// checkParity downloads and verifies a parity shard, recomputing it from the stripe's
+// data chunks when asked to repair
+func (f *fsck) checkParity(st metadata.StripeMetadata, parity metadata.ShardMetadata) error {
+	if len(f.knownCopies(parity.ShardName, []string{parity.Storage})) == 0 || !f.opts.Deep {
+		return nil
+	}
+	object, err := f.s.manager.GetChunkContext(f.ctx, parity.Storage, parity.ShardName)
+	if f.ctx.Err() != nil {
+		return f.ctx.Err()
+	}
+	var bad badCopy
+	if err != nil {
+		bad = badCopy{parity.Storage, IssueMissingObject, err.Error()}
+	} else if sum := sha256.Sum256(object); string(sum[:]) != parity.Checksum {
+		bad = badCopy{parity.Storage, IssueCorruptObject, "parity checksum does not match metadata"}
+	} else {
+		f.report.Verified++
+		return nil
+	}
+	var good []byte
+	if f.opts.Repair {
+		if shard, err := f.rebuildParity(st, parity.Shard); err == nil {
+			good = shard
+		} else {
+			bad.detail += "; " + err.Error()
+		}
+	}
+	f.repair(parity.ShardName, []badCopy{bad}, good)
+	return f.ctx.Err()
+}
+
+// rebuildParity recomputes one parity shard from the verified data chunks of its stripe
+func (f *fsck) rebuildParity(st metadata.StripeMetadata, shard int) ([]byte, error) {
+	enc, err := reedsolomon.New(st.DataShards, st.ParityShards)
+	if err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidErasureLayout, err)
+	}
+	shards := make([][]byte, st.DataShards+st.ParityShards)
+	for pos := range shards {
+		shards[pos] = make([]byte, st.ShardSize)
+		if pos >= st.DataCount || pos >= st.DataShards {
+			continue
+		}
+		meta, ok := f.si.chunks[st.Stripe*st.DataShards+pos]
+		if !ok {
+			return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable, fmt.Sprintf("stripe %d: chunk %d has no metadata", st.Stripe, pos))
+		}
+		object, err := f.s.manager.GetChunkReplicasContext(f.ctx, meta.Replicas, meta.ChunkName)
+		if err == nil {
+			_, err = f.cc.open(meta, object)
+		}
+		if err != nil {
+			return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable, fmt.Sprintf("stripe %d: %v", st.Stripe, err))
+		}
+		copy(shards[pos], object)
+	}
+	if err := enc.Encode(shards); err != nil {
+		return nil, errorx.Wrap(errorx.ErrInvalidErasureLayout, err)
+	}
+	parity := shards[st.DataShards+shard]
+	for _, p := range st.Parity {
+		if sum := sha256.Sum256(parity); p.Shard == shard && string(sum[:]) != p.Checksum {
+			return nil, errorx.WrapWithDetails(errorx.ErrStripeUnrecoverable, fmt.Sprintf("stripe %d: recomputed parity does not match metadata", st.Stripe))
+		}
+	}
+	return parity, nil
+}
+
+// repair reports the bad copies of an object, first writing good over them when there is one
+func (f *fsck) repair(object string, bad []badCopy, good []byte) {
+	for _, c := range bad {
+		repaired := false
+		if f.opts.Repair && good == nil {
+			c.detail += "; no intact copy to repair from"
+		} else if f.opts.Repair {
+			backend := f.s.manager.SearchStorageID(c.storage)
+			if err := cloud.UploadChunk(f.ctx, backend, object, good); err != nil {
+				c.detail += "; repair failed: " + err.Error()
+			} else {
+				log.Info("Repaired %s on %s", object, c.storage)
+				repaired = true
+			}
+		}
+		f.add(c.kind, object, c.storage, c.detail, repaired)
+	}
+}
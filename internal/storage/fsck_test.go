@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/metadata"
+)
+
+func issueKinds(report *FsckReport) map[string]int {
+	kinds := make(map[string]int)
+	for _, issue := range report.Issues {
+		kinds[issue.Kind]++
+	}
+	return kinds
+}
+
+func runFsck(t *testing.T, ss *StorageService, opts FsckOptions) *FsckReport {
+	t.Helper()
+	report, err := ss.Fsck(opts)
+	if err != nil {
+		t.Fatalf("Fsck failed: %v", err)
+	}
+	return report
+}
+
+func TestFsck_ReplicatedRepair(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 2)
+	ss.manager.SetReplication(2, 2)
+	data := resumeTestData()
+	path := writeTempFile(t, data)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+	if report := runFsck(t, ss, FsckOptions{Deep: true}); len(report.Issues) != 0 || report.Verified != 2*report.Objects {
+		t.Fatalf("clean file reported %+v", report)
+	}
+
+	chunks, _ := metaSvc.ListChunks(fileName)
+	corrupted, lost := chunks[0].ChunkName, chunks[1].ChunkName
+	mocks[0].chunks[corrupted] = append(bytes.Clone(mocks[0].chunks[corrupted][:20]), 'x')
+	delete(mocks[1].chunks, lost)
+
+	if report := runFsck(t, ss, FsckOptions{}); len(report.Issues) != 0 {
+		t.Errorf("metadata check downloaded objects: %+v", report.Issues)
+	}
+	report := runFsck(t, ss, FsckOptions{Deep: true})
+	if kinds := issueKinds(report); kinds[IssueCorruptObject] != 1 || kinds[IssueMissingObject] != 1 || report.Unresolved() != 2 {
+		t.Fatalf("deep check reported %+v", report.Issues)
+	}
+	if _, ok := mocks[1].chunks[lost]; ok {
+		t.Fatal("deep check without repair wrote objects")
+	}
+
+	report = runFsck(t, ss, FsckOptions{Repair: true})
+	if len(report.Issues) != 2 || report.Unresolved() != 0 {
+		t.Fatalf("repair reported %+v", report.Issues)
+	}
+	if report := runFsck(t, ss, FsckOptions{Deep: true}); len(report.Issues) != 0 {
+		t.Errorf("issues left after repair: %+v", report.Issues)
+	}
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, data) {
+		t.Error("content changed after repair")
+	}
+}
+
+func TestFsck_ErasureRepair(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 5)
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	if err := ss.UploadFileWithOptions(path, UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+	byID := make(map[string]*mockCloudStorage)
+	for _, m := range mocks {
+		byID[m.id] = m
+	}
+	chunks, _ := metaSvc.ListChunks(fileName)
+	stripes, _ := metaSvc.ListStripes(fileName)
+	// The only copy of a data chunk is corrupt, and a parity shard of another stripe is lost
+	chunk, parity := chunks[0], stripes[1].Parity[0]
+	byID[chunk.Storage].chunks[chunk.ChunkName][chunker.ChunkMetadataSize] ^= 0xff
+	delete(byID[parity.Storage].chunks, parity.ShardName)
+
+	report := runFsck(t, ss, FsckOptions{Repair: true})
+	if kinds := issueKinds(report); kinds[IssueCorruptObject] != 1 || kinds[IssueMissingObject] != 1 || report.Unresolved() != 0 {
+		t.Fatalf("repair reported %+v", report.Issues)
+	}
+	if report := runFsck(t, ss, FsckOptions{Deep: true}); len(report.Issues) != 0 {
+		t.Errorf("issues left after repair: %+v", report.Issues)
+	}
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, data) {
+		t.Error("content changed after repair")
+	}
+}
+
+func TestFsck_Metadata(t *testing.T) {
+	ss, _, metaSvc := setupErasureService(t, 1)
+	if err := metaSvc.AddFile("ghost.txt", 10); err != nil {
+		t.Fatal(err)
+	}
+	err := metaSvc.AddChunk("ghost.txt", metadata.ChunkMetadata{ChunkName: "ghost-1", Size: 4, Index: 1, Storage: "retired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := runFsck(t, ss, FsckOptions{})
+	kinds := issueKinds(report)
+	if kinds[IssueMissingChunk] != 1 || kinds[IssueSizeMismatch] != 1 || kinds[IssueUnknownStorage] != 1 || len(report.Issues) != 3 {
+		t.Errorf("Fsck reported %+v", report.Issues)
+	}
+	if report.Files != 1 || report.Versions != 1 || report.Objects != 1 {
+		t.Errorf("Fsck counted %+v", report)
+	}
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/cloud"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+)
+
+// DefaultGCGrace is how old an unreferenced object must be before GC removes it
+const DefaultGCGrace = 24 * time.Hour
+
+// storagexObject matches the names storagex stores objects under: content addresses
+// (the hex SHA-256 of the data or its keyed hash), parity shards "<name>-stripe-N-parity-M"
+// and chunks of files stored before content addressing, "<name>-chunk-N"
+var storagexObject = regexp.MustCompile(`^[0-9a-f]{64}$|-stripe-[0-9]+-parity-[0-9]+$|-chunk-[0-9]+$`)
+
+// GCOptions controls a garbage collection run
+type GCOptions struct {
+	// Grace spares unreferenced objects modified more recently than this: an upload in
+	// another process stores each object before recording it in metadata
+	Grace  time.Duration
+	DryRun bool // report orphans without deleting them
+}
+
+// GCReport is the outcome of garbage collection on one backend
+type GCReport struct {
+	Storage string
+	Scanned int                // objects listed
+	Foreign int                // objects not named like storagex objects, never touched
+	Orphans []cloud.ObjectInfo // unreferenced objects older than the grace period
+	Deleted int                // orphans removed; 0 on a dry run
+	Errors  []error            // listing or delete failures
+}
+
+// GC deletes objects on every backend that no chunk or parity shard in metadata references:
+// leftovers of failed rollbacks, crashed uploads and deletes whose remote half failed.
+func (s *StorageService) GC(opts GCOptions) ([]GCReport, error) {
+	return s.GCContext(context.Background(), opts)
+}
+
+// GCContext is GC bounded by ctx. Every backend is reported, also when an earlier one
+// failed; the error is ErrGCIncomplete when any report has errors.
+func (s *StorageService) GCContext(ctx context.Context, opts GCOptions) ([]GCReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		reports []GCReport
+		failed  bool
+	)
+	for _, backend := range s.manager.Backends() {
+		if err := ctx.Err(); err != nil {
+			return reports, err
+		}
+		report := s.collect(ctx, backend, opts, time.Now())
+		failed = failed || len(report.Errors) > 0
+		reports = append(reports, report)
+	}
+	if err := ctx.Err(); err != nil {
+		return reports, err
+	}
+	if failed {
+		return reports, errorx.ErrGCIncomplete
+	}
+	return reports, nil
+}
+
+// collect finds and removes the orphans of one backend. References are read after listing,
+// so an object recorded while the listing ran is never taken for an orphan. Only objects
+// named like storagex objects are candidates: a full-access Dropbox token or an S3 bucket
+// without a prefix lists files that other programs own.
+func (s *StorageService) collect(ctx context.Context, backend cloud.CloudStorage, opts GCOptions, now time.Time) GCReport {
+	report := GCReport{Storage: backend.StorageSystemID()}
+	objects, err := cloud.List(ctx, backend)
+	if err != nil {
+		report.Errors = append(report.Errors, err)
+		return report
+	}
+	report.Scanned = len(objects)
+	referenced, err := s.metaSvc.ReferencedObjects(report.Storage)
+	if err != nil {
+		report.Errors = append(report.Errors, err)
+		return report
+	}
+	cutoff := now.Add(-opts.Grace)
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		if !storagexObject.MatchString(obj.Name) {
+			report.Foreign++
+			continue
+		}
+		if referenced[obj.Name] || obj.ModTime.After(cutoff) {
+			continue
+		}
+		report.Orphans = append(report.Orphans, obj)
+		if opts.DryRun {
+			continue
+		}
+		if err := cloud.DeleteChunk(ctx, backend, obj.Name); err != nil {
+			report.Errors = append(report.Errors, errorx.WrapWithDetails(errorx.ErrChunkDeleteFailed, fmt.Sprintf("%s: %v", obj.Name, err)))
+			continue
+		}
+		log.Info("GC deleted orphan %s from %s", obj.Name, report.Storage)
+		report.Deleted++
+	}
+	return report
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+func orphanNames(reports []GCReport) []string {
+	var names []string
+	for _, r := range reports {
+		for _, obj := range r.Orphans {
+			names = append(names, r.Storage+"/"+obj.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestGC_Orphans(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 5)
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	fileName := filepath.Base(path)
+	if err := ss.UploadFileWithOptions(path, UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	var stray string
+	for name, obj := range mocks[0].chunks {
+		// A copy of a referenced object on a backend metadata does not record it on
+		stray = name
+		mocks[1].chunks[name] = obj
+		break
+	}
+	mocks[2].chunks["crashed-upload-chunk-0"] = []byte("old")
+	// Files of other programs sharing the backend
+	mocks[2].chunks["photos/holiday.jpg"] = []byte("not ours")
+	mocks[2].chunks["chunk-0-notes.txt"] = []byte("not ours")
+	if err := mocks[3].UploadChunk("uploading-chunk-0", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	before := make([]int, len(mocks))
+	for i, m := range mocks {
+		before[i] = len(m.chunks)
+	}
+
+	reports, err := ss.GC(GCOptions{Grace: time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	want := []string{"backend1/" + stray, "backend2/crashed-upload-chunk-0"}
+	if got := orphanNames(reports); len(reports) != 5 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("dry run found %v in %d reports, want %v", got, len(reports), want)
+	}
+	if reports[2].Foreign != 2 {
+		t.Errorf("dry run counted %d foreign objects on backend2, want 2", reports[2].Foreign)
+	}
+	for i, m := range mocks {
+		if len(m.chunks) != before[i] {
+			t.Errorf("dry run changed %s", m.id)
+		}
+	}
+
+	if _, err := ss.GC(GCOptions{Grace: time.Hour}); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	if _, ok := mocks[1].chunks[stray]; ok {
+		t.Error("stray copy was not deleted")
+	}
+	if _, ok := mocks[2].chunks["crashed-upload-chunk-0"]; ok {
+		t.Error("old orphan was not deleted")
+	}
+	if _, ok := mocks[3].chunks["uploading-chunk-0"]; !ok {
+		t.Error("orphan inside the grace period was deleted")
+	}
+	for _, name := range []string{"photos/holiday.jpg", "chunk-0-notes.txt"} {
+		if _, ok := mocks[2].chunks[name]; !ok {
+			t.Errorf("GC deleted %s, which storagex did not write", name)
+		}
+	}
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, data) {
+		t.Error("file content changed after GC")
+	}
+
+	// Without a grace period the recent object goes too; a failing backend is reported
+	mocks[4].chunks["crashed-upload-chunk-1"] = []byte("old")
+	mocks[4].failDelete = true
+	reports, err = ss.GC(GCOptions{})
+	if !errors.Is(err, errorx.ErrGCIncomplete) {
+		t.Fatalf("expected ErrGCIncomplete, got %v", err)
+	}
+	if _, ok := mocks[3].chunks["uploading-chunk-0"]; ok {
+		t.Error("orphan was not deleted without a grace period")
+	}
+	if r := reports[4]; len(r.Orphans) != 1 || r.Deleted != 0 || len(r.Errors) != 1 {
+		t.Errorf("failing backend reported %+v", r)
+	}
+}
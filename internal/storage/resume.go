@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+// newUploadSession describes an upload of the local file at filePath, expiring after the
+// configured session TTL
+func newUploadSession(fileName, filePath string, info os.FileInfo, opts UploadOptions) (metadata.UploadSession, error) {
+	sess, err := newStreamSession(fileName, opts)
+	if err != nil {
+		return metadata.UploadSession{}, err
+	}
+	if abs, err := filepath.Abs(filePath); err == nil {
+		filePath = abs
+	}
+	sess.SourcePath = filePath
+	sess.SourceSize = info.Size()
+	sess.SourceMTime = info.ModTime().UnixNano()
+	return sess, nil
+}
+
+// newStreamSession describes an upload without a local source; it has no source path and
+// cannot be resumed
+func newStreamSession(fileName string, opts UploadOptions) (metadata.UploadSession, error) {
+	encoded, err := json.Marshal(opts)
+	if err != nil {
+		return metadata.UploadSession{}, err
+	}
+	now := time.Now()
+	ttl := time.Duration(config.GetConfig().Upload.SessionTTLHours) * time.Hour
+	return metadata.UploadSession{
+		FileName:  fileName,
+		Options:   string(encoded),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}, nil
+}
+
+// ResumeUpload finishes an upload that was interrupted, with the options it was started
+// with. Chunks already stored are skipped once their checksum matches the source. If the
+// resumed upload fails too, its session is kept so it can be resumed again.
+func (s *StorageService) ResumeUpload(filePath string) error {
+	return s.ResumeUploadContext(context.Background(), filePath)
+}
+
+// ResumeUploadContext is ResumeUpload bounded by ctx. Chunks recorded before ctx ends
+// stay recorded, so a cancelled resume can itself be resumed.
+func (s *StorageService) ResumeUploadContext(ctx context.Context, filePath string) error {
+	return s.resumeUpload(ctx, filePath, "")
+}
+
+// ResumeUploadAsContext resumes the upload of filePath stored under the logical path fileName
+func (s *StorageService) ResumeUploadAsContext(ctx context.Context, filePath, fileName string) error {
+	fileName, err := CleanPath(fileName)
+	if err != nil {
+		return err
+	}
+	return s.resumeUpload(ctx, filePath, fileName)
+}
+
+func (s *StorageService) resumeUpload(ctx context.Context, filePath, fileName string) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("resume", 0, start, err) }(time.Now())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, info, fileName, err := openSource(filePath, fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sess, ok := s.metaSvc.GetUploadSession(fileName)
+	if !ok {
+		return errorx.WrapWithDetails(errorx.ErrUploadSessionNotFound, fileName)
+	}
+	if sess.Expired(time.Now()) {
+		return errorx.WrapWithDetails(errorx.ErrUploadSessionExpired, fileName)
+	}
+	if sess.SourcePath == "" {
+		return errorx.WrapWithDetails(errorx.ErrUploadSourceChanged, fileName+" was uploaded from a stream")
+	}
+	if sess.SourceSize != info.Size() || sess.SourceMTime != info.ModTime().UnixNano() {
+		return errorx.WrapWithDetails(errorx.ErrUploadSourceChanged, fileName)
+	}
+	var opts UploadOptions
+	if err := json.Unmarshal([]byte(sess.Options), &opts); err != nil {
+		return errorx.Wrap(errorx.ErrDBScanFailed, err)
+	}
+
+	ver, ok := s.metaSvc.GetVersion(fileName, sess.Version)
+	if !ok {
+		return errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, sess.Version))
+	}
+	stored, err := s.metaSvc.ListChunks(ver.StorageName)
+	if err != nil {
+		return err
+	}
+	fileCipher, err := s.versionCipher(ver)
+	if err != nil {
+		return err
+	}
+	if opts.Mode == ModeErasure {
+		if stored, err = s.dropIncompleteStripes(ctx, ver.StorageName, stored, opts.DataShards); err != nil {
+			return err
+		}
+	}
+	done := make(map[int]metadata.ChunkMetadata, len(stored))
+	for _, meta := range stored {
+		done[meta.Index] = meta
+	}
+	log.Info("Resuming upload of %s: %d chunks already stored", fileName, len(done))
+
+	chunks := s.chunker.ChunkStream(file, ver.StorageName)
+	cc := chunkCodec{cipher: fileCipher, compression: opts.Compression}
+	if _, err := s.upload(ctx, ver.StorageName, skipStored(chunks, done), cc, opts); err != nil {
+		return err
+	}
+	return s.finishUpload(ctx, fileName)
+}
+
+// skipStored passes on the chunks not in done. A stored chunk whose checksum differs
+// from the source ends the stream with ErrUploadSourceChanged.
+func skipStored(chunks <-chan chunker.Chunk, done map[int]metadata.ChunkMetadata) <-chan chunker.Chunk {
+	out := make(chan chunker.Chunk)
+	go func() {
+		defer close(out)
+		defer drain(chunks)
+		for chunk := range chunks {
+			meta, ok := done[int(chunk.Index)]
+			if ok && chunk.Err == nil {
+				if meta.Checksum != string(chunk.Checksum[:]) || meta.Size != int64(len(chunk.Data)) {
+					out <- chunker.Chunk{
+						Err:   errorx.WrapWithDetails(errorx.ErrUploadSourceChanged, "chunk "+strconv.FormatUint(chunk.Index, 10)+" differs from the stored one"),
+						Index: chunk.Index,
+					}
+					return
+				}
+				continue
+			}
+			out <- chunk
+			if chunk.Err != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// dropIncompleteStripes removes the chunks of erasure stripes whose parity was never
+// recorded, so they are uploaded again together with it
+func (s *StorageService) dropIncompleteStripes(ctx context.Context, storageName string, stored []metadata.ChunkMetadata, dataShards int) ([]metadata.ChunkMetadata, error) {
+	stripes, err := s.metaSvc.ListStripes(storageName)
+	if err != nil {
+		return nil, err
+	}
+	complete := make(map[int]bool, len(stripes))
+	for _, st := range stripes {
+		complete[st.Stripe] = true
+	}
+	var kept []metadata.ChunkMetadata
+	for _, meta := range stored {
+		if complete[meta.Index/dataShards] {
+			kept = append(kept, meta)
+			continue
+		}
+		if err := s.manager.DeleteChunkReplicasContext(ctx, meta.Replicas, meta.ChunkName); err != nil {
+			log.Error("%v: %s: %v", errorx.ErrChunkDeleteFailed, meta.ChunkName, err)
+		}
+		if err := s.metaSvc.DeleteChunk(meta.ChunkName); err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+// CleanupUploadSessions deletes unfinished uploads that have expired, or all of them when
+// all is set, together with the chunks they stored. An abandoned first upload removes the
+// file; an abandoned later version leaves the current one in place. It returns the files
+// whose uploads were removed.
+func (s *StorageService) CleanupUploadSessions(all bool) ([]string, error) {
+	return s.CleanupUploadSessionsContext(context.Background(), all)
+}
+
+// CleanupUploadSessionsContext is CleanupUploadSessions bounded by ctx
+func (s *StorageService) CleanupUploadSessionsContext(ctx context.Context, all bool) ([]string, error) {
+	sessions, err := s.metaSvc.ListUploadSessions()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var removed []string
+	for _, sess := range sessions {
+		if !all && !sess.Expired(now) {
+			continue
+		}
+		if err := s.abandonUpload(ctx, sess); err != nil {
+			return removed, err
+		}
+		removed = append(removed, sess.FileName)
+	}
+	return removed, nil
+}
+
+// abandonUpload removes an unfinished upload and what it stored
+func (s *StorageService) abandonUpload(ctx context.Context, sess metadata.UploadSession) error {
+	if file, ok := s.metaSvc.GetFile(sess.FileName); !ok || file.Version == sess.Version {
+		return s.DeleteFileContext(ctx, sess.FileName)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.releaseVersion(ctx, sess.FileName, sess.Version)
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+)
+
+// interruptUpload turns a finished upload back into the state left by a crash after its
+// first keep chunks were stored
+func interruptUpload(t *testing.T, ss *StorageService, backend *mockCloudStorage, path string, keep int, opts UploadOptions) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Base(path)
+	sess, err := newUploadSession(fileName, path, info, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ver, _ := ss.metaSvc.GetVersion(fileName, 0)
+	chunks, _ := ss.metaSvc.ListChunks(ver.StorageName)
+	if _, err := ss.metaSvc.ReleaseFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := ss.metaSvc.BeginUpload(sess, info.Size(), ver.WrappedKey)
+	if err != nil {
+		t.Fatalf("BeginUpload failed: %v", err)
+	}
+	for _, c := range chunks[:keep] {
+		if err := ss.metaSvc.AddChunk(restarted.StorageName, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range chunks[keep:] {
+		delete(backend.chunks, c.ChunkName)
+	}
+}
+
+func resumeTestData() []byte {
+	var data []byte
+	for i := 0; i < 8; i++ {
+		data = append(data, bytes.Repeat([]byte{byte('A' + i)}, 16)...)
+	}
+	return data
+}
+
+func TestResumeUpload(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	data := resumeTestData()
+	path := writeTempFile(t, data)
+	fileName := filepath.Base(path)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if _, ok := metaSvc.GetUploadSession(fileName); ok {
+		t.Fatal("session left open after a finished upload")
+	}
+
+	interruptUpload(t, ss, mocks[0], path, 5, DefaultUploadOptions())
+	if err := ss.GetFile(fileName, &bytes.Buffer{}); !errors.Is(err, errorx.ErrUploadInProgress) {
+		t.Errorf("expected ErrUploadInProgress reading an unfinished file, got %v", err)
+	}
+	if err := ss.UploadFile(path); !errors.Is(err, errorx.ErrUploadInProgress) {
+		t.Errorf("expected ErrUploadInProgress uploading again, got %v", err)
+	}
+
+	before := mocks[0].uploads
+	if err := ss.ResumeUpload(path); err != nil {
+		t.Fatalf("ResumeUpload failed: %v", err)
+	}
+	if got := mocks[0].uploads - before; got != 3 {
+		t.Errorf("resume uploaded %d chunks, want the 3 missing ones", got)
+	}
+	if _, ok := metaSvc.GetUploadSession(fileName); ok {
+		t.Error("session left open after resuming")
+	}
+	var buf bytes.Buffer
+	if err := ss.GetFile(fileName, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("GetFile after resume: %v", err)
+	}
+
+	if err := ss.ResumeUpload(path); !errors.Is(err, errorx.ErrUploadSessionNotFound) {
+		t.Errorf("expected ErrUploadSessionNotFound, got %v", err)
+	}
+}
+
+func TestResumeUpload_ErasureRedoesIncompleteStripe(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 5)
+	data := erasureTestData()
+	path := writeTempFile(t, data)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+
+	// Stripe 1 was cut short: chunk 3 recorded, its parity never was
+	info, _ := os.Stat(path)
+	sess, _ := newUploadSession(fileName, path, info, opts)
+	chunks, _ := ss.metaSvc.ListChunks(fileName)
+	stripes, _ := ss.metaSvc.ListStripes(fileName)
+	if _, err := ss.metaSvc.ReleaseFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.metaSvc.BeginUpload(sess, int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks[:4] {
+		if err := ss.metaSvc.AddChunk(fileName, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ss.metaSvc.AddStripe(fileName, stripes[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ss.ResumeUpload(path); err != nil {
+		t.Fatalf("ResumeUpload failed: %v", err)
+	}
+	// Every stripe must be intact again: lose two backends and read
+	mocks[0].failGet, mocks[1].failGet = true, true
+	var buf bytes.Buffer
+	if err := ss.GetFile(fileName, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("GetFile after resume with two backends down: %v", err)
+	}
+}
+
+func TestResumeUpload_RejectsChangedSource(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 1)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	data := resumeTestData()
+	path := writeTempFile(t, data)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	interruptUpload(t, ss, mocks[0], path, 5, DefaultUploadOptions())
+
+	// Same size and mtime, different content in a stored chunk
+	info, _ := os.Stat(path)
+	changed := append([]byte(nil), data...)
+	changed[0] = 'Z'
+	if err := os.WriteFile(path, changed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.ResumeUpload(path); !errors.Is(err, errorx.ErrUploadSourceChanged) {
+		t.Errorf("expected ErrUploadSourceChanged for changed chunk, got %v", err)
+	}
+
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.ResumeUpload(path); !errors.Is(err, errorx.ErrUploadSourceChanged) {
+		t.Errorf("expected ErrUploadSourceChanged for new mtime, got %v", err)
+	}
+}
+
+func TestCleanupUploadSessions(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	path := writeTempFile(t, resumeTestData())
+	fileName := filepath.Base(path)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	interruptUpload(t, ss, mocks[0], path, 5, DefaultUploadOptions())
+
+	removed, err := ss.CleanupUploadSessions(false)
+	if err != nil || len(removed) != 0 {
+		t.Fatalf("fresh session cleaned up: %v, %v", removed, err)
+	}
+	removed, err = ss.CleanupUploadSessions(true)
+	if err != nil || len(removed) != 1 || removed[0] != fileName {
+		t.Fatalf("CleanupUploadSessions(true) = %v, %v", removed, err)
+	}
+	if len(mocks[0].chunks) != 0 {
+		t.Errorf("%d chunks left after cleanup", len(mocks[0].chunks))
+	}
+	if exists, _ := metaSvc.FileExists(fileName); exists {
+		t.Error("file row left after cleanup")
+	}
+	if _, ok := metaSvc.GetUploadSession(fileName); ok {
+		t.Error("session left after cleanup")
+	}
+}
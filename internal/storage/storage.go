@@ -1,24 +1,30 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/sayuyere/storageX/internal/chunker"
+	"github.com/sayuyere/storageX/internal/compression"
 	"github.com/sayuyere/storageX/internal/config"
+	"github.com/sayuyere/storageX/internal/encryption"
 	errorx "github.com/sayuyere/storageX/internal/errors" // new error package alias
 	"github.com/sayuyere/storageX/internal/log"
 	"github.com/sayuyere/storageX/internal/manager"
 	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
 )
 
 type StorageService struct {
-	manager *manager.StorageManager
-	metaSvc *metadata.MetadataService
-	chunker *chunker.FileChunker
-	lock    sync.RWMutex
+	manager   *manager.StorageManager
+	metaSvc   *metadata.MetadataService
+	chunker   *chunker.FileChunker
+	masterKey []byte // wraps per-file data keys; nil disables encryption of new uploads
+	lock      sync.RWMutex
 }
 
 func NewStorageService(mgr *manager.StorageManager, meta *metadata.MetadataService, ch *chunker.FileChunker) *StorageService {
@@ -29,102 +35,423 @@ func NewStorageService(mgr *manager.StorageManager, meta *metadata.MetadataServi
 	}
 }
 
+// SetMasterKey enables client-side encryption: every new upload gets its own data key,
+// wrapped by masterKey and kept in metadata. Files stored without encryption stay readable.
+func (s *StorageService) SetMasterKey(masterKey []byte) {
+	s.masterKey = masterKey
+}
+
+// newFileKey creates a data key and wraps it with the master key; nil when encryption is off
+func (s *StorageService) newFileKey() (*encryption.Cipher, []byte, error) {
+	if s.masterKey == nil {
+		return nil, nil, nil
+	}
+	dataKey, err := encryption.NewDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := encryption.WrapKey(s.masterKey, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileCipher, err := encryption.NewCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fileCipher, wrapped, nil
+}
+
+// uploadKey returns the data key for a new version of fileName: the current version's key
+// when it has one, so unchanged chunks deduplicate across versions, otherwise a new key
+func (s *StorageService) uploadKey(fileName string) (*encryption.Cipher, []byte, error) {
+	if s.masterKey == nil {
+		return nil, nil, nil
+	}
+	if current, ok := s.metaSvc.GetVersion(fileName, 0); ok && current.WrappedKey != nil {
+		fileCipher, err := s.versionCipher(current)
+		return fileCipher, current.WrappedKey, err
+	}
+	return s.newFileKey()
+}
+
+// versionCipher unwraps the data key of a stored version; nil for unencrypted versions
+func (s *StorageService) versionCipher(ver metadata.FileVersion) (*encryption.Cipher, error) {
+	if ver.WrappedKey == nil {
+		return nil, nil
+	}
+	if s.masterKey == nil {
+		return nil, errorx.WrapWithDetails(errorx.ErrEncryptionKeyMissing, ver.FileName)
+	}
+	dataKey, err := encryption.UnwrapKey(s.masterKey, ver.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.NewCipher(dataKey)
+}
+
+// Storage modes for UploadOptions.Mode
+const (
+	ModeReplicate = "replicate" // each chunk is copied to replication-factor backends
+	ModeErasure   = "erasure"   // chunks are grouped into Reed-Solomon stripes over distinct backends
+)
+
+// UploadOptions selects how a single upload is stored
+type UploadOptions struct {
+	Mode         string // ModeReplicate or ModeErasure
+	DataShards   int    // erasure: chunks per stripe
+	ParityShards int    // erasure: parity shards per stripe
+	Compression  string // chunk codec: none, zstd, gzip, lz4 or auto
+}
+
+// DefaultUploadOptions returns the upload options from app config
+func DefaultUploadOptions() UploadOptions {
+	cfg := config.GetConfig()
+	return UploadOptions{
+		Mode:         cfg.StorageMode,
+		DataShards:   cfg.Erasure.DataShards,
+		ParityShards: cfg.Erasure.ParityShards,
+		Compression:  cfg.Compression.Codec,
+	}
+}
+
+func (o UploadOptions) validate() error {
+	if !compression.Valid(o.Compression) {
+		return errorx.WrapWithDetails(errorx.ErrUnknownCodec, o.Compression)
+	}
+	switch o.Mode {
+	case "", ModeReplicate:
+		return nil
+	case ModeErasure:
+		if o.DataShards < 1 || o.ParityShards < 1 || o.DataShards+o.ParityShards > 256 {
+			return errorx.WrapWithDetails(errorx.ErrInvalidErasureLayout,
+				fmt.Sprintf("data shards %d, parity shards %d", o.DataShards, o.ParityShards))
+		}
+		return nil
+	}
+	return errorx.WrapWithDetails(errorx.ErrUnknownStorageMode, o.Mode)
+}
+
 // UploadFile splits the file into chunks and uploads them, updating metadata
 func (s *StorageService) UploadFile(filePath string) error {
+	return s.UploadFileContext(context.Background(), filePath)
+}
+
+// UploadFileContext is UploadFile bounded by ctx
+func (s *StorageService) UploadFileContext(ctx context.Context, filePath string) error {
+	return s.UploadFileWithOptionsContext(ctx, filePath, DefaultUploadOptions())
+}
+
+// UploadFileWithOptions uploads a file using the given storage mode. Uploading a name that
+// is already stored adds a new version, which becomes current once the upload completes.
+// The upload is tracked by a session in metadata until then, so an interrupted upload can
+// be finished with ResumeUpload; an upload that fails with an error is rolled back instead.
+func (s *StorageService) UploadFileWithOptions(filePath string, opts UploadOptions) error {
+	return s.UploadFileWithOptionsContext(context.Background(), filePath, opts)
+}
+
+// UploadFileWithOptionsContext is UploadFileWithOptions bounded by ctx. Once ctx is done
+// no further chunks are started; chunks in flight are waited for and the upload is
+// rolled back like any other failure.
+func (s *StorageService) UploadFileWithOptionsContext(ctx context.Context, filePath string, opts UploadOptions) error {
+	return s.uploadFile(ctx, filePath, "", opts)
+}
+
+// UploadFileAsContext uploads the local file at filePath under the logical path fileName,
+// such as "photos/2024/beach.jpg", instead of its base name
+func (s *StorageService) UploadFileAsContext(ctx context.Context, filePath, fileName string, opts UploadOptions) error {
+	fileName, err := CleanPath(fileName)
+	if err != nil {
+		return err
+	}
+	if fileName == "" {
+		return errorx.WrapWithDetails(errorx.ErrInvalidPath, "empty file name")
+	}
+	return s.uploadFile(ctx, filePath, fileName, opts)
+}
+
+// uploadFile stores filePath as fileName, or under its base name when fileName is empty
+func (s *StorageService) uploadFile(ctx context.Context, filePath, fileName string, opts UploadOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	file, err := os.Open(filePath)
+	file, info, fileName, err := openSource(filePath, fileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	info, err := file.Stat()
+	sess, err := newUploadSession(fileName, filePath, info, opts)
 	if err != nil {
-		log.Error("%v: %v", errorx.ErrFileInfoFetchFailed, err)
-		return errorx.Wrap(errorx.ErrFileInfoFetchFailed, err)
+		return err
 	}
-	fileName := info.Name()
-	if fileName == "" || fileName == "." {
-		fileName = filePath
+	_, err = s.uploadStream(ctx, file, sess, info.Size(), opts)
+	return err
+}
+
+// UploadReaderContext stores everything read from r as fileName, for sources that are not
+// local files. Such uploads cannot be resumed. It returns the number of bytes stored.
+// r is read while the write lock is held, so sources that can stall, like request
+// bodies, are staged with StageReader first.
+func (s *StorageService) UploadReaderContext(ctx context.Context, r io.Reader, fileName string, opts UploadOptions) (int64, error) {
+	fileName, err := CleanPath(fileName)
+	if err != nil {
+		return 0, err
+	}
+	if fileName == "" {
+		return 0, errorx.WrapWithDetails(errorx.ErrInvalidPath, "empty file name")
+	}
+	if err := opts.validate(); err != nil {
+		return 0, err
 	}
 
-	// Check if file already exists
-	exists, err := s.metaSvc.FileExists(fileName)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sess, err := newStreamSession(fileName, opts)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	return s.uploadStream(ctx, io.NopCloser(r), sess, 0, opts)
+}
+
+// StageReader copies r to a temporary file in dir, the system temp dir when empty, and
+// returns it rewound for UploadReaderContext. The caller closes and removes the file.
+func StageReader(r io.Reader, dir string) (*os.File, error) {
+	tmp, err := os.CreateTemp(dir, "storagex-upload-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(tmp, r); err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// uploadStream stores r as a new version of sess.FileName. size is recorded when the upload
+// starts and corrected to the bytes actually read before the version becomes current.
+// Callers hold the write lock.
+func (s *StorageService) uploadStream(ctx context.Context, r io.ReadCloser, sess metadata.UploadSession, size int64, opts UploadOptions) (n int64, err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("upload", n, start, err) }(time.Now())
+	fileName := sess.FileName
+	if _, ok := s.metaSvc.GetUploadSession(fileName); ok {
+		return 0, errorx.WrapWithDetails(errorx.ErrUploadInProgress, fileName)
+	}
+	fileCipher, wrappedKey, err := s.uploadKey(fileName)
+	if err != nil {
+		return 0, err
+	}
+	ver, err := s.metaSvc.BeginUpload(sess, size, wrappedKey)
+	if err != nil {
+		return 0, err
+	}
+	if ver.Version > 1 {
+		log.Info("Uploading %s as version %d", fileName, ver.Version)
+	}
+
+	source := &countingReader{ReadCloser: r}
+	chunks := s.chunker.ChunkStream(source, ver.StorageName)
+	cc := chunkCodec{cipher: fileCipher, compression: opts.Compression}
+	uploaded, err := s.upload(ctx, ver.StorageName, chunks, cc, opts)
+	if err == nil && source.n != size {
+		err = s.metaSvc.SetVersionSize(fileName, ver.Version, source.n)
 	}
-	if exists {
-		return errorx.WrapWithDetails(errorx.ErrFileAlreadyExists, fileName)
+	if err != nil {
+		s.rollback(ctx, ver, uploaded)
+		return 0, err
 	}
+	return source.n, s.finishUpload(ctx, fileName)
+}
 
-	// Add file entry to metadata
-	if err := s.metaSvc.AddFile(fileName, uint64(info.Size())); err != nil {
+// finishUpload makes the version stored by the session of fileName current and prunes
+// old versions by the configured retention policy. Pruning failures are only logged.
+func (s *StorageService) finishUpload(ctx context.Context, fileName string) error {
+	if err := s.metaSvc.EndUploadSession(fileName); err != nil {
 		return err
 	}
+	policy := RetentionPolicyFromConfig(config.GetConfig().Versioning)
+	if _, err := s.pruneVersions(ctx, fileName, policy, time.Now()); err != nil {
+		log.Error("Pruning old versions of %s failed: %v", fileName, err)
+	}
+	return nil
+}
 
-	// Rollback function
-	rollback := func(uploadedChunks []string) {
-		for _, chunkName := range uploadedChunks {
-			chunkMetaData, found := s.metaSvc.GetChunk(chunkName)
-			if !found {
-				log.Error("%v: %s, skipping deletion", errorx.ErrChunkNotFound, chunkName)
-				continue
-			}
-			_ = s.manager.DeleteChunk(chunkMetaData.Storage, chunkName)
-		}
-		_ = s.metaSvc.DeleteFile(fileName)
+// upload stores a chunk stream with the storage mode in opts
+func (s *StorageService) upload(ctx context.Context, fileName string, chunks <-chan chunker.Chunk, cc chunkCodec, opts UploadOptions) (map[string][]string, error) {
+	if opts.Mode == ModeErasure {
+		return s.uploadErasure(ctx, fileName, chunks, cc, opts.DataShards, opts.ParityShards)
 	}
+	return s.uploadReplicated(ctx, fileName, chunks, cc)
+}
 
-	chunks, err := s.chunker.ChunkFileStream(file)
+// openSource opens a local file for upload and returns the name it is stored under:
+// fileName if set, otherwise the file's base name
+func openSource(filePath, fileName string) (*os.File, os.FileInfo, string, error) {
+	file, err := os.Open(filePath)
 	if err != nil {
-		_ = s.metaSvc.DeleteFile(fileName)
-		return err
+		return nil, nil, "", err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		log.Error("%v: %v", errorx.ErrFileInfoFetchFailed, err)
+		return nil, nil, "", errorx.Wrap(errorx.ErrFileInfoFetchFailed, err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, "", errorx.WrapWithDetails(errorx.ErrInvalidPath, filePath+" is a directory")
+	}
+	if fileName != "" {
+		return file, info, fileName, nil
+	}
+	fileName = info.Name()
+	if fileName == "" || fileName == "." {
+		fileName = filePath
+	}
+	return file, info, fileName, nil
+}
+
+// rollback removes every written object (chunk name -> storage systems) and the metadata
+// of the version being uploaded; the whole file when it was the first. It runs to
+// completion even when ctx is done.
+func (s *StorageService) rollback(ctx context.Context, ver metadata.FileVersion, uploaded map[string][]string) {
+	ctx = context.WithoutCancel(ctx)
+	for chunkName, replicas := range uploaded {
+		if err := s.manager.DeleteChunkReplicasContext(ctx, replicas, chunkName); err != nil {
+			log.Error("%v: %s: %v", errorx.ErrChunkDeleteFailed, chunkName, err)
+		}
+	}
+	if ver.Version == 1 {
+		_ = s.metaSvc.DeleteFile(ver.FileName)
+		return
+	}
+	_, _ = s.metaSvc.ReleaseVersion(ver.FileName, ver.Version)
+}
+
+// drain consumes the rest of a chunk stream so the chunker goroutine can exit
+func drain(chunks <-chan chunker.Chunk) {
+	for range chunks {
+	}
+}
+
+// acquire takes a worker slot from sem, giving up once ctx is done
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
+// chunkRef is a file position pointing at a stored object
+type chunkRef struct {
+	index int
+	name  string
+}
+
+// uploadReplicated uploads every chunk not stored yet to replication-factor backends and
+// references existing objects instead of uploading them again. It returns the objects
+// written so far, keyed by chunk name, for rollback.
+func (s *StorageService) uploadReplicated(ctx context.Context, fileName string, chunks <-chan chunker.Chunk, cc chunkCodec) (map[string][]string, error) {
 	var (
-		uploadedChunks []string
+		uploadedChunks = make(map[string][]string)
 		errOnce        sync.Once
 		uploadErr      error
 		mu             sync.Mutex
 		wg             sync.WaitGroup
 		maxParallel    = config.GetConfig().Parallel.Upload
 		sem            = make(chan struct{}, maxParallel)
+		scheduled      = make(map[string]bool) // objects this upload writes
+		repeats        []chunkRef              // later positions of objects in scheduled
 	)
+	defer drain(chunks)
 
 	for chunk := range chunks {
 		if chunk.Err != nil {
 			errOnce.Do(func() { uploadErr = chunk.Err })
 			break
 		}
-		c := string(chunk.Checksum[:])
-		if exists, _ := s.metaSvc.ChunkExists(chunk.Name); exists {
-			errOnce.Do(func() {
-				uploadErr = errorx.WrapWithDetails(errorx.ErrChunkAlreadyExists, chunk.Name)
-			})
+		chunk.Name = cc.objectName(chunk)
+		if scheduled[chunk.Name] {
+			// Referenced once the first copy is recorded
+			repeats = append(repeats, chunkRef{int(chunk.Index), chunk.Name})
+			continue
+		}
+		exists, err := s.metaSvc.ChunkExists(chunk.Name)
+		if err != nil {
+			errOnce.Do(func() { uploadErr = err })
+			break
+		}
+		if exists {
+			log.Info("Chunk %s already stored, skipping upload", chunk.Name)
+			if err := s.metaSvc.AddChunkRef(fileName, int(chunk.Index), chunk.Name); err != nil {
+				errOnce.Do(func() { uploadErr = err })
+				break
+			}
+			continue
+		}
+		if !acquire(ctx, sem) {
+			errOnce.Do(func() { uploadErr = ctx.Err() })
 			break
 		}
-		sem <- struct{}{}
+		scheduled[chunk.Name] = true
+		c := string(chunk.Checksum[:])
 		wg.Add(1)
 		go func(chunk chunker.Chunk) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			storageLocation, err := s.manager.UploadChunk(chunk.Name, chunk)
+			defer metrics.TrackWorker(metrics.PoolUpload)()
+			object, codec, err := cc.seal(chunk)
+			if err != nil {
+				errOnce.Do(func() { uploadErr = err })
+				return
+			}
+			storageLocations, err := s.manager.UploadObjectReplicasContext(ctx, chunk.Name, object)
 			if err != nil {
 				errOnce.Do(func() { uploadErr = err })
 				return
 			}
+			replicas := make([]string, len(storageLocations))
+			for i, location := range storageLocations {
+				replicas[i] = location.StorageSystemID()
+			}
 			mu.Lock()
-			uploadedChunks = append(uploadedChunks, chunk.Name)
+			uploadedChunks[chunk.Name] = replicas
 			mu.Unlock()
 			err = s.metaSvc.AddChunk(fileName, metadata.ChunkMetadata{
-				ChunkName: chunk.Name,
-				Size:      int64(len(chunk.Data)),
-				Checksum:  c,
-				Index:     int(chunk.Index),
-				FileName:  fileName,
-				Storage:   storageLocation.StorageSystemID(),
+				ChunkName:  chunk.Name,
+				Size:       int64(len(chunk.Data)),
+				Checksum:   c,
+				Index:      int(chunk.Index),
+				FileName:   fileName,
+				Storage:    replicas[0],
+				Replicas:   replicas,
+				Codec:      codec,
+				StoredSize: int64(len(object)),
 			})
 			if err != nil {
 				errOnce.Do(func() { uploadErr = err })
@@ -133,70 +460,105 @@ func (s *StorageService) UploadFile(filePath string) error {
 	}
 	wg.Wait()
 	if uploadErr != nil {
-		rollback(uploadedChunks)
-		return uploadErr
+		return uploadedChunks, uploadErr
 	}
-	return nil
+	for _, ref := range repeats {
+		if err := s.metaSvc.AddChunkRef(fileName, ref.index, ref.name); err != nil {
+			return uploadedChunks, err
+		}
+	}
+	return uploadedChunks, nil
 }
 
 // GetFile reconstructs the file from chunks and writes to writer
 func (s *StorageService) GetFile(fileName string, w io.Writer) error {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	return s.GetFileContext(context.Background(), fileName, w)
+}
+
+// GetFileContext is GetFile bounded by ctx
+func (s *StorageService) GetFileContext(ctx context.Context, fileName string, w io.Writer) error {
+	return s.GetFileVersionContext(ctx, fileName, 0, w)
+}
 
-	metas, err := s.metaSvc.ListChunks(fileName)
+// GetFileVersionContext writes a given version of a file to w; version 0 is the current one
+func (s *StorageService) GetFileVersionContext(ctx context.Context, fileName string, version int, w io.Writer) (err error) {
+	var n int64
+	defer func(start time.Time) { metrics.ObserveOperation("download", n, start, err) }(time.Now())
+	r, err := s.NewFileVersionReaderContext(ctx, fileName, version)
 	if err != nil {
 		return err
 	}
-	var (
-		errOnce     sync.Once
-		getErr      error
-		wg          sync.WaitGroup
-		maxParallel = config.GetConfig().Parallel.Download
-		sem         = make(chan struct{}, maxParallel)
-		results     = make([][]byte, len(metas))
-	)
-	for i, meta := range metas {
-		sem <- struct{}{}
-		wg.Add(1)
-		go func(i int, meta metadata.ChunkMetadata) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			log.Info("Retrieving chunk: %s", meta.ChunkName)
-			data, err := s.manager.GetChunk(meta.Storage, meta.ChunkName)
-			if err != nil {
-				errOnce.Do(func() { getErr = err })
-				return
-			}
-			results[i] = data[chunker.ChunkMetadataSize:]
-		}(i, meta)
+	defer r.Close()
+	n, err = r.WriteTo(w)
+	return err
+}
+
+// DeleteFile removes a file's metadata, every version included, and deletes the objects no
+// other file references
+func (s *StorageService) DeleteFile(fileName string) error {
+	return s.DeleteFileContext(context.Background(), fileName)
+}
+
+// DeleteFileContext is DeleteFile bounded by ctx. Metadata is released first, so objects
+// left on the backends when ctx ends are only orphans.
+func (s *StorageService) DeleteFileContext(ctx context.Context, fileName string) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("delete", 0, start, err) }(time.Now())
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	wg.Wait()
-	if getErr != nil {
-		return getErr
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	metas, err := s.metaSvc.ReleaseFile(fileName)
+	if err != nil {
+		return errorx.Wrap(errorx.ErrFileDeleteFailed, err)
 	}
-	for _, chunkData := range results {
-		if chunkData == nil {
-			continue
-		}
-		_, err := w.Write(chunkData)
-		if err != nil {
-			return err
-		}
+	if deleteErrs := s.deleteObjects(ctx, metas); len(deleteErrs) > 0 {
+		return errorx.WrapWithDetails(errorx.ErrFileDeleteFailed, fmt.Sprintf("file: %s, errors: %v", fileName, deleteErrs))
 	}
 	return nil
 }
 
-// DeleteFile deletes all chunks for a file and removes metadata
-func (s *StorageService) DeleteFile(fileName string) error {
+// RenameFile moves a file, every version included, to a free path. Only metadata changes;
+// the stored objects stay where they are.
+func (s *StorageService) RenameFile(from, to string) error {
+	from, to, err := cleanRename(from, to)
+	if err != nil {
+		return err
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	return s.metaSvc.RenameFile(from, to)
+}
 
-	metas, err := s.metaSvc.ListChunks(fileName)
+// RenameDir moves every file below directory from below to, keeping their relative paths.
+// It fails without moving anything if any of them is uploading or its new path is taken.
+func (s *StorageService) RenameDir(from, to string) error {
+	from, to, err := cleanRename(from, to)
 	if err != nil {
 		return err
 	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.metaSvc.RenameDir(from, to)
+}
+
+func cleanRename(from, to string) (string, string, error) {
+	from, err := CleanPath(from)
+	if err != nil {
+		return "", "", err
+	}
+	if to, err = CleanPath(to); err != nil {
+		return "", "", err
+	}
+	if from == "" || to == "" {
+		return "", "", errorx.WrapWithDetails(errorx.ErrInvalidPath, "empty file name")
+	}
+	return from, to, nil
+}
 
+// deleteObjects deletes released objects from every backend holding them, in parallel
+func (s *StorageService) deleteObjects(ctx context.Context, metas []metadata.ChunkMetadata) []error {
 	var (
 		deleteErrs  []error
 		wg          sync.WaitGroup
@@ -205,12 +567,18 @@ func (s *StorageService) DeleteFile(fileName string) error {
 		mu          sync.Mutex
 	)
 	for _, meta := range metas {
-		sem <- struct{}{}
+		if !acquire(ctx, sem) {
+			mu.Lock()
+			deleteErrs = append(deleteErrs, ctx.Err())
+			mu.Unlock()
+			break
+		}
 		wg.Add(1)
 		go func(meta metadata.ChunkMetadata) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			if err := s.manager.DeleteChunk(meta.Storage, meta.ChunkName); err != nil {
+			defer metrics.TrackWorker(metrics.PoolDelete)()
+			if err := s.manager.DeleteChunkReplicasContext(ctx, meta.Replicas, meta.ChunkName); err != nil {
 				mu.Lock()
 				deleteErrs = append(deleteErrs, errorx.WrapWithDetails(errorx.ErrChunkDeleteFailed, meta.ChunkName))
 				mu.Unlock()
@@ -218,13 +586,5 @@ func (s *StorageService) DeleteFile(fileName string) error {
 		}(meta)
 	}
 	wg.Wait()
-
-	if err := s.metaSvc.DeleteFile(fileName); err != nil {
-		deleteErrs = append(deleteErrs, errorx.Wrap(errorx.ErrFileDeleteFailed, err))
-	}
-
-	if len(deleteErrs) > 0 {
-		return errorx.WrapWithDetails(errorx.ErrFileDeleteFailed, fmt.Sprintf("file: %s, errors: %v", fileName, deleteErrs))
-	}
-	return nil
+	return deleteErrs
 }
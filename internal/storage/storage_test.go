@@ -2,12 +2,22 @@ package storage
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"io"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/sayuyere/storageX/internal/chunker"
 	"github.com/sayuyere/storageX/internal/cloud"
+	"github.com/sayuyere/storageX/internal/encryption"
+	errorx "github.com/sayuyere/storageX/internal/errors"
 	"github.com/sayuyere/storageX/internal/manager"
 	"github.com/sayuyere/storageX/internal/metadata"
 )
@@ -15,23 +25,40 @@ import (
 const DefaultChunkSize = 64 // Default chunk size for testing
 
 type mockCloudStorage struct {
+	id         string
+	mu         sync.Mutex
 	chunks     map[string][]byte
 	failUpload bool
 	failGet    bool
 	failDelete bool
+	uploads    int                  // successful UploadChunk calls
+	onUpload   func()               // called after each successful UploadChunk
+	modTimes   map[string]time.Time // when each object was last written
 }
 
 func (m *mockCloudStorage) UploadChunk(name string, data []byte) error {
 	if m.failUpload {
 		return errors.New("upload failed")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.chunks[name] = data
+	if m.modTimes == nil {
+		m.modTimes = make(map[string]time.Time)
+	}
+	m.modTimes[name] = time.Now()
+	m.uploads++
+	if m.onUpload != nil {
+		m.onUpload()
+	}
 	return nil
 }
 func (m *mockCloudStorage) GetChunk(name string) ([]byte, error) {
 	if m.failGet {
 		return nil, errors.New("get failed")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	data, ok := m.chunks[name]
 	if !ok {
 		return nil, errors.New("not found")
@@ -42,11 +69,27 @@ func (m *mockCloudStorage) DeleteChunk(name string) error {
 	if m.failDelete {
 		return errors.New("delete failed")
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	delete(m.chunks, name)
 	return nil
 }
 func (m *mockCloudStorage) GetRemainingSize() (int64, error) { return 1 << 30, nil }
-func (m *mockCloudStorage) StorageSystemID() string          { return "mock" }
+func (m *mockCloudStorage) List() ([]cloud.ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []cloud.ObjectInfo
+	for name, data := range m.chunks {
+		result = append(result, cloud.ObjectInfo{Name: name, Size: int64(len(data)), ModTime: m.modTimes[name]})
+	}
+	return result, nil
+}
+func (m *mockCloudStorage) StorageSystemID() string {
+	if m.id == "" {
+		return "mock"
+	}
+	return m.id
+}
 
 func setupStorageService(t *testing.T) (*StorageService, *mockCloudStorage, *metadata.MetadataService, func()) {
 	metaSvc, err := metadata.NewMetadataService("test_storage.db")
@@ -146,3 +189,497 @@ func TestUploadFile_RollbackOnChunkError(t *testing.T) {
 		t.Error("expected error on upload, got nil")
 	}
 }
+
+func TestUploadFile_ReplicatedWithFallback(t *testing.T) {
+	metaSvc, err := metadata.NewMetadataService("test_storage_replicas.db")
+	if err != nil {
+		t.Fatalf("failed to create metadata: %v", err)
+	}
+	defer os.Remove("test_storage_replicas.db")
+	primary := &mockCloudStorage{id: "primary", chunks: make(map[string][]byte)}
+	secondary := &mockCloudStorage{id: "secondary", chunks: make(map[string][]byte)}
+	mgr := manager.NewStorageManager([]cloud.CloudStorage{primary, secondary})
+	mgr.SetReplication(2, 2)
+	ss := NewStorageService(mgr, metaSvc, chunker.NewFileChunker(DefaultChunkSize))
+
+	f, err := os.CreateTemp("", "storage-test-*.txt")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	data := bytes.Repeat([]byte("replicated-data-"), 8)
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	f.Close()
+
+	if err := ss.UploadFile(f.Name()); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if len(primary.chunks) == 0 || len(primary.chunks) != len(secondary.chunks) {
+		t.Fatalf("expected every chunk on both backends, got %d and %d", len(primary.chunks), len(secondary.chunks))
+	}
+
+	primary.failGet = true
+	var buf bytes.Buffer
+	if err := ss.GetFile(filepath.Base(f.Name()), &buf); err != nil {
+		t.Fatalf("GetFile failed with one replica down: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetFile data mismatch after fallback")
+	}
+
+	if err := ss.DeleteFile(filepath.Base(f.Name())); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if len(primary.chunks) != 0 || len(secondary.chunks) != 0 {
+		t.Errorf("replicas left behind after delete: %d, %d", len(primary.chunks), len(secondary.chunks))
+	}
+}
+
+func TestUploadAndGetFile_Encrypted(t *testing.T) {
+	ss, mockCloud, metaSvc := setupErasureService(t, 1)
+	masterKey := bytes.Repeat([]byte{0x42}, encryption.KeySize)
+	ss.SetMasterKey(masterKey)
+
+	data := bytes.Repeat([]byte("secret customer data "), 8)
+	path := writeTempFile(t, data)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+
+	meta, ok := metaSvc.GetFile(fileName)
+	if !ok || meta.WrappedKey == nil {
+		t.Fatalf("expected a wrapped data key in metadata, got %+v", meta)
+	}
+	for name, object := range mockCloud[0].chunks {
+		if object[0] != encryption.Version {
+			t.Errorf("%s: missing version byte", name)
+		}
+		if bytes.Contains(object, []byte("secret")) {
+			t.Errorf("%s: plaintext stored on backend", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ss.GetFile(fileName, &buf); err != nil {
+		t.Fatalf("GetFile failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetFile data mismatch")
+	}
+
+	// A modified object must fail authentication rather than return corrupt data
+	chunks, _ := metaSvc.ListChunks(fileName)
+	object := mockCloud[0].chunks[chunks[0].ChunkName]
+	object[len(object)-1] ^= 0x01
+	if err := ss.GetFile(fileName, &bytes.Buffer{}); !errors.Is(err, errorx.ErrDecryptFailed) {
+		t.Errorf("expected ErrDecryptFailed for tampered chunk, got %v", err)
+	}
+	object[len(object)-1] ^= 0x01
+
+	ss.SetMasterKey(nil)
+	if err := ss.GetFile(fileName, &bytes.Buffer{}); !errors.Is(err, errorx.ErrEncryptionKeyMissing) {
+		t.Errorf("expected ErrEncryptionKeyMissing without master key, got %v", err)
+	}
+	ss.SetMasterKey(bytes.Repeat([]byte{0x43}, encryption.KeySize))
+	if err := ss.GetFile(fileName, &bytes.Buffer{}); !errors.Is(err, errorx.ErrKeyUnwrapFailed) {
+		t.Errorf("expected ErrKeyUnwrapFailed with wrong master key, got %v", err)
+	}
+}
+
+func TestUploadFile_Deduplicates(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	backend := mocks[0]
+	dir := t.TempDir()
+
+	// 16 bytes of data per chunk: four identical chunks, then two distinct ones
+	block := []byte("0123456789abcdef")
+	data := append(bytes.Repeat(block, 4), []byte("unique-chunk-one")...)
+	data = append(data, []byte("unique-chunk-two")...)
+	first := filepath.Join(dir, "first.bin")
+	second := filepath.Join(dir, "second.bin")
+	for _, p := range []string{first, second} {
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			t.Fatalf("failed to write temp file: %v", err)
+		}
+	}
+
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	if err := ss.UploadFile(first); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if len(backend.chunks) != 3 {
+		t.Fatalf("expected 3 distinct objects, got %d", len(backend.chunks))
+	}
+	sum := sha256.Sum256(block)
+	if _, ok := backend.chunks[hex.EncodeToString(sum[:])]; !ok {
+		t.Error("chunk not stored under its SHA-256")
+	}
+
+	if err := ss.UploadFile(second); err != nil {
+		t.Fatalf("UploadFile of identical file failed: %v", err)
+	}
+	if len(backend.chunks) != 3 {
+		t.Fatalf("identical file uploaded new objects: %d", len(backend.chunks))
+	}
+
+	for _, name := range []string{"first.bin", "second.bin"} {
+		var buf bytes.Buffer
+		if err := ss.GetFile(name, &buf); err != nil {
+			t.Fatalf("GetFile(%s) failed: %v", name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("GetFile(%s) data mismatch", name)
+		}
+	}
+
+	if err := ss.DeleteFile("first.bin"); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if len(backend.chunks) != 3 {
+		t.Fatalf("shared objects deleted while still referenced: %d left", len(backend.chunks))
+	}
+	var buf bytes.Buffer
+	if err := ss.GetFile("second.bin", &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("second file unreadable after deleting the first: %v", err)
+	}
+	if err := ss.DeleteFile("second.bin"); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if len(backend.chunks) != 0 {
+		t.Errorf("%d objects left after deleting every file", len(backend.chunks))
+	}
+	if files, _ := metaSvc.ListFiles(); len(files) != 0 {
+		t.Errorf("file metadata left behind: %+v", files)
+	}
+}
+
+func TestUploadFile_Compressed(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 1024)
+	data := bytes.Repeat([]byte("INFO compressible log line\n"), 200)
+	path := writeTempFile(t, data)
+
+	opts := DefaultUploadOptions()
+	opts.Compression = "zstd"
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	stored := 0
+	for _, object := range mocks[0].chunks {
+		stored += len(object)
+	}
+	if stored >= len(data)/2 {
+		t.Errorf("compressed objects take %d bytes for %d bytes of data", stored, len(data))
+	}
+	chunks, _ := metaSvc.ListChunks(filepath.Base(path))
+	if chunks[0].Codec != "zstd" || chunks[0].StoredSize != int64(len(mocks[0].chunks[chunks[0].ChunkName])) {
+		t.Errorf("codec or stored size not recorded: %+v", chunks[0])
+	}
+
+	var buf bytes.Buffer
+	if err := ss.GetFile(filepath.Base(path), &buf); err != nil {
+		t.Fatalf("GetFile failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetFile data mismatch")
+	}
+
+	opts.Compression = "brotli"
+	if err := ss.UploadFileWithOptions(path, opts); !errors.Is(err, errorx.ErrUnknownCodec) {
+		t.Errorf("expected ErrUnknownCodec, got %v", err)
+	}
+}
+
+func TestGetFile_VerifiesChunks(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 2)
+	ss.manager.SetReplication(2, 2)
+	data := bytes.Repeat([]byte("verified-data-"), 8)
+	path := writeTempFile(t, data)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+	chunks, _ := metaSvc.ListChunks(fileName)
+	name := chunks[0].ChunkName
+
+	// A corrupt copy on the first replica is skipped in favour of the second
+	first, second := chunks[0].Replicas[0], chunks[0].Replicas[1]
+	byID := map[string]*mockCloudStorage{mocks[0].id: mocks[0], mocks[1].id: mocks[1]}
+	corrupt := func(m *mockCloudStorage) {
+		object := append([]byte(nil), m.chunks[name]...)
+		object[len(object)-1] ^= 0x01
+		m.chunks[name] = object
+	}
+	corrupt(byID[first])
+	var buf bytes.Buffer
+	if err := ss.GetFile(fileName, &buf); err != nil {
+		t.Fatalf("GetFile failed with one corrupt replica: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("GetFile data mismatch after skipping corrupt replica")
+	}
+
+	corrupt(byID[second])
+	err := ss.GetFile(fileName, &bytes.Buffer{})
+	if !errors.Is(err, errorx.ErrChunkCorrupted) {
+		t.Fatalf("expected ErrChunkCorrupted with every replica corrupt, got %v", err)
+	}
+	var corruptErr *CorruptChunkError
+	if !errors.As(err, &corruptErr) || corruptErr.ChunkName != name || corruptErr.Storage != second {
+		t.Errorf("unexpected corruption details: %+v", corruptErr)
+	}
+
+	// An intact object stored under the wrong name fails the header checks
+	byID[first].chunks[name] = byID[first].chunks[chunks[1].ChunkName]
+	byID[second].chunks[name] = byID[second].chunks[chunks[1].ChunkName]
+	if err := ss.GetFile(fileName, &bytes.Buffer{}); !errors.Is(err, errorx.ErrChunkCorrupted) {
+		t.Errorf("expected ErrChunkCorrupted for misplaced object, got %v", err)
+	}
+}
+
+func TestFileReader_StreamsInOrder(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	var data []byte
+	for i := 0; i < 40; i++ {
+		data = append(data, bytes.Repeat([]byte{byte('A' + i)}, 16)...)
+	}
+	path := writeTempFile(t, data)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+
+	r, err := ss.NewFileReader(fileName)
+	if err != nil {
+		t.Fatalf("NewFileReader failed: %v", err)
+	}
+	got, err := io.ReadAll(iotest.OneByteReader(r))
+	r.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("streamed data mismatch (err %v)", err)
+	}
+
+	// Closing early must release the service so the file can be deleted
+	r, _ = ss.NewFileReader(fileName)
+	if _, err := r.Read(make([]byte, 4)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	r.Close()
+	if _, err := r.Read(make([]byte, 4)); !errors.Is(err, errorx.ErrFileReaderClosed) {
+		t.Errorf("expected ErrFileReaderClosed after Close, got %v", err)
+	}
+
+	// A bad chunk midway ends the stream with its error after the chunks before it
+	chunks, _ := metaSvc.ListChunks(fileName)
+	delete(mocks[0].chunks, chunks[10].ChunkName)
+	r, _ = ss.NewFileReader(fileName)
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err == nil {
+		t.Error("expected an error for a missing chunk")
+	}
+	r.Close()
+	if !bytes.Equal(buf.Bytes(), data[:10*16]) {
+		t.Errorf("wrote %d bytes before the missing chunk, want %d", buf.Len(), 10*16)
+	}
+
+	if err := ss.DeleteFile(fileName); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+}
+
+func TestOpenFile_RangedReads(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	var data []byte
+	for i := 0; i < 10; i++ {
+		data = append(data, bytes.Repeat([]byte{byte('a' + i)}, 16)...)
+	}
+	data = data[:len(data)-3]
+	path := writeTempFile(t, data)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	fileName := filepath.Base(path)
+
+	f, err := ss.OpenFile(fileName)
+	if err != nil {
+		t.Fatalf("OpenFile failed: %v", err)
+	}
+	defer f.Close()
+	if f.Size() != int64(len(data)) {
+		t.Fatalf("Size %d, want %d", f.Size(), len(data))
+	}
+
+	// Only the chunks covering a range are fetched: drop all but the last two
+	chunks, _ := metaSvc.ListChunks(fileName)
+	for _, c := range chunks[:len(chunks)-2] {
+		delete(mocks[0].chunks, c.ChunkName)
+	}
+	buf := make([]byte, 20)
+	n, err := f.ReadAt(buf, int64(len(data)-20))
+	if err != nil || n != 20 || !bytes.Equal(buf, data[len(data)-20:]) {
+		t.Fatalf("ReadAt of the tail returned %d, %v: %q", n, err, buf[:n])
+	}
+	n, err = f.ReadAt(buf, int64(len(data)-5))
+	if n != 5 || err != io.EOF || !bytes.Equal(buf[:n], data[len(data)-5:]) {
+		t.Errorf("short ReadAt returned %d, %v", n, err)
+	}
+	if _, err := f.ReadAt(buf, 0); err == nil {
+		t.Error("expected an error reading a chunk that is gone")
+	}
+	if _, err := f.ReadAt(buf, -1); !errors.Is(err, errorx.ErrInvalidOffset) {
+		t.Errorf("expected ErrInvalidOffset, got %v", err)
+	}
+
+	if pos, err := f.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(data)-10) {
+		t.Fatalf("Seek returned %d, %v", pos, err)
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(rest, data[len(data)-10:]) {
+		t.Errorf("Read after Seek returned %q, %v", rest, err)
+	}
+	if _, err := f.Seek(-1, io.SeekStart); !errors.Is(err, errorx.ErrInvalidOffset) {
+		t.Errorf("expected ErrInvalidOffset for negative seek, got %v", err)
+	}
+
+	if _, err := ss.OpenFile("missing.bin"); !errors.Is(err, errorx.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound, got %v", err)
+	}
+}
+
+func TestUploadFileContext_CancelRollsBack(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	path := writeTempFile(t, erasureTestData())
+	fileName := filepath.Base(path)
+
+	// Cancel once a few chunks are stored; the workers stop and the upload is undone
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mocks[0].onUpload = func() {
+		if mocks[0].uploads == 3 {
+			cancel()
+		}
+	}
+	err := ss.UploadFileWithOptionsContext(ctx, path, DefaultUploadOptions())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if n := len(mocks[0].chunks); n != 0 {
+		t.Errorf("%d objects left on the backend after rollback", n)
+	}
+	if ok, _ := metaSvc.FileExists(fileName); ok {
+		t.Error("file metadata still exists after rollback")
+	}
+	if _, ok := metaSvc.GetUploadSession(fileName); ok {
+		t.Error("upload session still exists after rollback")
+	}
+
+	// A done context fails reads before anything is fetched
+	mocks[0].onUpload = nil
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if err := ss.GetFileContext(ctx, fileName, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from GetFileContext, got %v", err)
+	}
+	f, err := ss.OpenFileContext(ctx, fileName)
+	if err != nil {
+		t.Fatalf("OpenFileContext failed: %v", err)
+	}
+	if _, err := f.ReadAt(make([]byte, 8), 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from ReadAt, got %v", err)
+	}
+	if err := ss.DeleteFileContext(ctx, fileName); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from DeleteFileContext, got %v", err)
+	}
+	if ok, _ := metaSvc.FileExists(fileName); !ok {
+		t.Error("cancelled delete removed the file")
+	}
+}
+
+func TestUploadReaderContext(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 5)
+	data := erasureTestData()
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+
+	// A source returning one byte per Read still fills whole chunks
+	n, err := ss.UploadReaderContext(context.Background(), iotest.OneByteReader(bytes.NewReader(data)), "streams/a.bin", opts)
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("UploadReaderContext = %d, %v", n, err)
+	}
+	meta, ok := metaSvc.GetFile("streams/a.bin")
+	if !ok || meta.TotalSize != int64(len(data)) {
+		t.Fatalf("file metadata = %+v, %v", meta, ok)
+	}
+	mocks[0].failGet = true
+	if got := readVersion(t, ss, "streams/a.bin", 0); !bytes.Equal(got, data) {
+		t.Error("streamed file content mismatch")
+	}
+	mocks[0].failGet = false
+
+	// A failing source rolls the new version back
+	broken := io.MultiReader(bytes.NewReader(data[:100]), iotest.ErrReader(io.ErrClosedPipe))
+	if _, err := ss.UploadReaderContext(context.Background(), broken, "streams/a.bin", opts); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expected the source error, got %v", err)
+	}
+	if versions, _ := metaSvc.ListVersions("streams/a.bin"); len(versions) != 1 {
+		t.Errorf("failed upload left %d versions, want 1", len(versions))
+	}
+	if _, ok := metaSvc.GetUploadSession("streams/a.bin"); ok {
+		t.Error("upload session still exists after rollback")
+	}
+	if _, err := ss.UploadReaderContext(context.Background(), bytes.NewReader(data), "../a.bin", opts); !errors.Is(err, errorx.ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath, got %v", err)
+	}
+}
+
+func TestRenameFile(t *testing.T) {
+	ss, _, metaSvc := setupErasureService(t, 1)
+	opts := UploadOptions{Mode: ModeReplicate}
+	v1, v2 := []byte("first version"), []byte("second version")
+	for _, data := range [][]byte{v1, v2} {
+		if _, err := ss.UploadReaderContext(context.Background(), bytes.NewReader(data), "docs/a.txt", opts); err != nil {
+			t.Fatalf("UploadReaderContext failed: %v", err)
+		}
+	}
+
+	if err := ss.RenameFile("docs/a.txt", "/archive/a.txt"); err != nil {
+		t.Fatalf("RenameFile failed: %v", err)
+	}
+	if got := readVersion(t, ss, "archive/a.txt", 0); !bytes.Equal(got, v2) {
+		t.Errorf("renamed file = %q, want %q", got, v2)
+	}
+	if got := readVersion(t, ss, "archive/a.txt", 1); !bytes.Equal(got, v1) {
+		t.Errorf("renamed version 1 = %q, want %q", got, v1)
+	}
+
+	// A new file under the old name does not collide with the storage names left behind
+	if _, err := ss.UploadReaderContext(context.Background(), bytes.NewReader([]byte("new")), "docs/a.txt", opts); err != nil {
+		t.Fatalf("upload under the old name failed: %v", err)
+	}
+	if err := ss.RenameDir("docs", "notes"); err != nil {
+		t.Fatalf("RenameDir failed: %v", err)
+	}
+	if got := readVersion(t, ss, "notes/a.txt", 0); string(got) != "new" {
+		t.Errorf("notes/a.txt = %q", got)
+	}
+	if got := readVersion(t, ss, "archive/a.txt", 1); !bytes.Equal(got, v1) {
+		t.Errorf("archive/a.txt version 1 = %q after the new upload", got)
+	}
+	if err := ss.DeleteFile("archive/a.txt"); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	if got := readVersion(t, ss, "notes/a.txt", 0); string(got) != "new" {
+		t.Errorf("notes/a.txt = %q after deleting the renamed file", got)
+	}
+	if _, ok := metaSvc.GetFile("archive/a.txt"); ok {
+		t.Error("deleted file still listed")
+	}
+	if err := ss.RenameFile("notes/a.txt", "../a.txt"); !errors.Is(err, errorx.ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath, got %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+type fetchResult struct {
+	data []byte
+	err  error
+}
+
+// FileReader streams a stored file in chunk order. Chunks are fetched in parallel at most
+// Parallel.Download ahead of the reader, so memory stays near that many chunks whatever
+// the file size. A FileReader is not safe for concurrent use and must be closed.
+type FileReader struct {
+	pending   <-chan chan fetchResult // one slot per chunk, in file order
+	done      chan struct{}           // closed by Close to stop prefetching
+	finished  chan struct{}           // closed once every fetch has returned
+	closeOnce sync.Once
+	buf       []byte // unread part of the current chunk
+	err       error  // sticky: io.EOF, a fetch error or ErrFileReaderClosed
+}
+
+// NewFileReader starts streaming fileName. Files are not deleted while a reader is open.
+func (s *StorageService) NewFileReader(fileName string) (*FileReader, error) {
+	return s.NewFileReaderContext(context.Background(), fileName)
+}
+
+// NewFileReaderContext is NewFileReader bounded by ctx: once ctx is done no further
+// chunks are fetched and reads fail with the context's error.
+func (s *StorageService) NewFileReaderContext(ctx context.Context, fileName string) (*FileReader, error) {
+	return s.NewFileVersionReaderContext(ctx, fileName, 0)
+}
+
+// NewFileVersionReaderContext streams a given version of fileName; version 0 is the current one
+func (s *StorageService) NewFileVersionReaderContext(ctx context.Context, fileName string, version int) (*FileReader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	metas, cc, si, err := s.prepareRead(fileName, version)
+	if err != nil {
+		s.lock.RUnlock()
+		return nil, err
+	}
+	window := config.GetConfig().Parallel.Download
+	if window < 1 {
+		window = 1
+	}
+	pending := make(chan chan fetchResult, window)
+	r := &FileReader{pending: pending, done: make(chan struct{}), finished: make(chan struct{})}
+	go s.prefetch(ctx, metas, cc, si, pending, r.done, r.finished)
+	return r, nil
+}
+
+// prepareRead loads what is needed to fetch and decode every chunk of a file version;
+// version 0 is the current one
+func (s *StorageService) prepareRead(fileName string, version int) ([]metadata.ChunkMetadata, chunkCodec, *stripeIndex, error) {
+	ver, ok := s.metaSvc.GetVersion(fileName, version)
+	if !ok {
+		if version != 0 {
+			return nil, chunkCodec{}, nil, errorx.WrapWithDetails(errorx.ErrVersionNotFound, fmt.Sprintf("%s version %d", fileName, version))
+		}
+		// Chunks recorded by AddChunk alone sit under the file name without a version
+		ver = metadata.FileVersion{FileName: fileName, StorageName: fileName}
+		if file, ok := s.metaSvc.GetFile(fileName); ok {
+			ver.WrappedKey = file.WrappedKey
+		}
+	}
+	if sess, ok := s.metaSvc.GetUploadSession(fileName); ok && (ver.Version == 0 || sess.Version == ver.Version) {
+		return nil, chunkCodec{}, nil, errorx.WrapWithDetails(errorx.ErrUploadInProgress, fileName)
+	}
+	metas, err := s.metaSvc.ListChunks(ver.StorageName)
+	if err != nil {
+		return nil, chunkCodec{}, nil, err
+	}
+	stripes, err := s.metaSvc.ListStripes(ver.StorageName)
+	if err != nil {
+		return nil, chunkCodec{}, nil, err
+	}
+	fileCipher, err := s.versionCipher(ver)
+	if err != nil {
+		return nil, chunkCodec{}, nil, err
+	}
+	cc := chunkCodec{cipher: fileCipher}
+	return metas, cc, newStripeIndex(stripes, metas, cc), nil
+}
+
+// prefetch starts one fetch per chunk, blocking while the reorder window is full. It
+// holds the service read lock taken by NewFileReader until every fetch has finished.
+func (s *StorageService) prefetch(ctx context.Context, metas []metadata.ChunkMetadata, cc chunkCodec, si *stripeIndex,
+	pending chan<- chan fetchResult, done <-chan struct{}, finished chan<- struct{}) {
+	var wg sync.WaitGroup
+	defer func() {
+		close(pending)
+		wg.Wait()
+		s.lock.RUnlock()
+		close(finished)
+	}()
+	for _, meta := range metas {
+		res := make(chan fetchResult, 1)
+		select {
+		case pending <- res:
+		case <-done:
+			return
+		case <-ctx.Done():
+			res <- fetchResult{err: ctx.Err()}
+			select {
+			case pending <- res:
+			case <-done:
+			}
+			return
+		}
+		wg.Add(1)
+		go func(meta metadata.ChunkMetadata) {
+			defer wg.Done()
+			defer metrics.TrackWorker(metrics.PoolDownload)()
+			data, err := s.fetchChunk(ctx, meta, cc, si)
+			res <- fetchResult{data: data, err: err}
+		}(meta)
+	}
+}
+
+// next waits for the following chunk in file order
+func (r *FileReader) next() {
+	res, ok := <-r.pending
+	if !ok {
+		r.err = io.EOF
+		return
+	}
+	result := <-res
+	if result.err != nil {
+		r.Close()
+		r.err = result.err
+		return
+	}
+	r.buf = result.data
+}
+
+func (r *FileReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// WriteTo writes the rest of the file to w chunk by chunk, without an intermediate copy
+func (r *FileReader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if len(r.buf) == 0 {
+			if r.err == io.EOF {
+				return total, nil
+			}
+			if r.err != nil {
+				return total, r.err
+			}
+			r.next()
+			continue
+		}
+		n, err := w.Write(r.buf)
+		total += int64(n)
+		r.buf = r.buf[n:]
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Close stops prefetching and waits for the fetches already in flight
+func (r *FileReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		<-r.finished
+		if r.err == nil {
+			r.err = errorx.ErrFileReaderClosed
+		}
+		r.buf = nil
+	})
+	return nil
+}
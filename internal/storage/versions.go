@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/config"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/log"
+	"github.com/sayuyere/storageX/internal/metadata"
+	"github.com/sayuyere/storageX/internal/metrics"
+)
+
+// RetentionPolicy decides which old versions of a file are kept. A version is kept when
+// any rule keeps it, and the current version always is; the zero policy keeps everything.
+type RetentionPolicy struct {
+	KeepLast      int // newest versions kept regardless of age
+	KeepDailyDays int // for this many days back, the newest version of each day is kept
+}
+
+// RetentionPolicyFromConfig converts the configured versioning rules
+func RetentionPolicyFromConfig(cfg config.VersioningConfig) RetentionPolicy {
+	return RetentionPolicy{KeepLast: cfg.KeepLast, KeepDailyDays: cfg.KeepDailyDays}
+}
+
+// expired returns the versions the policy drops at now, given every finished version
+// newest first. Days are calendar days in now's location.
+func (p RetentionPolicy) expired(versions []metadata.FileVersion, now time.Time) []metadata.FileVersion {
+	if p.KeepLast <= 0 && p.KeepDailyDays <= 0 {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -p.KeepDailyDays)
+	days := make(map[string]bool)
+	var drop []metadata.FileVersion
+	for i, ver := range versions {
+		keep := ver.Current || i < p.KeepLast
+		if p.KeepDailyDays > 0 && ver.CreatedAt.After(cutoff) {
+			day := ver.CreatedAt.In(now.Location()).Format(time.DateOnly)
+			if !days[day] {
+				days[day] = true
+				keep = true
+			}
+		}
+		if !keep {
+			drop = append(drop, ver)
+		}
+	}
+	return drop
+}
+
+// PruneVersions deletes the old versions of fileName that policy does not keep, releasing
+// the chunks no other version or file references. It returns the versions deleted.
+func (s *StorageService) PruneVersions(fileName string, policy RetentionPolicy) ([]int, error) {
+	return s.PruneVersionsContext(context.Background(), fileName, policy)
+}
+
+// PruneVersionsContext is PruneVersions bounded by ctx
+func (s *StorageService) PruneVersionsContext(ctx context.Context, fileName string, policy RetentionPolicy) ([]int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pruneVersions(ctx, fileName, policy, time.Now())
+}
+
+func (s *StorageService) pruneVersions(ctx context.Context, fileName string, policy RetentionPolicy, now time.Time) ([]int, error) {
+	versions, err := s.metaSvc.ListVersions(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []int
+	for _, ver := range policy.expired(versions, now) {
+		if err := ctx.Err(); err != nil {
+			return pruned, err
+		}
+		if err := s.releaseVersion(ctx, fileName, ver.Version); err != nil {
+			return pruned, err
+		}
+		log.Info("Pruned version %d of %s", ver.Version, fileName)
+		pruned = append(pruned, ver.Version)
+	}
+	return pruned, nil
+}
+
+// DeleteVersion deletes one version of fileName other than the current one
+func (s *StorageService) DeleteVersion(fileName string, version int) error {
+	return s.DeleteVersionContext(context.Background(), fileName, version)
+}
+
+// DeleteVersionContext is DeleteVersion bounded by ctx
+func (s *StorageService) DeleteVersionContext(ctx context.Context, fileName string, version int) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("delete", 0, start, err) }(time.Now())
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if sess, ok := s.metaSvc.GetUploadSession(fileName); ok && sess.Version == version {
+		return errorx.WrapWithDetails(errorx.ErrUploadInProgress, fileName)
+	}
+	return s.releaseVersion(ctx, fileName, version)
+}
+
+// releaseVersion drops a version's metadata and deletes the objects it alone referenced
+func (s *StorageService) releaseVersion(ctx context.Context, fileName string, version int) error {
+	metas, err := s.metaSvc.ReleaseVersion(fileName, version)
+	if err != nil {
+		return err
+	}
+	if deleteErrs := s.deleteObjects(ctx, metas); len(deleteErrs) > 0 {
+		return errorx.WrapWithDetails(errorx.ErrFileDeleteFailed, fmt.Sprintf("file: %s, version: %d, errors: %v", fileName, version, deleteErrs))
+	}
+	return nil
+}
+
+// RestoreVersion makes an earlier version of fileName current again. Nothing is copied:
+// reads return that version's chunks, and the newer versions are kept until pruned.
+func (s *StorageService) RestoreVersion(fileName string, version int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.metaSvc.SetCurrentVersion(fileName, version)
+}
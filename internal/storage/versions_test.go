@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sayuyere/storageX/internal/chunker"
+	errorx "github.com/sayuyere/storageX/internal/errors"
+	"github.com/sayuyere/storageX/internal/metadata"
+)
+
+func readVersion(t *testing.T, ss *StorageService, fileName string, version int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := ss.GetFileVersionContext(context.Background(), fileName, version, &buf); err != nil {
+		t.Fatalf("reading version %d of %s: %v", version, fileName, err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadFile_Versions(t *testing.T) {
+	ss, mocks, metaSvc := setupErasureService(t, 1)
+	ss.chunker = chunker.NewFileChunker(chunker.ChunkMetadataSize + 16)
+	v1 := resumeTestData()
+	v2 := append(bytes.Clone(v1[:64]), bytes.Repeat([]byte{'z'}, 40)...)
+	path := writeTempFile(t, v1)
+	fileName := filepath.Base(path)
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	stored := len(mocks[0].chunks)
+	if err := os.WriteFile(path, v2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("uploading a second version failed: %v", err)
+	}
+	// The first four chunks are shared with version 1, and the two full "z" chunks with each other
+	if got := len(mocks[0].chunks) - stored; got != 2 {
+		t.Errorf("second version stored %d new objects, want 2", got)
+	}
+
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, v2) {
+		t.Error("current version is not the latest upload")
+	}
+	if got := readVersion(t, ss, fileName, 1); !bytes.Equal(got, v1) {
+		t.Error("version 1 content changed")
+	}
+	versions, err := metaSvc.ListVersions(fileName)
+	if err != nil || len(versions) != 2 || versions[0].Version != 2 || !versions[0].Current || versions[1].TotalSize != int64(len(v1)) {
+		t.Fatalf("ListVersions = %+v, %v", versions, err)
+	}
+	if err := ss.GetFileVersionContext(context.Background(), fileName, 7, &bytes.Buffer{}); !errors.Is(err, errorx.ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+
+	if err := ss.RestoreVersion(fileName, 1); err != nil {
+		t.Fatalf("RestoreVersion failed: %v", err)
+	}
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, v1) {
+		t.Error("restored version is not current")
+	}
+	if err := ss.DeleteVersion(fileName, 1); !errors.Is(err, errorx.ErrVersionIsCurrent) {
+		t.Errorf("expected ErrVersionIsCurrent, got %v", err)
+	}
+
+	// A failed upload leaves the versions as they were
+	if err := os.WriteFile(path, bytes.Repeat([]byte{'q'}, 32), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mocks[0].failUpload = true
+	if err := ss.UploadFile(path); err == nil {
+		t.Fatal("upload to a failing backend succeeded")
+	}
+	mocks[0].failUpload = false
+	if versions, _ := metaSvc.ListVersions(fileName); len(versions) != 2 {
+		t.Errorf("failed upload left %d versions, want 2", len(versions))
+	}
+
+	// Version 3 has the content of version 1 and stores nothing new. Keeping only the
+	// newest version releases the objects version 2 alone referenced.
+	if err := os.WriteFile(path, v1, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.UploadFile(path); err != nil {
+		t.Fatalf("uploading a third version failed: %v", err)
+	}
+	pruned, err := ss.PruneVersions(fileName, RetentionPolicy{KeepLast: 1})
+	if err != nil || !reflect.DeepEqual(pruned, []int{2, 1}) {
+		t.Fatalf("PruneVersions = %v, %v", pruned, err)
+	}
+	if len(mocks[0].chunks) != stored {
+		t.Errorf("%d objects left after pruning, want %d", len(mocks[0].chunks), stored)
+	}
+	if got := readVersion(t, ss, fileName, 0); !bytes.Equal(got, v1) {
+		t.Error("pruning changed the current version")
+	}
+}
+
+func TestUploadFile_ErasureVersions(t *testing.T) {
+	ss, mocks, _ := setupErasureService(t, 5)
+	opts := UploadOptions{Mode: ModeErasure, DataShards: 3, ParityShards: 2}
+	v1 := erasureTestData()
+	v2 := bytes.ToUpper(v1)
+	path := writeTempFile(t, v1)
+	fileName := filepath.Base(path)
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("UploadFileWithOptions failed: %v", err)
+	}
+	if err := os.WriteFile(path, v2, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.UploadFileWithOptions(path, opts); err != nil {
+		t.Fatalf("uploading a second version failed: %v", err)
+	}
+
+	// Lose two backends: both versions must still rebuild from their own parity
+	mocks[0].failGet, mocks[1].failGet = true, true
+	if got := readVersion(t, ss, fileName, 1); !bytes.Equal(got, v1) {
+		t.Error("version 1 content mismatch")
+	}
+	if got := readVersion(t, ss, fileName, 2); !bytes.Equal(got, v2) {
+		t.Error("version 2 content mismatch")
+	}
+	mocks[0].failGet, mocks[1].failGet = false, false
+
+	if err := ss.DeleteFile(fileName); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	for _, m := range mocks {
+		if len(m.chunks) != 0 {
+			t.Errorf("%s still holds %d objects after deleting every version", m.id, len(m.chunks))
+		}
+	}
+}
+
+func TestRetentionPolicy_Expired(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	at := func(days, hours int) time.Time {
+		return now.AddDate(0, 0, -days).Add(-time.Duration(hours) * time.Hour)
+	}
+	// Newest first, as ListVersions returns them; version 5 was restored
+	versions := []metadata.FileVersion{
+		{Version: 6, CreatedAt: at(0, 1)},
+		{Version: 5, CreatedAt: at(0, 2), Current: true},
+		{Version: 4, CreatedAt: at(1, 1)},
+		{Version: 3, CreatedAt: at(1, 2)},
+		{Version: 2, CreatedAt: at(3, 0)},
+		{Version: 1, CreatedAt: at(30, 0)},
+	}
+	for _, tc := range []struct {
+		policy RetentionPolicy
+		want   []int
+	}{
+		{RetentionPolicy{}, nil},
+		{RetentionPolicy{KeepLast: 1}, []int{4, 3, 2, 1}},
+		{RetentionPolicy{KeepDailyDays: 7}, []int{3, 1}},
+		{RetentionPolicy{KeepLast: 4, KeepDailyDays: 2}, []int{2, 1}},
+	} {
+		var got []int
+		for _, ver := range tc.policy.expired(versions, now) {
+			got = append(got, ver.Version)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%+v pruned %v, want %v", tc.policy, got, tc.want)
+		}
+	}
+}